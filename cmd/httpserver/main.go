@@ -3,25 +3,74 @@ package main
 import (
 	"log"
 	"os"
-	"io"
 	"os/signal"
-	"net/http"
 	"syscall"
-	"strings"
-	"fmt"
-	"crypto/sha256"
 	"strconv"
-	"encoding/hex"
+	"strings"
+	"time"
+	"net/url"
 
+	"github.com/WaronLimsakul/learn_http/internal/cache"
+	"github.com/WaronLimsakul/learn_http/internal/client"
+	"github.com/WaronLimsakul/learn_http/internal/proxy"
 	"github.com/WaronLimsakul/learn_http/internal/server"
+	"github.com/WaronLimsakul/learn_http/internal/sse"
 	"github.com/WaronLimsakul/learn_http/internal/request"
 	"github.com/WaronLimsakul/learn_http/internal/response"
+	"github.com/WaronLimsakul/learn_http/internal/websocket"
 )
 
 const port = 42069
 
+// only used when TLS_CERT and TLS_KEY point at a certificate and its key
+const tlsPort = 42443
+
+// decoded request bodies can't grow past this
+const maxBodySize = 10 << 20
+
+// keeps connections to httpbin open between proxied requests
+var upstreamClient = &client.Client{
+	MaxIdlePerHost: 8,
+	IdleTimeout: 30 * time.Second,
+}
+
+// cacheable httpbin responses (e.g. /httpbin/cache/60) are served from here
+const cacheBudget = 32 << 20
+
+// a proxied request that takes longer gets a 504
+const upstreamTimeout = 30 * time.Second
+
+// /httpbin/anything -> https://httpbin.org/anything
+var httpbinProxy = &proxy.Proxy{
+	Transport: cache.New(&proxy.Upstream{
+		URL: &url.URL{Scheme: "https", Host: "httpbin.org"},
+		Client: upstreamClient,
+	}, cacheBudget),
+	Rewrites: []proxy.Rewrite{{Prefix: "/httpbin", Replacement: ""}},
+	ChecksumTrailers: true,
+}
+
 func main() {
-	server, err := server.Serve(port, reqHandler)
+	handler := server.DecompressRequests(maxBodySize, reqHandler)
+	plainHandler := handler
+
+	// HTTPS too, when we were given a certificate. Then the plain port
+	// only sends people over to it.
+	certFile, keyFile := os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY")
+	if certFile != "" && keyFile != "" {
+		hsts := server.HSTSPolicy{MaxAge: server.DefaultHSTSMaxAge}
+		tlsServer, err := server.ServeTLS(tlsPort, server.HSTS(hsts, handler), &server.TLSConfig{
+			Certificates: []server.CertFiles{{CertFile: certFile, KeyFile: keyFile}},
+		})
+		if err != nil {
+			log.Fatalf("Error start serving tls: %v\n", err)
+		}
+		defer tlsServer.Close()
+		log.Println("TLS server started on port ", tlsPort)
+		plainHandler = (&server.HTTPSRedirect{Port: tlsPort}).Handle
+	}
+
+	server, err := server.Serve(port, plainHandler)
 	if err != nil {
 		log.Fatalf("Error start serving: %v\n", err)
 	}
@@ -40,7 +89,7 @@ func main() {
 
 func reqHandler(w *response.Writer, req *request.Request) {
 	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-		proxyHandler(w, req)
+		server.Timeout(upstreamTimeout, httpbinProxy.Handle)(w, req)
 		return
 	}
 
@@ -54,71 +103,23 @@ func reqHandler(w *response.Writer, req *request.Request) {
 		case "/video":
 			handleGetVideo(w, req)
 			return
+		case "/ws":
+			handleWebSocket(w, req)
+			return
+		case "/events":
+			handleEvents(w, req)
+			return
 	}
 
 	handle200(w, req)
 
 }
 
-func proxyHandler(w *response.Writer, req *request.Request) {
-	httpbinTarget := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin")
-	urlTarget := "https://httpbin.org" + httpbinTarget
-
-
-	headers := response.GetDefaultHeaders(0)
-	binResp, err := http.Get(urlTarget)
-	if err != nil {
-		handle500(w, req)
-		return
-	}
-
-	headers.Set("Trailer", "X-Content-SHA256")
-	headers.Set("Trailer", "X-Content-Length")
-
-	defer binResp.Body.Close()
-	w.WriteStatusLine(200)
-	headers.Delete("Content-Length")
-	headers.Set("Transfer-Encoding", "chunked")
-	w.WriteHeaders(headers)
-
-	buffer := make([]byte, 1024)
-	fullBody := []byte{}
-	for  {
-		n, err := binResp.Body.Read(buffer)
-		fmt.Println("n: ", n)
-		if n > 0 {
-			_, err := w.WriteChunkedBody(buffer[:n]) // when .Read(), it fill from start to n-1 bytes
-			if err != nil {
-				fmt.Println("error writing chunked body:", err)
-				break
-			}
-			fullBody = append(fullBody, buffer[:n]...)
-		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			fmt.Println("error reading from response body:", err)
-			break
-		}
-	}
-	_, err = w.WriteChunkedBodyDone()
-	if err != nil {
-		fmt.Println("error writing last chunked body:", err)
-	}
-
-	hashedBody := sha256.Sum256(fullBody)
-	headers.Set("X-Content-SHA256", hex.EncodeToString(hashedBody[:]))
-	headers.Set("X-Content-Length", strconv.Itoa(len(fullBody)))
-
-	err = w.WriteTrailers(headers)
-	if err != nil {
-		fmt.Println("error writing trailers:", err)
-	}
-}
-
 func handle200(w *response.Writer, req *request.Request) {
-	w.WriteStatusLine(200)
+	acceptEncoding, _ := req.Headers.Get("Accept-Encoding")
+	cw := response.NewCompressWriter(w, acceptEncoding)
+	cw.Head = req.RequestLine.Method == "HEAD"
+	cw.WriteStatusLine(200)
 	msg := []byte(
 `<html>
   <head>
@@ -132,8 +133,9 @@ func handle200(w *response.Writer, req *request.Request) {
 `)
 	headers := response.GetDefaultHeaders(len(msg))
 	headers.Reset("Content-Type", "text/html")
-	w.WriteHeaders(headers)
-	w.WriteBody(msg)
+	cw.WriteHeaders(headers)
+	cw.Write(msg)
+	cw.Close()
 }
 
 func handle500(w *response.Writer, req *request.Request) {
@@ -187,3 +189,47 @@ func  handleGetVideo(w *response.Writer, req *request.Request) {
 	w.WriteHeaders(headers)
 	w.WriteBody(video)
 }
+
+// echoes every message back until the client closes
+func handleWebSocket(w *response.Writer, req *request.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, req)
+	if err != nil {
+		log.Printf("websocket handshake failed: %v", err)
+		return
+	}
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(messageType, data); err != nil {
+			conn.Close(websocket.CloseInternalError, "")
+			return
+		}
+	}
+}
+
+// the time every second, picking the count up where a reconnect left off
+func handleEvents(w *response.Writer, req *request.Request) {
+	stream, err := sse.NewStream(w, req, sse.DefaultHeartbeat)
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+	count, _ := strconv.Atoi(stream.LastEventID())
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Done():
+			return
+		case now := <-ticker.C:
+			count++
+			stream.Send(sse.Event{
+				Event: "tick",
+				ID:    strconv.Itoa(count),
+				Data:  now.Format(time.RFC3339),
+			})
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WaronLimsakul/learn_http/internal/client"
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+	"github.com/WaronLimsakul/learn_http/internal/proxy"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// A shared HTTP cache (RFC 9111) that sits between a proxy.Proxy and its
+// real Transport. Fresh hits never reach the upstream, stale entries are
+// revalidated with a conditional request.
+//
+// Every response gets X-Cache: HIT, MISS, REVALIDATED or BYPASS, and
+// responses served from the cache get an Age.
+type Cache struct {
+	Next proxy.Transport
+	// responses bigger than this are passed through but not stored
+	MaxEntrySize int
+
+	mu    sync.Mutex
+	store *lru
+	now   func() time.Time
+}
+
+// budget is the total bytes of bodies + headers we keep around.
+func New(next proxy.Transport, budget int) *Cache {
+	return &Cache{
+		Next:         next,
+		MaxEntrySize: budget / 8,
+		store:        newLRU(budget),
+		now:          time.Now,
+	}
+}
+
+// One stored response.
+type entry struct {
+	varyValues   map[string]string // request headers named by Vary
+	statusCode   response.StatusCode
+	reason       string
+	headers      headers.Headers
+	trailers     headers.Headers
+	body         []byte
+	requestTime  time.Time
+	responseTime time.Time
+	size         int
+}
+
+// Status codes we may store without being told to (RFC 9110 15.1).
+var heuristicallyCacheable = map[response.StatusCode]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// Not much point in trusting Last-Modified for longer than this.
+const maxHeuristicLifetime = 24 * time.Hour
+
+func (c *Cache) RoundTrip(req *request.Request) (*client.Response, error) {
+	method := req.RequestLine.Method
+	key := cacheKey(req)
+
+	if method != "GET" && method != "HEAD" {
+		res, err := c.Next.RoundTrip(req)
+		// a successful unsafe request changes the resource (RFC 9111 4.4)
+		if err == nil && !safeMethod(method) && res.StatusCode < 400 {
+			c.mu.Lock()
+			c.store.remove(key)
+			c.mu.Unlock()
+		}
+		return res, err
+	}
+
+	reqCC := ParseCacheControl(req.Headers["cache-control"])
+	if reqCC.Has("no-store") {
+		return c.forward(req, "BYPASS")
+	}
+
+	c.mu.Lock()
+	e := c.lookup(key, req)
+	c.mu.Unlock()
+	if e == nil {
+		return c.fetch(req, key, "MISS")
+	}
+
+	now := c.now()
+	age := e.currentAge(now)
+	pragma, _ := req.Headers.Get("Pragma")
+	wantsRevalidation := reqCC.Has("no-cache") || strings.Contains(pragma, "no-cache")
+	if !wantsRevalidation && e.fresh(age, reqCC) {
+		if e.notModified(req) {
+			return e.toNotModified(age, "HIT"), nil
+		}
+		return e.toResponse(age, "HIT", method), nil
+	}
+	if e.hasValidators() {
+		return c.revalidate(req, key, e)
+	}
+	return c.fetch(req, key, "MISS")
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+func cacheKey(req *request.Request) string {
+	host, _ := req.Headers.Get("Host")
+	return host + req.RequestLine.RequestTarget
+}
+
+// Find the variant whose Vary'd request headers match this request.
+func (c *Cache) lookup(key string, req *request.Request) *entry {
+	b := c.store.get(key)
+	if b == nil {
+		return nil
+	}
+	for _, e := range b.variants {
+		if matchesVary(e, req) {
+			return e
+		}
+	}
+	return nil
+}
+
+func matchesVary(e *entry, req *request.Request) bool {
+	for name, val := range e.varyValues {
+		if req.Headers[name] != val {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Cache) forward(req *request.Request, status string) (*client.Response, error) {
+	res, err := c.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Headers.Reset("X-Cache", status)
+	return res, nil
+}
+
+// Go to the upstream and store the answer (if we may) while it streams by.
+func (c *Cache) fetch(req *request.Request, key, status string) (*client.Response, error) {
+	requestTime := c.now()
+	// the upstream may rewrite target and Host, keep ours for Vary
+	res, err := c.Next.RoundTrip(req.Clone())
+	if err != nil {
+		return nil, err
+	}
+	c.record(req, key, res, requestTime, c.now())
+	res.Headers.Reset("X-Cache", status)
+	return res, nil
+}
+
+// Arrange for res to be stored once its body has streamed through.
+func (c *Cache) record(req *request.Request, key string, res *client.Response, requestTime, responseTime time.Time) {
+	if req.RequestLine.Method == "GET" && storable(req, res) {
+		e := &entry{
+			varyValues:   varyValues(req, res),
+			statusCode:   res.StatusCode,
+			reason:       res.Reason,
+			headers:      res.Headers.Clone(),
+			requestTime:  requestTime,
+			responseTime: responseTime,
+		}
+		if e.hasValidators() || e.freshnessLifetime() > 0 {
+			res.Body = &recordingBody{
+				ReadCloser: res.Body,
+				limit:      c.MaxEntrySize,
+				done: func(body []byte) {
+					e.body = body
+					e.trailers = res.Trailers.Clone()
+					e.size = len(body) + headersSize(e.headers) + headersSize(e.trailers)
+					c.mu.Lock()
+					c.store.add(key, e)
+					c.mu.Unlock()
+				},
+			}
+		}
+	}
+}
+
+// Ask the upstream whether our stale copy is still good (RFC 9111 4.3).
+func (c *Cache) revalidate(req *request.Request, key string, e *entry) (*client.Response, error) {
+	// our validators, not the client's: the 304 has to be about our copy
+	conditional := req.Clone()
+	conditional.Headers.Delete("If-None-Match")
+	conditional.Headers.Delete("If-Modified-Since")
+	if etag, ok := e.headers.Get("ETag"); ok {
+		conditional.Headers.Reset("If-None-Match", etag)
+	}
+	if lastModified, ok := e.headers.Get("Last-Modified"); ok {
+		conditional.Headers.Reset("If-Modified-Since", lastModified)
+	}
+
+	requestTime := c.now()
+	res, err := c.Next.RoundTrip(conditional)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 304 {
+		// the upstream sent a whole new response, treat it like a miss
+		c.record(req, key, res, requestTime, c.now())
+		res.Headers.Reset("X-Cache", "MISS")
+		return res, nil
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	responseTime := c.now()
+	if !e.validatedBy(res.Headers) {
+		// the 304 vouches for some other copy, ours is no good anymore
+		return c.fetch(req, key, "MISS")
+	}
+
+	// 304 headers update the stored ones, the body stays (RFC 9111 4.3.4)
+	c.mu.Lock()
+	updated := *e
+	updated.headers = e.headers.Clone()
+	for name, val := range res.Headers {
+		switch name {
+		case "content-length", "transfer-encoding", "connection", "x-cache":
+			continue
+		}
+		updated.headers[name] = val
+	}
+	updated.requestTime = requestTime
+	updated.responseTime = responseTime
+	updated.size = len(updated.body) + headersSize(updated.headers) + headersSize(updated.trailers)
+	c.store.add(key, &updated)
+	c.mu.Unlock()
+
+	age := updated.currentAge(c.now())
+	if updated.notModified(req) {
+		return updated.toNotModified(age, "REVALIDATED"), nil
+	}
+	return updated.toResponse(age, "REVALIDATED", req.RequestLine.Method), nil
+}
+
+// Whether a 304 is about the copy we hold: its validator, if it sends one,
+// has to be ours (RFC 9111 4.3.4).
+func (e *entry) validatedBy(h headers.Headers) bool {
+	if etag, ok := h.Get("ETag"); ok {
+		ours, _ := e.headers.Get("ETag")
+		return etag == ours
+	}
+	if lastModified, ok := h.Get("Last-Modified"); ok {
+		ours, _ := e.headers.Get("Last-Modified")
+		return lastModified == ours
+	}
+	return true
+}
+
+// Whether the client's own conditional says it already has our copy
+// (RFC 9110 13.1.2, 13.1.3). If-None-Match wins when both are sent.
+func (e *entry) notModified(req *request.Request) bool {
+	if inm, ok := req.Headers.Get("If-None-Match"); ok {
+		etag, ok := e.headers.Get("ETag")
+		if !ok {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			// weak comparison, W/"x" and "x" are the same here
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if since, ok := req.Headers.Get("If-Modified-Since"); ok {
+		lastModified, ok := e.headers.Get("Last-Modified")
+		if !ok {
+			return false
+		}
+		sinceTime, validSince := parseHTTPDate(since)
+		modified, validModified := parseHTTPDate(lastModified)
+		return validSince && validModified && !modified.After(sinceTime)
+	}
+	return false
+}
+
+// Rules for what a shared cache may keep (RFC 9111 3).
+func storable(req *request.Request, res *client.Response) bool {
+	if !heuristicallyCacheable[res.StatusCode] {
+		return false
+	}
+	resCC := ParseCacheControl(res.Headers["cache-control"])
+	if resCC.Has("no-store") || resCC.Has("private") {
+		return false
+	}
+	if vary, ok := res.Headers.Get("Vary"); ok && strings.TrimSpace(vary) == "*" {
+		return false
+	}
+	// a cookie is for whoever asked, not for everyone after them
+	if len(res.Cookies) > 0 {
+		return false
+	}
+	// someone else's credentials, unless the upstream says it's fine to share
+	if _, ok := req.Headers.Get("Authorization"); ok {
+		if !resCC.Has("public") && !resCC.Has("s-maxage") && !resCC.Has("must-revalidate") {
+			return false
+		}
+	}
+	return true
+}
+
+func varyValues(req *request.Request, res *client.Response) map[string]string {
+	values := map[string]string{}
+	vary, ok := res.Headers.Get("Vary")
+	if !ok {
+		return values
+	}
+	for _, name := range strings.Split(vary, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			values[name] = req.Headers[name]
+		}
+	}
+	return values
+}
+
+func (e *entry) hasValidators() bool {
+	_, etag := e.headers.Get("ETag")
+	_, lastModified := e.headers.Get("Last-Modified")
+	return etag || lastModified
+}
+
+// How long the response stays fresh after it was generated (RFC 9111 4.2.1).
+func (e *entry) freshnessLifetime() time.Duration {
+	cc := ParseCacheControl(e.headers["cache-control"])
+	if cc.Has("no-cache") {
+		return 0
+	}
+	if lifetime, ok := cc.Seconds("s-maxage"); ok {
+		return lifetime
+	}
+	if lifetime, ok := cc.Seconds("max-age"); ok {
+		return lifetime
+	}
+	date := e.date()
+	if expires, ok := e.headers.Get("Expires"); ok {
+		expiresAt, valid := parseHTTPDate(expires)
+		if !valid {
+			// "0" and friends mean already expired
+			return 0
+		}
+		return max(expiresAt.Sub(date), 0)
+	}
+	// heuristic: 10% of how long it went unmodified (RFC 9111 4.2.2)
+	if lastModified, ok := e.headers.Get("Last-Modified"); ok {
+		if modified, valid := parseHTTPDate(lastModified); valid && date.After(modified) {
+			return min(date.Sub(modified)/10, maxHeuristicLifetime)
+		}
+	}
+	return 0
+}
+
+func (e *entry) date() time.Time {
+	if date, ok := e.headers.Get("Date"); ok {
+		if t, valid := parseHTTPDate(date); valid {
+			return t
+		}
+	}
+	return e.responseTime
+}
+
+// RFC 9111 4.2.3
+func (e *entry) currentAge(now time.Time) time.Duration {
+	apparentAge := max(e.responseTime.Sub(e.date()), 0)
+	ageValue := time.Duration(0)
+	if age, ok := e.headers.Get("Age"); ok {
+		if secs, err := strconv.ParseInt(age, 10, 64); err == nil && secs > 0 {
+			ageValue = time.Duration(secs) * time.Second
+		}
+	}
+	responseDelay := e.responseTime.Sub(e.requestTime)
+	correctedInitialAge := max(apparentAge, ageValue+responseDelay)
+	residentTime := now.Sub(e.responseTime)
+	return correctedInitialAge + residentTime
+}
+
+func (e *entry) fresh(age time.Duration, reqCC Directives) bool {
+	lifetime := e.freshnessLifetime()
+	if maxAge, ok := reqCC.Seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := reqCC.Seconds("min-fresh"); ok && lifetime-age < minFresh {
+		return false
+	}
+	return age < lifetime
+}
+
+func (e *entry) toResponse(age time.Duration, status, method string) *client.Response {
+	h := e.headers.Clone()
+	h.Reset("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	h.Reset("X-Cache", status)
+	body := e.body
+	if method == "HEAD" {
+		body = nil
+	}
+	return &client.Response{
+		HttpVersion: "1.1",
+		StatusCode:  e.statusCode,
+		Reason:      e.reason,
+		Headers:     h,
+		Trailers:    e.trailers.Clone(),
+		Body:        io.NopCloser(bytes.NewReader(body)),
+	}
+}
+
+// The client's copy is good, it gets our headers without a body.
+func (e *entry) toNotModified(age time.Duration, status string) *client.Response {
+	res := e.toResponse(age, status, "HEAD")
+	res.StatusCode = 304
+	res.Reason = response.StatusText(304)
+	for _, name := range []string{"Content-Length", "Content-Type", "Content-Encoding", "Content-Language"} {
+		res.Headers.Delete(name)
+	}
+	return res
+}
+
+func headersSize(h headers.Headers) int {
+	total := 0
+	for key, val := range h {
+		total += len(key) + len(val) + 4 // ": " and crlf
+	}
+	return total
+}
+
+// Keeps a copy of the body as it streams to the client, and hands it over
+// once the upstream is done. Gives up quietly past limit.
+type recordingBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	limit    int
+	tooBig   bool
+	finished bool
+	done     func(body []byte)
+}
+
+func (r *recordingBody) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if !r.tooBig {
+		r.buf.Write(p[:n])
+		if r.buf.Len() > r.limit {
+			r.tooBig = true
+			r.buf = bytes.Buffer{}
+		}
+	}
+	if err == io.EOF && !r.tooBig && !r.finished {
+		r.finished = true
+		r.done(r.buf.Bytes())
+	}
+	return n, err
+}
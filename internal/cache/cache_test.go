@@ -0,0 +1,304 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/client"
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// upstream stand-in: answers with whatever respond builds, remembers requests
+type fakeUpstream struct {
+	requests []*request.Request
+	respond  func(req *request.Request) (response.StatusCode, map[string]string, string)
+}
+
+func (f *fakeUpstream) RoundTrip(req *request.Request) (*client.Response, error) {
+	f.requests = append(f.requests, req)
+	code, hdrs, body := f.respond(req)
+	h := headers.NewHeaders()
+	var cookies []string
+	for key, val := range hdrs {
+		if key == "Set-Cookie" {
+			cookies = append(cookies, val)
+			continue
+		}
+		h.Set(key, val)
+	}
+	return &client.Response{
+		HttpVersion: "1.1",
+		StatusCode:  code,
+		Headers:     h,
+		Cookies:     cookies,
+		Trailers:    headers.NewHeaders(),
+		Body:        io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func formatHTTPDate(t time.Time) string {
+	return t.UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestCache(upstream *fakeUpstream, budget int) (*Cache, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	c := New(upstream, budget)
+	c.now = clock.Now
+	return c, clock
+}
+
+func get(t *testing.T, c *Cache, target string, hdrs map[string]string) (*client.Response, string) {
+	t.Helper()
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	req.Headers.Set("Host", "example.test")
+	for key, val := range hdrs {
+		req.Headers.Set(key, val)
+	}
+	res, err := c.RoundTrip(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	return res, string(body)
+}
+
+func TestParseCacheControl(t *testing.T) {
+	d := ParseCacheControl(`max-age=60, No-Cache, private="set-cookie, x-a", s-maxage="120"`)
+	assert.Equal(t, "60", d["max-age"])
+	assert.True(t, d.Has("no-cache"))
+	assert.Equal(t, "set-cookie, x-a", d["private"])
+	lifetime, ok := d.Seconds("s-maxage")
+	assert.True(t, ok)
+	assert.Equal(t, 120*time.Second, lifetime)
+
+	lifetime, ok = ParseCacheControl("max-age=soon").Seconds("max-age")
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), lifetime)
+}
+
+func TestCacheFreshHit(t *testing.T) {
+	upstream := &fakeUpstream{respond: func(req *request.Request) (response.StatusCode, map[string]string, string) {
+		return 200, map[string]string{"Cache-Control": "max-age=60"}, "hello"
+	}}
+	c, clock := newTestCache(upstream, 1<<20)
+
+	res, body := get(t, c, "/a", nil)
+	assert.Equal(t, "MISS", res.Headers["x-cache"])
+	assert.Equal(t, "hello", body)
+
+	clock.now = clock.now.Add(10 * time.Second)
+	res, body = get(t, c, "/a", nil)
+	assert.Equal(t, "HIT", res.Headers["x-cache"])
+	assert.Equal(t, "10", res.Headers["age"])
+	assert.Equal(t, "hello", body)
+	assert.Len(t, upstream.requests, 1)
+
+	// Test: client asks for something fresher than we have
+	res, _ = get(t, c, "/a", map[string]string{"Cache-Control": "max-age=5"})
+	assert.Equal(t, "MISS", res.Headers["x-cache"])
+	assert.Len(t, upstream.requests, 2)
+
+	// Test: expired without validators is just a miss
+	clock.now = clock.now.Add(2 * time.Minute)
+	res, _ = get(t, c, "/a", nil)
+	assert.Equal(t, "MISS", res.Headers["x-cache"])
+
+	// Test: no-store from the client skips us entirely
+	res, _ = get(t, c, "/a", map[string]string{"Cache-Control": "no-store"})
+	assert.Equal(t, "BYPASS", res.Headers["x-cache"])
+}
+
+func TestCacheRevalidation(t *testing.T) {
+	upstream := &fakeUpstream{respond: func(req *request.Request) (response.StatusCode, map[string]string, string) {
+		if inm, _ := req.Headers.Get("If-None-Match"); inm == `"v1"` {
+			return 304, map[string]string{"Cache-Control": "max-age=30", "ETag": `"v1"`, "X-Fresh": "yes"}, ""
+		}
+		return 200, map[string]string{"Cache-Control": "max-age=30", "ETag": `"v1"`}, "body v1"
+	}}
+	c, clock := newTestCache(upstream, 1<<20)
+
+	get(t, c, "/r", nil)
+	clock.now = clock.now.Add(time.Minute)
+	res, body := get(t, c, "/r", nil)
+	assert.Equal(t, "REVALIDATED", res.Headers["x-cache"])
+	assert.Equal(t, "body v1", body)
+	assert.Equal(t, "yes", res.Headers["x-fresh"])
+	assert.Equal(t, `"v1"`, upstream.requests[1].Headers["if-none-match"])
+
+	// the 304 made it fresh again
+	res, _ = get(t, c, "/r", nil)
+	assert.Equal(t, "HIT", res.Headers["x-cache"])
+	assert.Len(t, upstream.requests, 2)
+
+	// Test: no-cache from the client always revalidates
+	res, _ = get(t, c, "/r", map[string]string{"Cache-Control": "no-cache"})
+	assert.Equal(t, "REVALIDATED", res.Headers["x-cache"])
+	assert.Len(t, upstream.requests, 3)
+}
+
+func TestCacheRevalidationClientValidators(t *testing.T) {
+	version := `"v1"`
+	// answers 304 to any validator when lying, like a confused upstream
+	lying := false
+	upstream := &fakeUpstream{respond: func(req *request.Request) (response.StatusCode, map[string]string, string) {
+		hdrs := map[string]string{"Cache-Control": "max-age=30", "ETag": version}
+		if inm := req.Headers["if-none-match"]; inm != "" && (inm == version || lying) {
+			return 304, hdrs, ""
+		}
+		return 200, hdrs, "body " + strings.Trim(version, `"`)
+	}}
+	c, clock := newTestCache(upstream, 1<<20)
+	get(t, c, "/r", nil)
+
+	// Test: the client has some other copy, we still ask about ours
+	clock.now = clock.now.Add(time.Minute)
+	res, body := get(t, c, "/r", map[string]string{"If-None-Match": `"v2"`})
+	assert.Equal(t, `"v1"`, upstream.requests[1].Headers["if-none-match"])
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.Equal(t, "REVALIDATED", res.Headers["x-cache"])
+	assert.Equal(t, `"v1"`, res.Headers["etag"])
+	assert.Equal(t, "body v1", body)
+
+	// Test: the client has our copy, it gets a 304 of its own
+	clock.now = clock.now.Add(time.Minute)
+	res, body = get(t, c, "/r", map[string]string{"If-None-Match": `"v0", W/"v1"`})
+	assert.Equal(t, response.StatusCode(304), res.StatusCode)
+	assert.Equal(t, "REVALIDATED", res.Headers["x-cache"])
+	assert.Equal(t, `"v1"`, res.Headers["etag"])
+	assert.Empty(t, body)
+	res, _ = get(t, c, "/r", map[string]string{"If-None-Match": `"v1"`})
+	assert.Equal(t, response.StatusCode(304), res.StatusCode)
+	assert.Equal(t, "HIT", res.Headers["x-cache"])
+	assert.Len(t, upstream.requests, 3)
+
+	// Test: a 304 about another copy doesn't refresh ours
+	version, lying = `"v2"`, true
+	clock.now = clock.now.Add(time.Minute)
+	res, body = get(t, c, "/r", nil)
+	assert.Equal(t, "MISS", res.Headers["x-cache"])
+	assert.Equal(t, "body v2", body)
+	res, body = get(t, c, "/r", nil)
+	assert.Equal(t, "HIT", res.Headers["x-cache"])
+	assert.Equal(t, `"v2"`, res.Headers["etag"])
+	assert.Equal(t, "body v2", body)
+}
+
+func TestCacheExpiresAndAge(t *testing.T) {
+	var clock *fakeClock
+	upstream := &fakeUpstream{respond: func(req *request.Request) (response.StatusCode, map[string]string, string) {
+		return 200, map[string]string{
+			"Date":    formatHTTPDate(clock.now),
+			"Expires": formatHTTPDate(clock.now.Add(100 * time.Second)),
+			// an upstream cache already held it for a while
+			"Age": "90",
+		}, "x"
+	}}
+	c, clock := newTestCache(upstream, 1<<20)
+
+	get(t, c, "/e", nil)
+	clock.now = clock.now.Add(5 * time.Second)
+	res, _ := get(t, c, "/e", nil)
+	assert.Equal(t, "HIT", res.Headers["x-cache"])
+	assert.Equal(t, "95", res.Headers["age"])
+
+	clock.now = clock.now.Add(6 * time.Second)
+	res, _ = get(t, c, "/e", nil)
+	assert.Equal(t, "MISS", res.Headers["x-cache"])
+}
+
+func TestCacheVary(t *testing.T) {
+	upstream := &fakeUpstream{respond: func(req *request.Request) (response.StatusCode, map[string]string, string) {
+		lang := req.Headers["accept-language"]
+		return 200, map[string]string{"Cache-Control": "max-age=60", "Vary": "Accept-Language"}, "in " + lang
+	}}
+	c, _ := newTestCache(upstream, 1<<20)
+
+	_, body := get(t, c, "/v", map[string]string{"Accept-Language": "en"})
+	assert.Equal(t, "in en", body)
+	_, body = get(t, c, "/v", map[string]string{"Accept-Language": "th"})
+	assert.Equal(t, "in th", body)
+
+	res, body := get(t, c, "/v", map[string]string{"Accept-Language": "en"})
+	assert.Equal(t, "HIT", res.Headers["x-cache"])
+	assert.Equal(t, "in en", body)
+	res, body = get(t, c, "/v", map[string]string{"Accept-Language": "th"})
+	assert.Equal(t, "HIT", res.Headers["x-cache"])
+	assert.Equal(t, "in th", body)
+	assert.Len(t, upstream.requests, 2)
+}
+
+func TestCacheNotStorable(t *testing.T) {
+	cases := map[string]map[string]string{
+		"/private":  {"Cache-Control": "private, max-age=60"},
+		"/no-store": {"Cache-Control": "no-store"},
+		"/vary-all": {"Cache-Control": "max-age=60", "Vary": "*"},
+		"/cookie":   {"Cache-Control": "public, max-age=60", "Set-Cookie": "id=1"},
+		"/nothing":  {},
+	}
+	for target, hdrs := range cases {
+		upstream := &fakeUpstream{respond: func(req *request.Request) (response.StatusCode, map[string]string, string) {
+			return 200, hdrs, "x"
+		}}
+		c, _ := newTestCache(upstream, 1<<20)
+		get(t, c, target, nil)
+		res, _ := get(t, c, target, nil)
+		assert.Equal(t, "MISS", res.Headers["x-cache"], target)
+	}
+
+	// Test: Authorization needs the upstream's blessing
+	upstream := &fakeUpstream{respond: func(req *request.Request) (response.StatusCode, map[string]string, string) {
+		return 200, map[string]string{"Cache-Control": "max-age=60"}, "secret"
+	}}
+	c, _ := newTestCache(upstream, 1<<20)
+	auth := map[string]string{"Authorization": "Bearer abc"}
+	get(t, c, "/me", auth)
+	res, _ := get(t, c, "/me", auth)
+	assert.Equal(t, "MISS", res.Headers["x-cache"])
+}
+
+func TestCacheInvalidationAndEviction(t *testing.T) {
+	upstream := &fakeUpstream{respond: func(req *request.Request) (response.StatusCode, map[string]string, string) {
+		return 200, map[string]string{"Cache-Control": "max-age=60"}, strings.Repeat("z", 100)
+	}}
+	c, _ := newTestCache(upstream, 1000)
+
+	// Test: POST to the same target drops the entry
+	get(t, c, "/item", nil)
+	post := &request.Request{
+		RequestLine: request.RequestLine{Method: "POST", RequestTarget: "/item", HttpVersion: "1.1"},
+		Headers:     headers.Headers{"host": "example.test"},
+	}
+	_, err := c.RoundTrip(post)
+	require.NoError(t, err)
+	res, _ := get(t, c, "/item", nil)
+	assert.Equal(t, "MISS", res.Headers["x-cache"])
+
+	// Test: the budget pushes out the least recently used
+	for i := range 10 {
+		get(t, c, fmt.Sprint("/lru/", i), nil)
+	}
+	res, _ = get(t, c, "/lru/9", nil)
+	assert.Equal(t, "HIT", res.Headers["x-cache"])
+	res, _ = get(t, c, "/lru/0", nil)
+	assert.Equal(t, "MISS", res.Headers["x-cache"])
+	assert.LessOrEqual(t, c.store.used, 1000)
+}
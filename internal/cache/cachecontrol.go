@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cache-Control directives, names lower-cased, values unquoted.
+// A directive without a value maps to "".
+type Directives map[string]string
+
+// Split on commas outside of quotes, since private="a, b" is one directive.
+func ParseCacheControl(field string) Directives {
+	d := Directives{}
+	for _, part := range splitOutsideQuotes(field, ',') {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, val, _ := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		val = strings.TrimSpace(val)
+		if unquoted, err := strconv.Unquote(val); err == nil && strings.HasPrefix(val, `"`) {
+			val = unquoted
+		}
+		// first one wins when a directive repeats
+		if _, ok := d[name]; !ok {
+			d[name] = val
+		}
+	}
+	return d
+}
+
+func splitOutsideQuotes(s string, sep byte) []string {
+	parts := []string{}
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && inQuotes:
+			i++
+		case s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func (d Directives) Has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// Seconds value of a directive like max-age. Invalid values count as 0,
+// which is the safe answer for freshness (RFC 9111 1.2.2).
+func (d Directives) Seconds(name string) (time.Duration, bool) {
+	val, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	secs, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || secs < 0 {
+		return 0, true
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+// HTTP-date, the three formats RFC 9110 5.6.7 says we must accept.
+func parseHTTPDate(s string) (time.Time, bool) {
+	for _, layout := range []string{
+		time.RFC1123,
+		"Monday, 02-Jan-06 15:04:05 MST",
+		time.ANSIC,
+	} {
+		if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
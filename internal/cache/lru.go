@@ -0,0 +1,96 @@
+package cache
+
+import "container/list"
+
+// Least recently used store with a byte budget. Everything stored under
+// one primary key (all its Vary variants) lives and dies together.
+type lru struct {
+	budget int
+	used   int
+	order  *list.List // front is most recently used
+	items  map[string]*list.Element
+}
+
+type bucket struct {
+	key      string
+	variants []*entry
+}
+
+func (b *bucket) size() int {
+	total := 0
+	for _, e := range b.variants {
+		total += e.size
+	}
+	return total
+}
+
+func newLRU(budget int) *lru {
+	return &lru{
+		budget: budget,
+		order:  list.New(),
+		items:  make(map[string]*list.Element),
+	}
+}
+
+func (l *lru) get(key string) *bucket {
+	elem, ok := l.items[key]
+	if !ok {
+		return nil
+	}
+	l.order.MoveToFront(elem)
+	return elem.Value.(*bucket)
+}
+
+// Put e in place of any variant with the same vary values.
+func (l *lru) add(key string, e *entry) {
+	if e.size > l.budget {
+		return
+	}
+	b := l.get(key)
+	if b == nil {
+		b = &bucket{key: key}
+		l.items[key] = l.order.PushFront(b)
+	}
+	l.used -= b.size()
+	kept := b.variants[:0]
+	for _, old := range b.variants {
+		if !sameVary(old.varyValues, e.varyValues) {
+			kept = append(kept, old)
+		}
+	}
+	b.variants = append(kept, e)
+	l.used += b.size()
+	l.evict()
+}
+
+func (l *lru) remove(key string) {
+	elem, ok := l.items[key]
+	if !ok {
+		return
+	}
+	l.used -= elem.Value.(*bucket).size()
+	l.order.Remove(elem)
+	delete(l.items, key)
+}
+
+func (l *lru) evict() {
+	for l.used > l.budget {
+		oldest := l.order.Back()
+		if oldest == nil {
+			return
+		}
+		l.remove(oldest.Value.(*bucket).key)
+	}
+}
+
+func sameVary(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, val := range a {
+		if other, ok := b[key]; !ok || other != val {
+			return false
+		}
+	}
+	return true
+}
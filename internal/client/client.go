@@ -0,0 +1,256 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// Speaks HTTP/1.1 to upstreams using our own request/response code.
+// The zero value is ready to use.
+type Client struct {
+	DialTimeout time.Duration
+	// only used for https upstreams, nil means the defaults
+	TLSConfig *tls.Config
+	// Idle connections kept per scheme+host. 0 means DefaultMaxIdlePerHost,
+	// negative turns pooling off and every request gets its own connection.
+	MaxIdlePerHost int
+	// Idle connections older than this are closed instead of reused.
+	// 0 means DefaultIdleTimeout.
+	IdleTimeout time.Duration
+
+	mu    sync.Mutex
+	idle  map[string][]*persistConn
+	stats stats
+}
+
+var DefaultClient = &Client{}
+
+// Like response.Response, but Body streams so a big upstream response
+// never sits in memory. Trailers are only filled in after Body hits io.EOF.
+type Response struct {
+	HttpVersion string
+	StatusCode  response.StatusCode
+	Reason      string
+	Headers     headers.Headers
+	// Set-Cookie values, one per line the upstream sent
+	Cookies  []string
+	Trailers headers.Headers
+	Body     io.ReadCloser
+}
+
+const defaultDialTimeout = 10 * time.Second
+
+// Build a request for the given url, Host is filled in from it.
+func NewRequest(method string, u *url.URL, body []byte) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        method,
+			RequestTarget: u.RequestURI(),
+			HttpVersion:   "1.1",
+		},
+		Headers: headers.NewHeaders(),
+		Body:    body,
+	}
+	req.Headers.Set("Host", u.Host)
+	return req
+}
+
+func Get(rawURL string) (*Response, error) {
+	return DefaultClient.Get(rawURL)
+}
+
+func (c *Client) Get(rawURL string) (*Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(u, NewRequest("GET", u, nil))
+}
+
+// Send req to the upstream's scheme://host and read back the response head.
+// The caller must close res.Body. Reading it to the end hands the
+// connection back to the pool.
+//
+// req.Context() covers the whole exchange, body included: once it is done
+// any read or write in progress fails with the context's error.
+func (c *Client) Do(upstream *url.URL, req *request.Request) (*Response, error) {
+	ctx := req.Context()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// our additions go on a copy, the caller may send req again
+	req = req.Clone()
+	if _, found := req.Headers.Get("Host"); !found {
+		req.Headers.Set("Host", upstream.Host)
+	}
+	if c.maxIdlePerHost() < 0 && keepAlive(req.Headers, headers.NewHeaders()) {
+		// Set appends, other Connection options stay
+		req.Headers.Set("Connection", "close")
+	}
+
+	key := poolKey(upstream)
+	pc, err := c.getConn(ctx, key, upstream)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.roundTrip(pc, req)
+	// An idle connection can die between our health check and the write.
+	// Nothing reached the server in a usable way, so try once on a fresh one.
+	if err != nil && pc.reused && Idempotent(req.RequestLine.Method) && ctx.Err() == nil {
+		pc, err = c.dialConn(ctx, key, upstream)
+		if err != nil {
+			return nil, err
+		}
+		res, err = c.roundTrip(pc, req)
+	}
+	return res, err
+}
+
+func (c *Client) roundTrip(pc *persistConn, req *request.Request) (*Response, error) {
+	ctx := req.Context()
+	// a deadline in the past wakes up whatever read or write is blocked
+	stop := context.AfterFunc(ctx, func() {
+		pc.conn.SetDeadline(time.Unix(1, 0))
+	})
+	if _, err := req.WriteTo(pc.conn); err != nil {
+		stop()
+		pc.conn.Close()
+		return nil, fmt.Errorf("error writing request: %w", contextErr(ctx, err))
+	}
+	head, body, err := response.ReadResponseHead(pc, req.RequestLine.Method)
+	if err != nil {
+		stop()
+		pc.conn.Close()
+		return nil, fmt.Errorf("error reading response: %w", contextErr(ctx, err))
+	}
+	return &Response{
+		HttpVersion: head.HttpVersion,
+		StatusCode:  head.StatusCode,
+		Reason:      head.Reason,
+		Headers:     head.Headers,
+		Cookies:     head.Cookies,
+		Trailers:    head.Trailers, // same map, filled in at the end of body
+		Body: &connBody{
+			body:      body,
+			pc:        pc,
+			client:    c,
+			keepAlive: keepAlive(req.Headers, head.Headers) && !body.CloseDelimited(),
+			ctx:       ctx,
+			stop:      stop,
+		},
+	}, nil
+}
+
+// The context's error explains a failure better than "i/o timeout".
+func contextErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// Methods where sending twice means the same as sending once (RFC 9110
+// 9.2.2), the only ones safe to resend.
+func Idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func keepAlive(reqHeaders, resHeaders headers.Headers) bool {
+	for _, h := range []headers.Headers{reqHeaders, resHeaders} {
+		if h.HasToken("Connection", "close") {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Client) dial(ctx context.Context, upstream *url.URL) (net.Conn, error) {
+	timeout := c.DialTimeout
+	if timeout == 0 {
+		timeout = defaultDialTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+
+	switch upstream.Scheme {
+	case "http":
+		return dialer.DialContext(ctx, "tcp", hostPort(upstream, "80"))
+	case "https":
+		config := &tls.Config{}
+		if c.TLSConfig != nil {
+			config = c.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = upstream.Hostname()
+		}
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: config}
+		return tlsDialer.DialContext(ctx, "tcp", hostPort(upstream, "443"))
+	}
+	return nil, fmt.Errorf("unsupported scheme: %s", upstream.Scheme)
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// Hands the connection back to the pool once the body is fully read.
+type connBody struct {
+	body      *response.BodyReader
+	pc        *persistConn
+	client    *Client
+	keepAlive bool
+	finished  bool
+	ctx       context.Context
+	stop      func() bool // stops the context watch, false if it already fired
+}
+
+func (b *connBody) Read(p []byte) (int, error) {
+	if b.finished {
+		return 0, io.EOF
+	}
+	n, err := b.body.Read(p)
+	if err == io.EOF {
+		b.finished = true
+		// once the context fired the deadline is poisoned, no reuse
+		if b.stop() && b.keepAlive {
+			b.pc.pending = b.body.Buffered()
+			b.client.putConn(b.pc)
+		} else {
+			b.pc.conn.Close()
+		}
+	} else if err != nil {
+		b.finished = true
+		b.stop()
+		b.pc.conn.Close()
+		err = contextErr(b.ctx, err)
+	}
+	return n, err
+}
+
+// Closing before the end means we don't know where the next response
+// starts, so the connection can't be reused.
+func (b *connBody) Close() error {
+	if b.finished {
+		return nil
+	}
+	b.finished = true
+	b.stop()
+	return b.pc.conn.Close()
+}
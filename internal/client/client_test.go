@@ -0,0 +1,146 @@
+package client
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+	"github.com/WaronLimsakul/learn_http/internal/server"
+)
+
+// Upstream that answers every connection with the same raw bytes, then hangs up.
+// The request it received is sent on the returned channel.
+func rawUpstream(t *testing.T, raw string) (*url.URL, <-chan *request.Request) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan *request.Request, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			req, err := request.RequestFromReader(conn)
+			if err == nil {
+				received <- req
+			}
+			conn.Write([]byte(raw))
+			conn.Close()
+		}
+	}()
+	u, err := url.Parse("http://" + listener.Addr().String())
+	require.NoError(t, err)
+	return u, received
+}
+
+func TestClientFraming(t *testing.T) {
+	// Test: Content-Length body
+	u, received := rawUpstream(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello")
+	res, err := DefaultClient.Get(u.String() + "/coffee?x=1")
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.Equal(t, "OK", res.Reason)
+	assert.Equal(t, "hello", string(body))
+	req := <-received
+	assert.Equal(t, "/coffee?x=1", req.RequestLine.RequestTarget)
+	assert.Equal(t, u.Host, req.Headers["host"])
+
+	// Test: chunked body with trailers
+	u, _ = rawUpstream(t, "HTTP/1.1 200 OK\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"Trailer: X-Checksum\r\n\r\n"+
+		"5\r\nhello\r\n"+
+		"7;ext=1\r\n, world\r\n"+
+		"0\r\n"+
+		"X-Checksum: abc\r\n\r\n")
+	res, err = DefaultClient.Get(u.String())
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "hello, world", string(body))
+	assert.Equal(t, "abc", res.Trailers["x-checksum"])
+
+	// Test: close-delimited body, odd reason phrase
+	u, _ = rawUpstream(t, "HTTP/1.1 404 Not Here At All\r\nContent-Type: text/plain\r\n\r\nuntil the end")
+	res, err = DefaultClient.Get(u.String())
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, response.StatusCode(404), res.StatusCode)
+	assert.Equal(t, "Not Here At All", res.Reason)
+	assert.Equal(t, "until the end", string(body))
+
+	// Test: interim 100 Continue is skipped
+	u, _ = rawUpstream(t, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	res, err = DefaultClient.Get(u.String())
+	require.NoError(t, err)
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.Equal(t, "ok", string(body))
+
+	// Test: truncated fixed body
+	u, _ = rawUpstream(t, "HTTP/1.1 200 OK\r\nContent-Length: 50\r\n\r\nshort")
+	res, err = DefaultClient.Get(u.String())
+	require.NoError(t, err)
+	_, err = io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: garbage status line
+	u, _ = rawUpstream(t, "SMTP ready\r\n\r\n")
+	_, err = DefaultClient.Get(u.String())
+	require.Error(t, err)
+}
+
+func TestClientAgainstServer(t *testing.T) {
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		msg := []byte(req.RequestLine.Method + " " + string(req.Body))
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(msg)))
+		w.WriteBody(msg)
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	u, err := url.Parse("http://" + srv.Addr().String() + "/echo")
+	require.NoError(t, err)
+	res, err := DefaultClient.Do(u, NewRequest("POST", u, []byte("ping")))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "POST ping", string(body))
+	assert.Equal(t, "text/plain", res.Headers["content-type"])
+}
+
+func TestDoLeavesRequestAlone(t *testing.T) {
+	u, received := rawUpstream(t, "HTTP/1.1 204 No Content\r\n\r\n")
+	req := NewRequest("GET", u, nil)
+	req.Headers.Delete("Host")
+	c := &Client{MaxIdlePerHost: -1}
+
+	// sent twice, the second copy has no leftovers from the first
+	for range 2 {
+		res, err := c.Do(u, req)
+		require.NoError(t, err)
+		res.Body.Close()
+		sent := <-received
+		assert.Equal(t, u.Host, sent.Headers["host"])
+		assert.Equal(t, "close", sent.Headers["connection"])
+	}
+	assert.Empty(t, req.Headers)
+}
@@ -0,0 +1,202 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultMaxIdlePerHost = 2
+	DefaultIdleTimeout    = 90 * time.Second
+)
+
+// A connection that may carry many requests one after another.
+type persistConn struct {
+	conn      net.Conn
+	key       string
+	reused    bool
+	idleSince time.Time
+	// bytes the last response read past its own end
+	pending []byte
+
+	// while idle a goroutine sits in Read, so a hang up shows the moment
+	// it happens. watched is closed once that Read returned.
+	watched  chan struct{}
+	watchN   int
+	watchErr error
+}
+
+// An idle connection should have nothing to read. If the server closes its
+// side the Read gets EOF, if it sends junk it gets data, and the connection
+// is no good for the next request either way.
+func (pc *persistConn) watchIdle() {
+	pc.watched = make(chan struct{})
+	go func() {
+		defer close(pc.watched)
+		var one [1]byte
+		pc.watchN, pc.watchErr = pc.conn.Read(one[:])
+		if pc.watchN > 0 || !errors.Is(pc.watchErr, os.ErrDeadlineExceeded) {
+			// hung up or talking out of turn, free the socket right away
+			pc.conn.Close()
+		}
+	}()
+}
+
+func (pc *persistConn) Read(p []byte) (int, error) {
+	if len(pc.pending) > 0 {
+		n := copy(p, pc.pending)
+		pc.pending = pc.pending[n:]
+		return n, nil
+	}
+	return pc.conn.Read(p)
+}
+
+// Snapshot of what the pool has been doing.
+type Stats struct {
+	Dials      int64 // new connections opened
+	Reuses     int64 // requests sent on a pooled connection
+	Idle       int64 // connections sitting in the pool right now
+	Expired    int64 // idle connections dropped for being too old
+	Unhealthy  int64 // idle connections the server had closed
+	Overflowed int64 // connections closed because the host's pool was full
+}
+
+type stats struct {
+	dials      atomic.Int64
+	reuses     atomic.Int64
+	expired    atomic.Int64
+	unhealthy  atomic.Int64
+	overflowed atomic.Int64
+}
+
+func (c *Client) Stats() Stats {
+	c.mu.Lock()
+	idle := 0
+	for _, conns := range c.idle {
+		idle += len(conns)
+	}
+	c.mu.Unlock()
+	return Stats{
+		Dials:      c.stats.dials.Load(),
+		Reuses:     c.stats.reuses.Load(),
+		Idle:       int64(idle),
+		Expired:    c.stats.expired.Load(),
+		Unhealthy:  c.stats.unhealthy.Load(),
+		Overflowed: c.stats.overflowed.Load(),
+	}
+}
+
+func (c *Client) maxIdlePerHost() int {
+	if c.MaxIdlePerHost == 0 {
+		return DefaultMaxIdlePerHost
+	}
+	return c.MaxIdlePerHost
+}
+
+func (c *Client) idleTimeout() time.Duration {
+	if c.IdleTimeout == 0 {
+		return DefaultIdleTimeout
+	}
+	return c.IdleTimeout
+}
+
+func poolKey(u *url.URL) string {
+	defaultPort := "80"
+	if u.Scheme == "https" {
+		defaultPort = "443"
+	}
+	return u.Scheme + "://" + hostPort(u, defaultPort)
+}
+
+// Take the newest healthy idle connection for key, or dial a new one.
+func (c *Client) getConn(ctx context.Context, key string, upstream *url.URL) (*persistConn, error) {
+	for {
+		pc := c.popIdle(key)
+		if pc == nil {
+			break
+		}
+		if time.Since(pc.idleSince) > c.idleTimeout() {
+			c.stats.expired.Add(1)
+			pc.conn.Close()
+			continue
+		}
+		if !healthy(pc) {
+			c.stats.unhealthy.Add(1)
+			pc.conn.Close()
+			continue
+		}
+		pc.reused = true
+		c.stats.reuses.Add(1)
+		return pc, nil
+	}
+	return c.dialConn(ctx, key, upstream)
+}
+
+func (c *Client) dialConn(ctx context.Context, key string, upstream *url.URL) (*persistConn, error) {
+	conn, err := c.dial(ctx, upstream)
+	if err != nil {
+		return nil, err
+	}
+	c.stats.dials.Add(1)
+	return &persistConn{conn: conn, key: key}, nil
+}
+
+func (c *Client) popIdle(key string) *persistConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := c.idle[key]
+	if len(conns) == 0 {
+		return nil
+	}
+	pc := conns[len(conns)-1]
+	c.idle[key] = conns[:len(conns)-1]
+	return pc
+}
+
+func (c *Client) putConn(pc *persistConn) {
+	// leftover bytes mean the server sent something we never asked for
+	if len(pc.pending) > 0 || c.maxIdlePerHost() < 0 {
+		pc.conn.Close()
+		return
+	}
+	pc.idleSince = time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.idle == nil {
+		c.idle = make(map[string][]*persistConn)
+	}
+	if len(c.idle[pc.key]) >= c.maxIdlePerHost() {
+		c.stats.overflowed.Add(1)
+		pc.conn.Close()
+		return
+	}
+	c.idle[pc.key] = append(c.idle[pc.key], pc)
+	pc.watchIdle()
+}
+
+// Close every idle connection, e.g. on shutdown.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, conns := range c.idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+// Stop the idle watch, a deadline in the past wakes it up at once. Only
+// waking up that way means nothing happened while it sat in the pool.
+func healthy(pc *persistConn) bool {
+	pc.conn.SetReadDeadline(time.Unix(1, 0))
+	<-pc.watched
+	pc.conn.SetReadDeadline(time.Time{})
+	return pc.watchN == 0 && errors.Is(pc.watchErr, os.ErrDeadlineExceeded)
+}
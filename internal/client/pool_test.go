@@ -0,0 +1,223 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+)
+
+// Upstream that keeps connections open and answers each request with the
+// number of the connection it came in on. closeAfter > 0 hangs up after
+// that many responses on a connection.
+func keepAliveUpstream(t *testing.T, closeAfter int) *url.URL {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	var connCount atomic.Int64
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			id := connCount.Add(1)
+			go func() {
+				defer conn.Close()
+				for served := 1; ; served++ {
+					req, err := request.RequestFromReader(conn)
+					if err != nil {
+						return
+					}
+					msg := fmt.Sprintf("conn %d", id)
+					extra := ""
+					if req.RequestLine.RequestTarget == "/close" {
+						extra = "Connection: close\r\n"
+					}
+					fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\n%sContent-Length: %d\r\n\r\n%s", extra, len(msg), msg)
+					if extra != "" || served == closeAfter {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	u, err := url.Parse("http://" + listener.Addr().String())
+	require.NoError(t, err)
+	return u
+}
+
+func get(t *testing.T, c *Client, u *url.URL, path string) string {
+	t.Helper()
+	res, err := c.Get(u.String() + path)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	return string(body)
+}
+
+func TestPoolReusesConnections(t *testing.T) {
+	u := keepAliveUpstream(t, 0)
+	c := &Client{}
+
+	assert.Equal(t, "conn 1", get(t, c, u, "/a"))
+	assert.Equal(t, "conn 1", get(t, c, u, "/b"))
+	assert.Equal(t, "conn 1", get(t, c, u, "/c"))
+	stats := c.Stats()
+	assert.Equal(t, int64(1), stats.Dials)
+	assert.Equal(t, int64(2), stats.Reuses)
+	assert.Equal(t, int64(1), stats.Idle)
+
+	// Test: Connection: close from the upstream keeps it out of the pool
+	assert.Equal(t, "conn 1", get(t, c, u, "/close"))
+	assert.Equal(t, int64(0), c.Stats().Idle)
+	assert.Equal(t, "conn 2", get(t, c, u, "/"))
+	assert.Equal(t, int64(2), c.Stats().Dials)
+
+	c.CloseIdleConnections()
+	assert.Equal(t, int64(0), c.Stats().Idle)
+}
+
+func TestPoolDropsHalfClosed(t *testing.T) {
+	// upstream hangs up after every response but doesn't say so
+	u := keepAliveUpstream(t, 1)
+	c := &Client{}
+
+	assert.Equal(t, "conn 1", get(t, c, u, "/"))
+	// give the FIN time to arrive
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, "conn 2", get(t, c, u, "/"))
+	stats := c.Stats()
+	assert.Equal(t, int64(1), stats.Unhealthy)
+	assert.Equal(t, int64(2), stats.Dials)
+	assert.Equal(t, int64(0), stats.Reuses)
+}
+
+func TestKeepAliveTokens(t *testing.T) {
+	none := headers.NewHeaders()
+	assert.True(t, keepAlive(none, none))
+	assert.False(t, keepAlive(headers.Headers{"connection": "keep-alive, Close"}, none))
+	assert.False(t, keepAlive(none, headers.Headers{"connection": "close"}))
+	// a token that merely starts with "close" isn't one
+	assert.True(t, keepAlive(none, headers.Headers{"connection": "closed-loop"}))
+}
+
+func TestHealthyWithoutWaiting(t *testing.T) {
+	ours, theirs := net.Pipe()
+	defer theirs.Close()
+	pc := &persistConn{conn: ours}
+
+	// Test: a quiet connection is healthy, and we learn that right away
+	pc.watchIdle()
+	start := time.Now()
+	assert.True(t, healthy(pc))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	// Test: the server spoke while it sat in the pool
+	pc.watchIdle()
+	go theirs.Write([]byte("x"))
+	<-pc.watched
+	assert.False(t, healthy(pc))
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	u := keepAliveUpstream(t, 0)
+	c := &Client{IdleTimeout: 10 * time.Millisecond}
+
+	assert.Equal(t, "conn 1", get(t, c, u, "/"))
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, "conn 2", get(t, c, u, "/"))
+	assert.Equal(t, int64(1), c.Stats().Expired)
+}
+
+func TestPoolMaxIdlePerHost(t *testing.T) {
+	u := keepAliveUpstream(t, 0)
+	c := &Client{MaxIdlePerHost: 1}
+
+	// two responses in flight at once need two connections
+	first, err := c.Get(u.String())
+	require.NoError(t, err)
+	second, err := c.Get(u.String())
+	require.NoError(t, err)
+	io.ReadAll(first.Body)
+	io.ReadAll(second.Body)
+
+	stats := c.Stats()
+	assert.Equal(t, int64(2), stats.Dials)
+	assert.Equal(t, int64(1), stats.Idle)
+	assert.Equal(t, int64(1), stats.Overflowed)
+
+	// Test: pooling off
+	c = &Client{MaxIdlePerHost: -1}
+	get(t, c, u, "/")
+	get(t, c, u, "/")
+	assert.Equal(t, int64(2), c.Stats().Dials)
+	assert.Equal(t, int64(0), c.Stats().Idle)
+}
+
+func TestPoolAbandonedBody(t *testing.T) {
+	u := keepAliveUpstream(t, 0)
+	c := &Client{}
+
+	// closing without reading means we lost our place in the stream
+	res, err := c.Get(u.String())
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, int64(0), c.Stats().Idle)
+}
+
+func TestContextCancel(t *testing.T) {
+	// answers with a head and then only part of the promised body
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := request.RequestFromReader(conn); err != nil {
+					return
+				}
+				fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello")
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	u, err := url.Parse("http://" + listener.Addr().String())
+	require.NoError(t, err)
+	c := &Client{}
+
+	// Test: a context that is already done never dials
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.Do(u, NewRequest("GET", u, nil).WithContext(ctx))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int64(0), c.Stats().Dials)
+
+	// Test: the deadline interrupts a body that stalls
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	res, err := c.Do(u, NewRequest("GET", u, nil).WithContext(ctx))
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = io.ReadAll(res.Body)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int64(0), c.Stats().Idle)
+}
@@ -0,0 +1,192 @@
+// Cookies (RFC 6265): reading the Cookie header of a request and building
+// the Set-Cookie lines of a response.
+package cookie
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SameSite int
+
+const (
+	// no attribute, the browser picks (Lax in the current ones)
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	// needs Secure, browsers drop the cookie otherwise
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	}
+	return ""
+}
+
+// A cookie as a request sends it (Name and Value only) or as a response
+// sets it.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path   string
+	Domain string
+	// zero leaves it out, the cookie lasts as long as the browser session
+	Expires time.Time
+	// seconds, wins over Expires. 0 leaves it out, negative deletes the
+	// cookie right away (sent as Max-Age=0)
+	MaxAge int
+	// only sent over HTTPS
+	Secure bool
+	// hidden from scripts
+	HttpOnly bool
+	SameSite SameSite
+	// CHIPS: kept apart per top-level site, needs Secure
+	Partitioned bool
+}
+
+// Parse a Cookie header: "a=1; b=2". Pairs that aren't valid are skipped
+// the way browsers skip what they can't read. A value may be quoted, the
+// quotes are taken off. HTTP/1.1 clients may send the header more than
+// once, merged that comes with commas in between, so those split too.
+func Parse(header string) []*Cookie {
+	var cookies []*Cookie
+	for _, part := range strings.FieldsFunc(header, func(r rune) bool { return r == ';' || r == ',' }) {
+		name, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || !validName(name) {
+			continue
+		}
+		if len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"' {
+			val = val[1 : len(val)-1]
+		}
+		if !validValue(val) {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: val})
+	}
+	return cookies
+}
+
+// Whether c can go out as a Set-Cookie line as it is.
+func (c *Cookie) Valid() error {
+	if !validName(c.Name) {
+		return fmt.Errorf("cookie: invalid name %q", c.Name)
+	}
+	if !validValue(c.Value) {
+		return fmt.Errorf("cookie: invalid value for %s", c.Name)
+	}
+	if !validAttr(c.Path) {
+		return fmt.Errorf("cookie: invalid path %q", c.Path)
+	}
+	if c.Domain != "" && !validDomain(strings.TrimPrefix(c.Domain, ".")) {
+		return fmt.Errorf("cookie: invalid domain %q", c.Domain)
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return fmt.Errorf("cookie: expires before 1601")
+	}
+	if (c.SameSite == SameSiteNone || c.Partitioned) && !c.Secure {
+		return fmt.Errorf("cookie: %s needs Secure", c.Name)
+	}
+	return nil
+}
+
+// The Set-Cookie value for c (RFC 6265 4.1.1). Check Valid first, String
+// doesn't.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(c.Value)
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		// the leading dot is from RFC 2109 and ignored anyway
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT"))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// A token (RFC 9110 5.6.2).
+func validName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if !((ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') ||
+			(ch >= '0' && ch <= '9') || strings.IndexByte("!#$%&'*+-.^_`|~", ch) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// cookie-octets: visible ASCII but DQUOTE, comma, semicolon and backslash.
+func validValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch <= ' ' || ch >= 0x7f || strings.IndexByte("\",;\\", ch) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Path and the like: anything visible but a semicolon.
+func validAttr(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] >= 0x7f || s[i] == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+func validDomain(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			ch := label[i]
+			if !((ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') ||
+				(ch >= '0' && ch <= '9') || ch == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	cookies := Parse(`session=abc123; theme="dark"; bad name=x; empty=; noequals; b=2`)
+	var got []string
+	for _, c := range cookies {
+		got = append(got, c.Name+"="+c.Value)
+	}
+	assert.Equal(t, []string{"session=abc123", "theme=dark", "empty=", "b=2"}, got)
+
+	// Test: two Cookie lines merged by the header parser
+	assert.Len(t, Parse("a=1, b=2"), 2)
+	assert.Empty(t, Parse(""))
+}
+
+func TestString(t *testing.T) {
+	c := &Cookie{
+		Name:        "id",
+		Value:       "a3fWa",
+		Path:        "/",
+		Domain:      ".example.test",
+		Expires:     time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	assert.NoError(t, c.Valid())
+	assert.Equal(t, "id=a3fWa; Path=/; Domain=example.test; Expires=Wed, 21 Oct 2015 07:28:00 GMT; "+
+		"Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", c.String())
+
+	// Test: deleting
+	assert.Equal(t, "id=; Max-Age=0", (&Cookie{Name: "id", MaxAge: -1}).String())
+	assert.Equal(t, "id=x; SameSite=Lax", (&Cookie{Name: "id", Value: "x", SameSite: SameSiteLax}).String())
+}
+
+func TestValid(t *testing.T) {
+	for _, bad := range []*Cookie{
+		{Name: ""},
+		{Name: "a b", Value: "x"},
+		{Name: "a", Value: "x;y"},
+		{Name: "a", Value: "with space"},
+		{Name: "a", Path: "/;evil"},
+		{Name: "a", Domain: "exa_mple.test"},
+		{Name: "a", SameSite: SameSiteNone},
+		{Name: "a", Partitioned: true},
+		{Name: "a", Expires: time.Date(1500, 1, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		assert.Error(t, bad.Valid(), bad.Name)
+	}
+}
@@ -0,0 +1,140 @@
+package digest
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"sort"
+	"strings"
+)
+
+// Algorithm keys from the HTTP Digest Algorithm Values registry (RFC 9530 7.2).
+type Algorithm string
+
+const (
+	SHA256 Algorithm = "sha-256"
+	SHA512 Algorithm = "sha-512"
+	// deprecated for integrity, still handy to catch corruption cheaply
+	CRC32C Algorithm = "crc32c"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Return a fresh hash for a, false when we don't know the algorithm.
+func (a Algorithm) New() (hash.Hash, bool) {
+	switch a {
+	case SHA256:
+		return sha256.New(), true
+	case SHA512:
+		return sha512.New(), true
+	case CRC32C:
+		return crc32.New(castagnoli), true
+	}
+	return nil, false
+}
+
+// Hashes everything written to it with several algorithms at once, so a
+// body can be digested as it streams by instead of being kept around.
+type Digester struct {
+	algorithms []Algorithm
+	hashes     []hash.Hash
+	n          int64
+}
+
+// Unknown algorithms are skipped. No algorithms means SHA256.
+func New(algorithms ...Algorithm) *Digester {
+	if len(algorithms) == 0 {
+		algorithms = []Algorithm{SHA256}
+	}
+	d := &Digester{}
+	for _, a := range algorithms {
+		if h, ok := a.New(); ok {
+			d.algorithms = append(d.algorithms, a)
+			d.hashes = append(d.hashes, h)
+		}
+	}
+	return d
+}
+
+// Never fails, hashes don't.
+func (d *Digester) Write(p []byte) (int, error) {
+	for _, h := range d.hashes {
+		h.Write(p)
+	}
+	d.n += int64(len(p))
+	return len(p), nil
+}
+
+// Bytes written so far.
+func (d *Digester) Len() int64 {
+	return d.n
+}
+
+func (d *Digester) Algorithms() []Algorithm {
+	return d.algorithms
+}
+
+// Digest of everything so far, nil if a isn't one of ours.
+func (d *Digester) Sum(a Algorithm) []byte {
+	for i, algorithm := range d.algorithms {
+		if algorithm == a {
+			return d.hashes[i].Sum(nil)
+		}
+	}
+	return nil
+}
+
+// Value for Content-Digest / Repr-Digest, e.g. sha-256=:X48E9q...=:
+func (d *Digester) Field() string {
+	sums := map[Algorithm][]byte{}
+	for _, a := range d.algorithms {
+		sums[a] = d.Sum(a)
+	}
+	return Format(sums)
+}
+
+// A structured field dictionary of byte sequences (RFC 8941 3.2), keys sorted.
+func Format(sums map[Algorithm][]byte) string {
+	keys := make([]string, 0, len(sums))
+	for a := range sums {
+		keys = append(keys, string(a))
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		sum := sums[Algorithm(key)]
+		parts = append(parts, key+"=:"+base64.StdEncoding.EncodeToString(sum)+":")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Parse a Content-Digest / Repr-Digest value. Keys are lowercased, members
+// that aren't byte sequences (or have parameters we don't read) are errors.
+func Parse(field string) (map[Algorithm][]byte, error) {
+	sums := map[Algorithm][]byte{}
+	for _, member := range strings.Split(field, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		key, val, found := strings.Cut(member, "=")
+		if !found {
+			return nil, fmt.Errorf("digest member without value: %s", member)
+		}
+		// parameters don't change the value, drop them
+		val, _, _ = strings.Cut(val, ";")
+		val = strings.TrimSpace(val)
+		if len(val) < 2 || val[0] != ':' || val[len(val)-1] != ':' {
+			return nil, fmt.Errorf("digest value is not a byte sequence: %s", member)
+		}
+		sum, err := base64.StdEncoding.DecodeString(val[1 : len(val)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 in digest: %s", member)
+		}
+		sums[Algorithm(strings.ToLower(strings.TrimSpace(key)))] = sum
+	}
+	return sums, nil
+}
@@ -0,0 +1,54 @@
+package digest
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigester(t *testing.T) {
+	// Test: the example from RFC 9530 appendix D.1, written in pieces
+	d := New(SHA256, SHA512, CRC32C, "md5")
+	d.Write([]byte(`{"hello": `))
+	d.Write([]byte(`"world"}`))
+	assert.Equal(t, []Algorithm{SHA256, SHA512, CRC32C}, d.Algorithms())
+	assert.Equal(t, int64(18), d.Len())
+	assert.Equal(t, "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:",
+		Format(map[Algorithm][]byte{SHA256: d.Sum(SHA256)}))
+	assert.Nil(t, d.Sum("md5"))
+
+	// Test: CRC32C check value
+	d = New(CRC32C)
+	d.Write([]byte("123456789"))
+	assert.Equal(t, "e3069283", hex.EncodeToString(d.Sum(CRC32C)))
+
+	// Test: default is sha-256
+	assert.Equal(t, []Algorithm{SHA256}, New().Algorithms())
+}
+
+func TestFormatParse(t *testing.T) {
+	d := New(SHA512, SHA256)
+	d.Write([]byte("round trip"))
+	field := d.Field()
+	assert.True(t, strings.HasPrefix(field, "sha-256=:"))
+	assert.Contains(t, field, ", sha-512=:")
+
+	sums, err := Parse(field)
+	require.NoError(t, err)
+	assert.Equal(t, d.Sum(SHA256), sums[SHA256])
+	assert.Equal(t, d.Sum(SHA512), sums[SHA512])
+
+	// Test: keys are case-insensitive, parameters are ignored
+	sums, err = Parse("SHA-256=:AAAA:;foo=1, ")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0}, sums[SHA256])
+
+	// Test: malformed members
+	for _, field := range []string{"sha-256", "sha-256=abc", "sha-256=:not base64!:", "sha-256=:"} {
+		_, err := Parse(field)
+		assert.Error(t, err, field)
+	}
+}
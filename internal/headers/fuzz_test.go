@@ -0,0 +1,68 @@
+package headers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parse field lines until the blank line, like the request parser does
+func parseAll(data []byte) (Headers, int, bool, error) {
+	h := NewHeaders()
+	total := 0
+	for {
+		n, done, err := h.Parse(data[total:])
+		if err != nil {
+			return nil, total, false, err
+		}
+		total += n
+		if done || n == 0 {
+			return h, total, done, nil
+		}
+	}
+}
+
+// Set merges repeats with ", ", so an empty repeat leaves "a, " behind and
+// reading that back trims it to "a,". Same list either way.
+func trimValues(h Headers) Headers {
+	trimmed := NewHeaders()
+	for key, val := range h {
+		trimmed[key] = strings.TrimSpace(val)
+	}
+	return trimmed
+}
+
+func FuzzHeadersParse(f *testing.F) {
+	f.Add([]byte("Host: localhost:42069\r\n\r\n"))
+	f.Add([]byte("HOST: localhost:42069\r\n\r\n"))
+	f.Add([]byte("Host: localhost:42069  \r\n HX-Request: true \r\n\r\n"))
+	f.Add([]byte("\r\n"))
+	f.Add([]byte("Set-Person: lane-loves-go\r\n Set-Person: prime-loves-zig\r\n Set-Person: tj-loves-ocaml\r\n\r\n"))
+	f.Add([]byte("       Host : localhost:42069       \r\n\r\n"))
+	f.Add([]byte("H©st: localhost:42069       \r\n\r\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, n, done, err := parseAll(data)
+		if err != nil {
+			return
+		}
+		require.LessOrEqual(t, n, len(data))
+		if !done {
+			return
+		}
+
+		// every accepted field survives being written out and parsed again
+		var buf bytes.Buffer
+		for key, val := range h {
+			require.True(t, validFieldName([]byte(key)), "bad key %q", key)
+			buf.WriteString(key + ": " + val + crlf)
+		}
+		buf.WriteString(crlf)
+		again, _, done, err := parseAll(buf.Bytes())
+		require.NoError(t, err, "reparsing %q", buf.String())
+		require.True(t, done)
+		assert.Equal(t, trimValues(h), again)
+	})
+}
@@ -26,15 +26,15 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		// only done when we found crlf separator
 		return 2, true, nil
 	}
-	pair := string(data[:crlfIdx])
-	key, val, err := getFieldLinePair(pair)
+	// stay in []byte until we know the line is good, then allocate once
+	key, val, err := getFieldLinePair(data[:crlfIdx])
 	if err != nil {
 		return 0, false, err
 	}
 	if !validFieldName(key) {
 		return 0, false, fmt.Errorf("invalid field-name: '%s'", key)
 	}
-	h.Set(key, val)
+	h.Set(fieldName(key), string(val))
 	return crlfIdx + 2, false, nil
 }
 
@@ -54,23 +54,23 @@ func (h Headers) Reset(key, val string) {
 }
 
 // return trim value o
-func getFieldLinePair(s string) (key, val string, err error) {
-	s  = strings.TrimSpace(s)
-	key, val, found := strings.Cut(s, ":")
+func getFieldLinePair(line []byte) (key, val []byte, err error) {
+	line = bytes.TrimSpace(line)
+	key, val, found := bytes.Cut(line, []byte(":"))
 	if !found {
-		return "", "", fmt.Errorf("Invalid field line: %s", s)
+		return nil, nil, fmt.Errorf("Invalid field line: %s", line)
 	}
 
-	if strings.Contains(key, " ") {
-		return "", "", fmt.Errorf("Invalid header name: %s", key)
+	if bytes.Contains(key, []byte(" ")) {
+		return nil, nil, fmt.Errorf("Invalid header name: %s", key)
 	}
 
-	val = strings.TrimSpace(val)
+	val = bytes.TrimSpace(val)
 
 	return
 }
 
-func validFieldName(s string) bool {
+func validFieldName(s []byte) bool {
 	if len(s) == 0 {
 		return false
 	}
@@ -78,13 +78,47 @@ func validFieldName(s string) bool {
 		if !((ch >= 'a' && ch <= 'z') ||
 			(ch >= 'A' && ch <= 'Z') ||
 			(ch >= '0' && ch <= '9') ||
-			strings.Contains("!#$%&'*+-.^_`|~", string(ch)) ) {
+			strings.IndexByte("!#$%&'*+-.^_`|~", ch) >= 0) {
 			return false
 		}
 	}
 	return  true
 }
 
+// Names we see on almost every request. Looking them up with
+// string(bytes) as the key doesn't allocate.
+var commonFieldNames = map[string]string{}
+
+func init() {
+	for _, name := range []string{
+		"host", "user-agent", "accept", "accept-encoding", "accept-language",
+		"connection", "content-length", "content-type", "content-encoding",
+		"transfer-encoding", "cookie", "referer", "origin", "cache-control",
+		"authorization", "if-none-match", "if-modified-since", "upgrade",
+	} {
+		commonFieldNames[name] = name
+	}
+}
+
+// lower-case the field name, reusing a shared string for common ones
+func fieldName(key []byte) string {
+	var stack [32]byte
+	lower := stack[:0]
+	if len(key) > len(stack) {
+		lower = make([]byte, 0, len(key))
+	}
+	for _, ch := range key {
+		if ch >= 'A' && ch <= 'Z' {
+			ch += 'a' - 'A'
+		}
+		lower = append(lower, ch)
+	}
+	if name, ok := commonFieldNames[string(lower)]; ok {
+		return name
+	}
+	return string(lower)
+}
+
 func (h Headers) Get(s string) (val string, found bool) {
 	val, found = h[strings.ToLower(s)]
 	return
@@ -94,3 +128,23 @@ func (h Headers) Delete(key string) {
 	key = strings.ToLower(key)
 	delete(h, key)
 }
+
+// Whether field lists token, comma separated and case-insensitive.
+func (h Headers) HasToken(field, token string) bool {
+	val, _ := h.Get(field)
+	for _, part := range strings.Split(val, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// A copy that can be changed without touching h.
+func (h Headers) Clone() Headers {
+	clone := NewHeaders()
+	for key, val := range h {
+		clone[key] = val
+	}
+	return clone
+}
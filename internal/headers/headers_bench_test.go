@@ -0,0 +1,40 @@
+package headers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func headerBlock(fields int) []byte {
+	var sb strings.Builder
+	for i := range fields {
+		fmt.Fprintf(&sb, "X-Field-%d: value-%d-%s\r\n", i, i, strings.Repeat("x", 20))
+	}
+	sb.WriteString("\r\n")
+	return []byte(sb.String())
+}
+
+func BenchmarkHeadersParse(b *testing.B) {
+	for _, fields := range []int{10, 50, 100} {
+		b.Run(fmt.Sprintf("fields=%d", fields), func(b *testing.B) {
+			data := headerBlock(fields)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				h := NewHeaders()
+				rest := data
+				for {
+					n, done, err := h.Parse(rest)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if done {
+						break
+					}
+					rest = rest[n:]
+				}
+			}
+		})
+	}
+}
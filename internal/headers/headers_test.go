@@ -79,3 +79,17 @@ func TestParseHeaders(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 }
+
+func TestHasToken(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Connection", "keep-alive")
+	h.Set("Connection", " Upgrade ,HTTP2-Settings")
+	assert.True(t, h.HasToken("connection", "upgrade"))
+	assert.True(t, h.HasToken("Connection", "http2-settings"))
+	assert.True(t, h.HasToken("Connection", "KEEP-ALIVE"))
+
+	// Test: only whole tokens count
+	h.Reset("Connection", "closed-loop")
+	assert.False(t, h.HasToken("Connection", "close"))
+	assert.False(t, h.HasToken("Upgrade", "websocket"))
+}
@@ -0,0 +1,290 @@
+// Header compression for HTTP/2 (RFC 7541).
+package hpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The table size both sides start with, before any SETTINGS.
+const DefaultTableSize = 4096
+
+var ErrHeaderListTooLarge = errors.New("hpack: header list too large")
+
+// Anything wrong with a header block. The connection can't go on after
+// one, the tables no longer agree (RFC 9113 4.3).
+type DecodingError struct {
+	Reason string
+}
+
+func (e *DecodingError) Error() string {
+	return "hpack: " + e.Reason
+}
+
+type HeaderField struct {
+	Name, Value string
+	// never put in a table, not even by proxies along the way,
+	// e.g. cookies or authorization
+	Sensitive bool
+}
+
+// What the field counts for against table sizes (RFC 7541 4.1) and
+// SETTINGS_MAX_HEADER_LIST_SIZE.
+func (f HeaderField) Size() uint32 {
+	return uint32(len(f.Name) + len(f.Value) + 32)
+}
+
+// One side's state for reading header blocks.
+type Decoder struct {
+	table dynamicTable
+	// the largest table the encoder may ask for, our SETTINGS_HEADER_TABLE_SIZE
+	allowedMaxSize uint32
+	// 0 means no limit. Past it Decode keeps the table in sync but
+	// returns ErrHeaderListTooLarge instead of the fields.
+	MaxHeaderListSize uint32
+}
+
+func NewDecoder(maxTableSize uint32) *Decoder {
+	return &Decoder{
+		table:          dynamicTable{maxSize: maxTableSize},
+		allowedMaxSize: maxTableSize,
+	}
+}
+
+// Decode one complete header block (all CONTINUATIONs put together).
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	var fields []HeaderField
+	var listSize uint32
+	tooLarge := false
+	emit := func(f HeaderField) {
+		listSize += f.Size()
+		if d.MaxHeaderListSize > 0 && listSize > d.MaxHeaderListSize {
+			tooLarge = true
+		}
+		if !tooLarge {
+			fields = append(fields, f)
+		}
+	}
+
+	// size updates are only allowed before the first field (RFC 7541 4.2)
+	first := true
+	for len(block) > 0 {
+		b := block[0]
+		switch {
+		case b&0x80 != 0:
+			// indexed field
+			index, rest, err := readInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			block = rest
+			f, ok := d.table.at(index)
+			if !ok {
+				return nil, &DecodingError{fmt.Sprintf("invalid index %d", index)}
+			}
+			emit(f)
+		case b&0xc0 == 0x40:
+			// literal, added to the table
+			f, rest, err := d.readLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			block = rest
+			d.table.add(f)
+			emit(f)
+		case b&0xe0 == 0x20:
+			if !first {
+				return nil, &DecodingError{"table size update after a field"}
+			}
+			size, rest, err := readInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			block = rest
+			if size > uint64(d.allowedMaxSize) {
+				return nil, &DecodingError{fmt.Sprintf("table size %d over the limit", size)}
+			}
+			d.table.setMaxSize(uint32(size))
+			continue
+		default:
+			// literal, not indexed (0000) or never indexed (0001)
+			f, rest, err := d.readLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+			block = rest
+			f.Sensitive = b&0x10 != 0
+			emit(f)
+		}
+		first = false
+	}
+	if tooLarge {
+		return nil, ErrHeaderListTooLarge
+	}
+	return fields, nil
+}
+
+// A literal field whose name index has prefixBits bits, 0 for a new name.
+func (d *Decoder) readLiteral(block []byte, prefixBits uint8) (HeaderField, []byte, error) {
+	var f HeaderField
+	nameIndex, rest, err := readInt(block, prefixBits)
+	if err != nil {
+		return f, nil, err
+	}
+	if nameIndex > 0 {
+		indexed, ok := d.table.at(nameIndex)
+		if !ok {
+			return f, nil, &DecodingError{fmt.Sprintf("invalid index %d", nameIndex)}
+		}
+		f.Name = indexed.Name
+	} else {
+		f.Name, rest, err = readString(rest)
+		if err != nil {
+			return f, nil, err
+		}
+	}
+	f.Value, rest, err = readString(rest)
+	return f, rest, err
+}
+
+// RFC 7541 5.1. Values past 2^32 are nonsense for us and rejected.
+func readInt(p []byte, prefixBits uint8) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, nil, &DecodingError{"truncated integer"}
+	}
+	mask := uint64(1)<<prefixBits - 1
+	n := uint64(p[0]) & mask
+	p = p[1:]
+	if n < mask {
+		return n, p, nil
+	}
+	var shift uint
+	for len(p) > 0 {
+		b := p[0]
+		p = p[1:]
+		n += uint64(b&0x7f) << shift
+		if n > 1<<32 {
+			return 0, nil, &DecodingError{"integer overflow"}
+		}
+		if b&0x80 == 0 {
+			return n, p, nil
+		}
+		shift += 7
+		if shift > 35 {
+			return 0, nil, &DecodingError{"integer overflow"}
+		}
+	}
+	return 0, nil, &DecodingError{"truncated integer"}
+}
+
+func readString(p []byte) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", nil, &DecodingError{"truncated string"}
+	}
+	huffman := p[0]&0x80 != 0
+	length, rest, err := readInt(p, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(rest)) < length {
+		return "", nil, &DecodingError{"truncated string"}
+	}
+	raw, rest := rest[:length], rest[length:]
+	if !huffman {
+		return string(raw), rest, nil
+	}
+	decoded, err := AppendHuffmanDecode(nil, raw)
+	if err != nil {
+		return "", nil, &DecodingError{err.Error()}
+	}
+	return string(decoded), rest, nil
+}
+
+// One side's state for writing header blocks. Blocks have to go out in
+// the order they were encoded.
+type Encoder struct {
+	table dynamicTable
+	// the smallest size since the last block, and whether the peer has to
+	// hear about a change (RFC 7541 4.2)
+	minSize       uint32
+	pendingUpdate bool
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{table: dynamicTable{maxSize: DefaultTableSize}}
+}
+
+// Follow the peer's SETTINGS_HEADER_TABLE_SIZE. We never use more than
+// DefaultTableSize, whatever the peer allows.
+func (e *Encoder) SetMaxTableSize(n uint32) {
+	n = min(n, DefaultTableSize)
+	if n == e.table.maxSize {
+		return
+	}
+	if !e.pendingUpdate || n < e.minSize {
+		e.minSize = n
+	}
+	e.pendingUpdate = true
+	e.table.setMaxSize(n)
+}
+
+// Append the block for fields to dst.
+func (e *Encoder) Encode(dst []byte, fields []HeaderField) []byte {
+	if e.pendingUpdate {
+		if e.minSize < e.table.maxSize {
+			dst = appendInt(dst, 0x20, 5, uint64(e.minSize))
+		}
+		dst = appendInt(dst, 0x20, 5, uint64(e.table.maxSize))
+		e.pendingUpdate = false
+	}
+	for _, f := range fields {
+		dst = e.encodeField(dst, f)
+	}
+	return dst
+}
+
+func (e *Encoder) encodeField(dst []byte, f HeaderField) []byte {
+	index, nameOnly := e.table.search(f)
+	if index > 0 && !nameOnly && !f.Sensitive {
+		return appendInt(dst, 0x80, 7, index)
+	}
+	switch {
+	case f.Sensitive:
+		dst = appendInt(dst, 0x10, 4, index)
+	case f.Size() > e.table.maxSize:
+		// would only flush the table, send it without indexing
+		dst = appendInt(dst, 0x00, 4, index)
+	default:
+		dst = appendInt(dst, 0x40, 6, index)
+		e.table.add(HeaderField{Name: f.Name, Value: f.Value})
+	}
+	if index == 0 {
+		dst = appendString(dst, f.Name)
+	}
+	return appendString(dst, f.Value)
+}
+
+// first holds the representation's flag bits above the prefix.
+func appendInt(dst []byte, first byte, prefixBits uint8, n uint64) []byte {
+	mask := uint64(1)<<prefixBits - 1
+	if n < mask {
+		return append(dst, first|byte(n))
+	}
+	dst = append(dst, first|byte(mask))
+	n -= mask
+	for n >= 0x80 {
+		dst = append(dst, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(dst, byte(n))
+}
+
+// Huffman whenever it comes out shorter.
+func appendString(dst []byte, s string) []byte {
+	if huffLen := HuffmanEncodedLen(s); huffLen < len(s) {
+		dst = appendInt(dst, 0x80, 7, uint64(huffLen))
+		return AppendHuffman(dst, s)
+	}
+	dst = appendInt(dst, 0x00, 7, uint64(len(s)))
+	return append(dst, s...)
+}
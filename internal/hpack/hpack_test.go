@@ -0,0 +1,161 @@
+package hpack
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return b
+}
+
+func fields(pairs ...string) []HeaderField {
+	var fs []HeaderField
+	for i := 0; i < len(pairs); i += 2 {
+		fs = append(fs, HeaderField{Name: pairs[i], Value: pairs[i+1]})
+	}
+	return fs
+}
+
+// RFC 7541 C.4, three requests on one connection, Huffman coded.
+var requestExamples = []struct {
+	block  string
+	fields []HeaderField
+	size   uint32
+}{
+	{
+		"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+		fields(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com"),
+		57,
+	},
+	{
+		"8286 84be 5886 a8eb 1064 9cbf",
+		fields(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com", "cache-control", "no-cache"),
+		110,
+	},
+	{
+		"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+		fields(":method", "GET", ":scheme", "https", ":path", "/index.html", ":authority", "www.example.com", "custom-key", "custom-value"),
+		164,
+	},
+}
+
+func TestDecoder(t *testing.T) {
+	// Test: the RFC's request examples, each one leaning on the table the
+	// ones before it built
+	d := NewDecoder(DefaultTableSize)
+	for _, example := range requestExamples {
+		got, err := d.Decode(unhex(t, example.block))
+		require.NoError(t, err)
+		assert.Equal(t, example.fields, got)
+		assert.Equal(t, example.size, d.table.size)
+	}
+
+	// Test: C.6, responses through a 256 byte table with eviction
+	d = NewDecoder(256)
+	responses := []string{
+		"4882 6402 5885 aec3 771a 4b61 96d0 7abe 9410 54d4 44a8 2005 9504 0b81 66e0 82a6 2d1b ff6e 919d 29ad 1718 63c7 8f0b 97c8 e9ae 82ae 43d3",
+		"4883 640e ffc1 c0bf",
+		"88c1 6196 d07a be94 1054 d444 a820 0595 040b 8166 e084 a62d 1bff c05a 839b d9ab 77ad 94e7 821d d7f2 e6c7 b335 dfdf cd5b 3960 d5af 2708 7f36 72c1 ab27 0fb5 291f 9587 3160 65c0 03ed 4ee5 b106 3d50 07",
+	}
+	for _, block := range responses {
+		_, err := d.Decode(unhex(t, block))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, uint32(215), d.table.size)
+	// oldest first
+	assert.Equal(t, fields(
+		"date", "Mon, 21 Oct 2013 20:13:22 GMT",
+		"content-encoding", "gzip",
+		"set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1",
+	), d.table.entries)
+
+	// Test: never-indexed literals stay out of the table
+	d = NewDecoder(DefaultTableSize)
+	got, err := d.Decode(unhex(t, "1008 7061 7373 776f 7264 0673 6563 7265 74"))
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{Name: "password", Value: "secret", Sensitive: true}}, got)
+	assert.Equal(t, 0, d.table.len())
+}
+
+func TestDecoderErrors(t *testing.T) {
+	tests := map[string]string{
+		"index out of range":      "be",
+		"index zero":              "80",
+		"truncated string":        "4005 6162",
+		"size update after field": "82 3f e1 1f",
+		"size update over limit":  "3f e2 1f",
+		"integer too long":        "ff ff ff ff ff ff ff",
+		"bad huffman padding":     "4081 00 00",
+	}
+	for name, block := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewDecoder(DefaultTableSize).Decode(unhex(t, block))
+			var decodingErr *DecodingError
+			assert.ErrorAs(t, err, &decodingErr)
+		})
+	}
+
+	// Test: a huge list is refused, but the table still learns the fields
+	d := NewDecoder(DefaultTableSize)
+	d.MaxHeaderListSize = 40
+	_, err := d.Decode(unhex(t, requestExamples[2].block[:4]+" 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf"))
+	assert.ErrorIs(t, err, ErrHeaderListTooLarge)
+	assert.Equal(t, 1, d.table.len())
+}
+
+func TestEncoderRoundTrip(t *testing.T) {
+	e := NewEncoder()
+	d := NewDecoder(DefaultTableSize)
+	for _, example := range requestExamples {
+		block := e.Encode(nil, example.fields)
+		got, err := d.Decode(block)
+		require.NoError(t, err)
+		assert.Equal(t, example.fields, got)
+	}
+
+	// Test: the same fields again come out as one byte each
+	block := e.Encode(nil, requestExamples[2].fields)
+	assert.Len(t, block, len(requestExamples[2].fields))
+
+	// Test: a smaller table from SETTINGS is announced in the next block
+	e.SetMaxTableSize(0)
+	e.SetMaxTableSize(100)
+	block = e.Encode(nil, fields("x-custom", "1"))
+	assert.Equal(t, []byte{0x20, 0x3f, 0x45}, block[:3])
+	got, err := d.Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, fields("x-custom", "1"), got)
+	assert.Equal(t, 1, d.table.len())
+
+	// Test: sensitive fields are never indexed on either side
+	block = e.Encode(nil, []HeaderField{{Name: "authorization", Value: "Bearer x", Sensitive: true}})
+	got, err = d.Decode(block)
+	require.NoError(t, err)
+	assert.True(t, got[0].Sensitive)
+	assert.Equal(t, 1, d.table.len())
+}
+
+func TestHuffman(t *testing.T) {
+	for _, s := range []string{"", "www.example.com", "no-cache", "custom-key", "\x00\xff binary \x7f"} {
+		encoded := AppendHuffman(nil, s)
+		assert.Len(t, encoded, HuffmanEncodedLen(s))
+		decoded, err := AppendHuffmanDecode(nil, encoded)
+		require.NoError(t, err)
+		assert.Equal(t, s, string(decoded))
+	}
+	assert.Equal(t, unhex(t, "f1e3 c2e5 f23a 6ba0 ab90 f4ff"), AppendHuffman(nil, "www.example.com"))
+
+	// Test: EOS inside the data and padding of 8 or more bits
+	_, err := AppendHuffmanDecode(nil, unhex(t, "ffff fffc"))
+	assert.ErrorIs(t, err, ErrInvalidHuffman)
+	_, err = AppendHuffmanDecode(nil, unhex(t, "f1e3 c2e5 f23a 6ba0 ab90 f4ff ff"))
+	assert.ErrorIs(t, err, ErrInvalidHuffman)
+}
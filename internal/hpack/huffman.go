@@ -0,0 +1,97 @@
+package hpack
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrInvalidHuffman = errors.New("hpack: invalid huffman-encoded data")
+
+// How many bytes s takes once Huffman encoded.
+func HuffmanEncodedLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLen[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+// Append the Huffman encoding of s to dst, padded with the start of EOS.
+func AppendHuffman(dst []byte, s string) []byte {
+	var acc uint64 // bits waiting to be written, right aligned
+	var n uint     // how many of them
+	for i := 0; i < len(s); i++ {
+		length := uint(huffmanCodeLen[s[i]])
+		acc = acc<<length | uint64(huffmanCodes[s[i]])
+		n += length
+		for n >= 8 {
+			n -= 8
+			dst = append(dst, byte(acc>>n))
+		}
+	}
+	if n > 0 {
+		// the padding is the most significant bits of EOS, all ones
+		dst = append(dst, byte(acc<<(8-n))|byte(0xff>>n))
+	}
+	return dst
+}
+
+// One step in the decoding tree, a leaf once sym is set.
+type huffmanNode struct {
+	children [2]*huffmanNode
+	sym      byte
+	leaf     bool
+}
+
+var (
+	huffmanRoot     *huffmanNode
+	huffmanRootOnce sync.Once
+)
+
+func buildHuffmanTree() {
+	huffmanRoot = &huffmanNode{}
+	for sym := range 256 {
+		code, length := huffmanCodes[sym], huffmanCodeLen[sym]
+		node := huffmanRoot
+		for i := int(length) - 1; i >= 0; i-- {
+			bit := (code >> uint(i)) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &huffmanNode{}
+			}
+			node = node.children[bit]
+		}
+		node.sym = byte(sym)
+		node.leaf = true
+	}
+}
+
+// Decode Huffman data, appending to dst. EOS inside the data or padding that
+// isn't a short run of ones is an error (RFC 7541 5.2).
+func AppendHuffmanDecode(dst, src []byte) ([]byte, error) {
+	huffmanRootOnce.Do(buildHuffmanTree)
+	node := huffmanRoot
+	// bits read since the last symbol, and whether they were all ones
+	pending, ones := 0, true
+	for _, b := range src {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> uint(i)) & 1
+			node = node.children[bit]
+			if node == nil {
+				// only EOS leads off the tree
+				return nil, ErrInvalidHuffman
+			}
+			if node.leaf {
+				dst = append(dst, node.sym)
+				node = huffmanRoot
+				pending, ones = 0, true
+				continue
+			}
+			pending++
+			ones = ones && bit == 1
+		}
+	}
+	if pending > 7 || !ones {
+		return nil, ErrInvalidHuffman
+	}
+	return dst, nil
+}
@@ -0,0 +1,164 @@
+package hpack
+
+// RFC 7541 Appendix A, index 1 is the first entry.
+var staticTable = [...]HeaderField{
+	{Name: ":authority"},
+	{Name: ":method", Value: "GET"},
+	{Name: ":method", Value: "POST"},
+	{Name: ":path", Value: "/"},
+	{Name: ":path", Value: "/index.html"},
+	{Name: ":scheme", Value: "http"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":status", Value: "200"},
+	{Name: ":status", Value: "204"},
+	{Name: ":status", Value: "206"},
+	{Name: ":status", Value: "304"},
+	{Name: ":status", Value: "400"},
+	{Name: ":status", Value: "404"},
+	{Name: ":status", Value: "500"},
+	{Name: "accept-charset"},
+	{Name: "accept-encoding", Value: "gzip, deflate"},
+	{Name: "accept-language"},
+	{Name: "accept-ranges"},
+	{Name: "accept"},
+	{Name: "access-control-allow-origin"},
+	{Name: "age"},
+	{Name: "allow"},
+	{Name: "authorization"},
+	{Name: "cache-control"},
+	{Name: "content-disposition"},
+	{Name: "content-encoding"},
+	{Name: "content-language"},
+	{Name: "content-length"},
+	{Name: "content-location"},
+	{Name: "content-range"},
+	{Name: "content-type"},
+	{Name: "cookie"},
+	{Name: "date"},
+	{Name: "etag"},
+	{Name: "expect"},
+	{Name: "expires"},
+	{Name: "from"},
+	{Name: "host"},
+	{Name: "if-match"},
+	{Name: "if-modified-since"},
+	{Name: "if-none-match"},
+	{Name: "if-range"},
+	{Name: "if-unmodified-since"},
+	{Name: "last-modified"},
+	{Name: "link"},
+	{Name: "location"},
+	{Name: "max-forwards"},
+	{Name: "proxy-authenticate"},
+	{Name: "proxy-authorization"},
+	{Name: "range"},
+	{Name: "referer"},
+	{Name: "refresh"},
+	{Name: "retry-after"},
+	{Name: "server"},
+	{Name: "set-cookie"},
+	{Name: "strict-transport-security"},
+	{Name: "transfer-encoding"},
+	{Name: "user-agent"},
+	{Name: "vary"},
+	{Name: "via"},
+	{Name: "www-authenticate"},
+}
+
+// For the encoder: where a whole field and where just a name sits in
+// the static table. The lowest index wins for names that repeat.
+var (
+	staticFieldIndex = map[HeaderField]uint64{}
+	staticNameIndex  = map[string]uint64{}
+)
+
+func init() {
+	for i, f := range staticTable {
+		index := uint64(i + 1)
+		if f.Value != "" {
+			staticFieldIndex[f] = index
+		}
+		if _, ok := staticNameIndex[f.Name]; !ok {
+			staticNameIndex[f.Name] = index
+		}
+	}
+}
+
+// Fields both sides remember, newest first in index order (RFC 7541 2.3.2).
+type dynamicTable struct {
+	// oldest first, so adding is an append
+	entries []HeaderField
+	size    uint32
+	maxSize uint32
+}
+
+func (t *dynamicTable) add(f HeaderField) {
+	t.entries = append(t.entries, f)
+	t.size += f.Size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(n uint32) {
+	t.maxSize = n
+	t.evict()
+}
+
+// Drop the oldest entries until we fit. An entry bigger than the whole
+// table empties it (RFC 7541 4.4).
+func (t *dynamicTable) evict() {
+	drop := 0
+	for t.size > t.maxSize && drop < len(t.entries) {
+		t.size -= t.entries[drop].Size()
+		drop++
+	}
+	if drop > 0 {
+		t.entries = append(t.entries[:0], t.entries[drop:]...)
+	}
+}
+
+func (t *dynamicTable) len() int {
+	return len(t.entries)
+}
+
+// i counts from 1, the newest entry.
+func (t *dynamicTable) get(i int) HeaderField {
+	return t.entries[len(t.entries)-i]
+}
+
+// Look up an index (static then dynamic) from the wire.
+func (t *dynamicTable) at(index uint64) (HeaderField, bool) {
+	if index == 0 {
+		return HeaderField{}, false
+	}
+	if index <= uint64(len(staticTable)) {
+		return staticTable[index-1], true
+	}
+	i := index - uint64(len(staticTable))
+	if i > uint64(t.len()) {
+		return HeaderField{}, false
+	}
+	return t.get(int(i)), true
+}
+
+// The best index for f: a full match if there is one (nameOnly false),
+// otherwise a name match, 0 for neither.
+func (t *dynamicTable) search(f HeaderField) (index uint64, nameOnly bool) {
+	if i, ok := staticFieldIndex[HeaderField{Name: f.Name, Value: f.Value}]; ok {
+		return i, false
+	}
+	nameIndex := staticNameIndex[f.Name]
+	for i := 1; i <= t.len(); i++ {
+		entry := t.get(i)
+		if entry.Name != f.Name {
+			continue
+		}
+		dynIndex := uint64(len(staticTable) + i)
+		if entry.Value == f.Value {
+			return dynIndex, false
+		}
+		if nameIndex == 0 {
+			nameIndex = dynIndex
+		}
+	}
+	return nameIndex, true
+}
@@ -0,0 +1,325 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+type FrameType uint8
+
+// RFC 9113 6
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+type Flags uint8
+
+const (
+	FlagEndStream  Flags = 0x1
+	FlagAck        Flags = 0x1 // SETTINGS and PING
+	FlagEndHeaders Flags = 0x4
+	FlagPadded     Flags = 0x8
+	FlagPriority   Flags = 0x20
+)
+
+func (f Flags) Has(flag Flags) bool {
+	return f&flag != 0
+}
+
+type ErrCode uint32
+
+// RFC 9113 7
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+// Ends the whole connection with a GOAWAY.
+type ConnError struct {
+	Code   ErrCode
+	Reason string
+}
+
+func (e *ConnError) Error() string {
+	return fmt.Sprintf("http2: connection error %d: %s", e.Code, e.Reason)
+}
+
+// Ends one stream with a RST_STREAM, the connection goes on.
+type StreamError struct {
+	StreamID uint32
+	Code     ErrCode
+	Reason   string
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error %d: %s", e.StreamID, e.Code, e.Reason)
+}
+
+const frameHeaderLen = 9
+
+// Smallest and largest SETTINGS_MAX_FRAME_SIZE (RFC 9113 6.5.2).
+const (
+	minMaxFrameSize = 1 << 14
+	maxMaxFrameSize = 1<<24 - 1
+)
+
+// Largest flow control window (RFC 9113 6.9.1).
+const maxWindowSize = 1<<31 - 1
+
+// A frame as it came off the wire, Payload still in its raw form.
+type Frame struct {
+	Type     FrameType
+	Flags    Flags
+	StreamID uint32
+	Payload  []byte
+}
+
+// Reads and writes frames on a connection. Reads happen on one goroutine,
+// writes may come from many and never interleave.
+type Framer struct {
+	r io.Reader
+	// largest payload we accept, our SETTINGS_MAX_FRAME_SIZE
+	maxReadSize uint32
+	header      [frameHeaderLen]byte
+
+	wmu sync.Mutex
+	w   io.Writer
+}
+
+func NewFramer(w io.Writer, r io.Reader) *Framer {
+	return &Framer{r: r, w: w, maxReadSize: minMaxFrameSize}
+}
+
+func (f *Framer) ReadFrame() (*Frame, error) {
+	if _, err := io.ReadFull(f.r, f.header[:]); err != nil {
+		return nil, err
+	}
+	length := uint32(f.header[0])<<16 | uint32(f.header[1])<<8 | uint32(f.header[2])
+	if length > f.maxReadSize {
+		return nil, &ConnError{ErrCodeFrameSize, fmt.Sprintf("frame of %d bytes", length)}
+	}
+	frame := &Frame{
+		Type:  FrameType(f.header[3]),
+		Flags: Flags(f.header[4]),
+		// the top bit is reserved and ignored
+		StreamID: binary.BigEndian.Uint32(f.header[5:]) & 0x7fffffff,
+		Payload:  make([]byte, length),
+	}
+	if _, err := io.ReadFull(f.r, frame.Payload); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// One frame in one Write, so frames from different goroutines never mix.
+func (f *Framer) WriteFrame(t FrameType, flags Flags, streamID uint32, payload []byte) error {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	return f.writeFrameLocked(t, flags, streamID, payload)
+}
+
+func (f *Framer) writeFrameLocked(t FrameType, flags Flags, streamID uint32, payload []byte) error {
+	buf := make([]byte, frameHeaderLen, frameHeaderLen+len(payload))
+	n := len(payload)
+	buf[0], buf[1], buf[2] = byte(n>>16), byte(n>>8), byte(n)
+	buf[3] = byte(t)
+	buf[4] = byte(flags)
+	binary.BigEndian.PutUint32(buf[5:], streamID)
+	_, err := f.w.Write(append(buf, payload...))
+	return err
+}
+
+// HEADERS plus as many CONTINUATIONs as the block needs, back to back.
+// The block has to be encoded under the same lock as it is written, or
+// the peer's HPACK table goes out of step, hence encode.
+func (f *Framer) WriteHeaders(streamID uint32, endStream bool, maxFrameSize uint32, encode func() []byte) error {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	block := encode()
+	frameType, flags := FrameHeaders, Flags(0)
+	if endStream {
+		flags |= FlagEndStream
+	}
+	for {
+		fragment := block
+		last := uint32(len(block)) <= maxFrameSize
+		if !last {
+			fragment = block[:maxFrameSize]
+		}
+		block = block[len(fragment):]
+		if last {
+			flags |= FlagEndHeaders
+		}
+		if err := f.writeFrameLocked(frameType, flags, streamID, fragment); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		frameType, flags = FrameContinuation, 0
+	}
+}
+
+func (f *Framer) WriteData(streamID uint32, endStream bool, data []byte) error {
+	var flags Flags
+	if endStream {
+		flags = FlagEndStream
+	}
+	return f.WriteFrame(FrameData, flags, streamID, data)
+}
+
+type Setting struct {
+	ID    SettingID
+	Value uint32
+}
+
+type SettingID uint16
+
+// RFC 9113 6.5.2
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+func (f *Framer) WriteSettings(settings ...Setting) error {
+	payload := make([]byte, 0, 6*len(settings))
+	for _, s := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(s.ID))
+		payload = binary.BigEndian.AppendUint32(payload, s.Value)
+	}
+	return f.WriteFrame(FrameSettings, 0, 0, payload)
+}
+
+func (f *Framer) WriteSettingsAck() error {
+	return f.WriteFrame(FrameSettings, FlagAck, 0, nil)
+}
+
+func (f *Framer) WritePing(ack bool, data [8]byte) error {
+	var flags Flags
+	if ack {
+		flags = FlagAck
+	}
+	return f.WriteFrame(FramePing, flags, 0, data[:])
+}
+
+func (f *Framer) WriteWindowUpdate(streamID, increment uint32) error {
+	return f.WriteFrame(FrameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, increment))
+}
+
+func (f *Framer) WriteRSTStream(streamID uint32, code ErrCode) error {
+	return f.WriteFrame(FrameRSTStream, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+func (f *Framer) WriteGoAway(lastStreamID uint32, code ErrCode, debug string) error {
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	return f.WriteFrame(FrameGoAway, 0, 0, append(payload, debug...))
+}
+
+// The parts of a frame payload after padding (and for HEADERS, the
+// priority fields) are taken off.
+func (fr *Frame) content() ([]byte, error) {
+	p := fr.Payload
+	padLen := 0
+	if fr.Flags.Has(FlagPadded) && (fr.Type == FrameData || fr.Type == FrameHeaders) {
+		if len(p) < 1 {
+			return nil, &ConnError{ErrCodeFrameSize, "padded frame without a pad length"}
+		}
+		padLen = int(p[0])
+		p = p[1:]
+	}
+	if fr.Type == FrameHeaders && fr.Flags.Has(FlagPriority) {
+		if len(p) < 5 {
+			return nil, &ConnError{ErrCodeFrameSize, "headers frame too short for priority"}
+		}
+		p = p[5:]
+	}
+	// padding the size of the payload or more is a connection error (6.1)
+	if padLen > len(p) {
+		return nil, &ConnError{ErrCodeProtocol, "padding longer than the payload"}
+	}
+	return p[:len(p)-padLen], nil
+}
+
+func (fr *Frame) settings() ([]Setting, error) {
+	if fr.StreamID != 0 {
+		return nil, &ConnError{ErrCodeProtocol, "settings on a stream"}
+	}
+	if fr.Flags.Has(FlagAck) {
+		if len(fr.Payload) != 0 {
+			return nil, &ConnError{ErrCodeFrameSize, "settings ack with a payload"}
+		}
+		return nil, nil
+	}
+	if len(fr.Payload)%6 != 0 {
+		return nil, &ConnError{ErrCodeFrameSize, "settings payload not a multiple of 6"}
+	}
+	return parseSettings(fr.Payload)
+}
+
+func parseSettings(p []byte) ([]Setting, error) {
+	var settings []Setting
+	for ; len(p) >= 6; p = p[6:] {
+		s := Setting{
+			ID:    SettingID(binary.BigEndian.Uint16(p)),
+			Value: binary.BigEndian.Uint32(p[2:]),
+		}
+		switch s.ID {
+		case SettingEnablePush:
+			if s.Value > 1 {
+				return nil, &ConnError{ErrCodeProtocol, "enable push must be 0 or 1"}
+			}
+		case SettingInitialWindowSize:
+			if s.Value > maxWindowSize {
+				return nil, &ConnError{ErrCodeFlowControl, "initial window size too large"}
+			}
+		case SettingMaxFrameSize:
+			if s.Value < minMaxFrameSize || s.Value > maxMaxFrameSize {
+				return nil, &ConnError{ErrCodeProtocol, "max frame size out of range"}
+			}
+		}
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+func (fr *Frame) windowIncrement() (uint32, error) {
+	if len(fr.Payload) != 4 {
+		return 0, &ConnError{ErrCodeFrameSize, "window update not 4 bytes"}
+	}
+	increment := binary.BigEndian.Uint32(fr.Payload) & 0x7fffffff
+	if increment == 0 {
+		if fr.StreamID == 0 {
+			return 0, &ConnError{ErrCodeProtocol, "window update of 0"}
+		}
+		return 0, &StreamError{fr.StreamID, ErrCodeProtocol, "window update of 0"}
+	}
+	return increment, nil
+}
@@ -0,0 +1,503 @@
+// HTTP/2 over cleartext TCP (h2c, RFC 9113), served through the same
+// handlers as HTTP/1.1.
+package http2
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/WaronLimsakul/learn_http/internal/hpack"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// The first thing a client sends on an HTTP/2 connection (RFC 9113 3.4).
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// Same shape as server.Handler, which converts to it.
+type Handler func(w *response.Writer, req *request.Request)
+
+// What we announce in our SETTINGS.
+const (
+	maxConcurrentStreams = 100
+	maxHeaderListSize    = 1 << 20
+	// per stream and for the connection. Bigger than the default 64KB so
+	// uploads don't keep stopping to wait for WINDOW_UPDATEs.
+	initialWindowSize = 1 << 20
+)
+
+// Windows before any SETTINGS or WINDOW_UPDATE (RFC 9113 6.9.2).
+const defaultWindowSize = 65535
+
+// A header block that goes on in CONTINUATION frames is capped at this,
+// so a client can't make us hold an endless one.
+const maxHeaderBlockSize = 2 * maxHeaderListSize
+
+var errStreamClosed = errors.New("http2: stream closed")
+
+type serverConn struct {
+	conn       net.Conn
+	framer     *Framer
+	handler    Handler
+	remoteAddr string
+	// parent of every stream's context, cancelled when the connection ends
+	ctx    context.Context
+	cancel context.CancelFunc
+	// handlers still running
+	handlers sync.WaitGroup
+
+	// only touched by the read loop
+	decoder *hpack.Decoder
+	// a header block still waiting for CONTINUATIONs
+	continuing *headerBlock
+	recvWindow int64
+
+	mu sync.Mutex
+	// broadcast whenever a send window grows or a stream or the connection ends
+	cond    *sync.Cond
+	streams map[uint32]*stream
+	// highest stream the client opened, only the read loop changes it
+	lastStreamID uint32
+	// only used while holding the framer's write lock, see writeHeaders
+	encoder           *hpack.Encoder
+	sendWindow        int64
+	peerInitialWindow int64
+	peerMaxFrameSize  uint32
+	// no new streams once we sent GOAWAY
+	goingAway bool
+	closed    bool
+}
+
+type headerBlock struct {
+	streamID  uint32
+	endStream bool
+	block     []byte
+}
+
+// Serve HTTP/2 on conn until the client leaves, a protocol error ends it
+// or ctx is done. conn isn't closed, that's the caller's job.
+//
+// upgrade is the HTTP/1.1 request that asked for h2c, after the 101 went
+// out. It becomes stream 1. nil means the client starts with the preface.
+func ServeConn(ctx context.Context, conn net.Conn, handler Handler, upgrade *request.Request) error {
+	sc := &serverConn{
+		conn:              conn,
+		framer:            NewFramer(conn, bufio.NewReader(conn)),
+		handler:           handler,
+		remoteAddr:        conn.RemoteAddr().String(),
+		decoder:           hpack.NewDecoder(hpack.DefaultTableSize),
+		recvWindow:        defaultWindowSize,
+		streams:           map[uint32]*stream{},
+		encoder:           hpack.NewEncoder(),
+		sendWindow:        defaultWindowSize,
+		peerInitialWindow: defaultWindowSize,
+		peerMaxFrameSize:  minMaxFrameSize,
+	}
+	sc.ctx, sc.cancel = context.WithCancel(ctx)
+	sc.cond = sync.NewCond(&sc.mu)
+	sc.decoder.MaxHeaderListSize = maxHeaderListSize
+
+	// server shutdown: say goodbye and wake the read loop up
+	stop := context.AfterFunc(ctx, func() {
+		sc.goAway(ErrCodeNo, "")
+		conn.Close()
+	})
+	defer stop()
+	defer sc.close()
+
+	err := sc.serve(upgrade)
+	var connErr *ConnError
+	if errors.As(err, &connErr) {
+		sc.goAway(connErr.Code, connErr.Reason)
+	}
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+func (sc *serverConn) serve(upgrade *request.Request) error {
+	// our preface is a SETTINGS frame, it has to be the first thing we send
+	err := sc.framer.WriteSettings(
+		Setting{SettingMaxConcurrentStreams, maxConcurrentStreams},
+		Setting{SettingInitialWindowSize, initialWindowSize},
+		Setting{SettingMaxHeaderListSize, maxHeaderListSize},
+		Setting{SettingEnablePush, 0},
+	)
+	if err != nil {
+		return err
+	}
+	if err := sc.framer.WriteWindowUpdate(0, initialWindowSize-defaultWindowSize); err != nil {
+		return err
+	}
+	sc.recvWindow = initialWindowSize
+
+	if upgrade != nil {
+		if err := sc.startUpgradeStream(upgrade); err != nil {
+			return err
+		}
+	}
+
+	preface := make([]byte, len(ClientPreface))
+	if _, err := io.ReadFull(sc.framer.r, preface); err != nil {
+		return err
+	}
+	if string(preface) != ClientPreface {
+		return &ConnError{ErrCodeProtocol, "bad connection preface"}
+	}
+	// the client's preface goes on with a SETTINGS frame
+	first := true
+	for {
+		frame, err := sc.framer.ReadFrame()
+		if err != nil {
+			return err
+		}
+		if first && frame.Type != FrameSettings {
+			return &ConnError{ErrCodeProtocol, "preface without settings"}
+		}
+		first = false
+		err = sc.processFrame(frame)
+		var streamErr *StreamError
+		if errors.As(err, &streamErr) {
+			sc.resetStream(streamErr.StreamID, streamErr.Code)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Wake every blocked writer, cancel every stream and wait for the handlers.
+func (sc *serverConn) close() {
+	sc.mu.Lock()
+	sc.closed = true
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	sc.cancel()
+	sc.handlers.Wait()
+}
+
+func (sc *serverConn) goAway(code ErrCode, debug string) {
+	sc.mu.Lock()
+	if sc.goingAway {
+		sc.mu.Unlock()
+		return
+	}
+	sc.goingAway = true
+	last := sc.lastStreamID
+	sc.mu.Unlock()
+	sc.framer.WriteGoAway(last, code, debug)
+}
+
+func (sc *serverConn) processFrame(f *Frame) error {
+	if sc.continuing != nil {
+		if f.Type != FrameContinuation || f.StreamID != sc.continuing.streamID {
+			return &ConnError{ErrCodeProtocol, "expected a continuation"}
+		}
+		return sc.onContinuation(f)
+	}
+
+	switch f.Type {
+	case FrameData:
+		return sc.onData(f)
+	case FrameHeaders:
+		return sc.onHeaders(f)
+	case FramePriority:
+		if f.StreamID == 0 {
+			return &ConnError{ErrCodeProtocol, "priority on stream 0"}
+		}
+		if len(f.Payload) != 5 {
+			return &StreamError{f.StreamID, ErrCodeFrameSize, "priority not 5 bytes"}
+		}
+		// we don't prioritize, every stream gets what it can
+		return nil
+	case FrameRSTStream:
+		return sc.onRSTStream(f)
+	case FrameSettings:
+		return sc.onSettings(f)
+	case FramePushPromise:
+		return &ConnError{ErrCodeProtocol, "clients can't push"}
+	case FramePing:
+		if f.StreamID != 0 {
+			return &ConnError{ErrCodeProtocol, "ping on a stream"}
+		}
+		if len(f.Payload) != 8 {
+			return &ConnError{ErrCodeFrameSize, "ping not 8 bytes"}
+		}
+		if f.Flags.Has(FlagAck) {
+			return nil
+		}
+		return sc.framer.WritePing(true, [8]byte(f.Payload))
+	case FrameGoAway:
+		if f.StreamID != 0 {
+			return &ConnError{ErrCodeProtocol, "goaway on a stream"}
+		}
+		// the client won't start anything new, what is running finishes
+		// and then it hangs up
+		return nil
+	case FrameWindowUpdate:
+		return sc.onWindowUpdate(f)
+	case FrameContinuation:
+		return &ConnError{ErrCodeProtocol, "continuation without headers"}
+	}
+	// unknown frame types are ignored (RFC 9113 4.1)
+	return nil
+}
+
+func (sc *serverConn) onHeaders(f *Frame) error {
+	if f.StreamID == 0 {
+		return &ConnError{ErrCodeProtocol, "headers on stream 0"}
+	}
+	content, err := f.content()
+	if err != nil {
+		return err
+	}
+	block := &headerBlock{
+		streamID:  f.StreamID,
+		endStream: f.Flags.Has(FlagEndStream),
+		block:     append([]byte(nil), content...),
+	}
+	if !f.Flags.Has(FlagEndHeaders) {
+		sc.continuing = block
+		return nil
+	}
+	return sc.onHeaderBlock(block)
+}
+
+func (sc *serverConn) onContinuation(f *Frame) error {
+	block := sc.continuing
+	if len(block.block)+len(f.Payload) > maxHeaderBlockSize {
+		return &ConnError{ErrCodeEnhanceYourCalm, "header block too large"}
+	}
+	block.block = append(block.block, f.Payload...)
+	if !f.Flags.Has(FlagEndHeaders) {
+		return nil
+	}
+	sc.continuing = nil
+	return sc.onHeaderBlock(block)
+}
+
+// A complete header block: a new request or a request's trailers.
+func (sc *serverConn) onHeaderBlock(block *headerBlock) error {
+	id := block.streamID
+	// decode no matter what happens to the stream, or our table drifts
+	// away from the client's
+	fields, err := sc.decoder.Decode(block.block)
+	tooLarge := errors.Is(err, hpack.ErrHeaderListTooLarge)
+	if err != nil && !tooLarge {
+		return &ConnError{ErrCodeCompression, err.Error()}
+	}
+
+	sc.mu.Lock()
+	st := sc.streams[id]
+	sc.mu.Unlock()
+	if st != nil {
+		if st.remoteDone {
+			return &StreamError{id, ErrCodeStreamClosed, "headers after end of stream"}
+		}
+		if !block.endStream {
+			return &StreamError{id, ErrCodeProtocol, "trailers without end of stream"}
+		}
+		if tooLarge {
+			return &StreamError{id, ErrCodeProtocol, "trailers too large"}
+		}
+		trailers, err := trailersFromFields(fields)
+		if err != nil {
+			return &StreamError{id, ErrCodeProtocol, err.Error()}
+		}
+		st.req.Trailers = trailers
+		return sc.endRemote(st)
+	}
+
+	if id%2 == 0 {
+		return &ConnError{ErrCodeProtocol, "client stream with an even id"}
+	}
+	if id <= sc.lastStreamID {
+		return &StreamError{id, ErrCodeStreamClosed, "headers on a closed stream"}
+	}
+	sc.mu.Lock()
+	sc.lastStreamID = id
+	goingAway, active := sc.goingAway, len(sc.streams)
+	sc.mu.Unlock()
+	if goingAway {
+		// past our GOAWAY, the client knows this one never happened
+		return nil
+	}
+	if active >= maxConcurrentStreams {
+		return &StreamError{id, ErrCodeRefusedStream, "too many streams"}
+	}
+	if tooLarge {
+		return sc.writeStatusOnly(id, 431)
+	}
+	req, err := requestFromFields(fields)
+	if err != nil {
+		return &StreamError{id, ErrCodeProtocol, err.Error()}
+	}
+	req.RemoteAddr = sc.remoteAddr
+	st = sc.newStream(id, req)
+	if block.endStream {
+		return sc.endRemote(st)
+	}
+	return nil
+}
+
+func (sc *serverConn) onData(f *Frame) error {
+	if f.StreamID == 0 {
+		return &ConnError{ErrCodeProtocol, "data on stream 0"}
+	}
+	content, err := f.content()
+	if err != nil {
+		return err
+	}
+	// padding counts against the windows too
+	n := int64(len(f.Payload))
+	sc.recvWindow -= n
+	if sc.recvWindow < 0 {
+		return &ConnError{ErrCodeFlowControl, "connection window exceeded"}
+	}
+	if n > 0 {
+		// we keep every body in memory anyway, so hand the space back now
+		if err := sc.framer.WriteWindowUpdate(0, uint32(n)); err != nil {
+			return err
+		}
+		sc.recvWindow += n
+	}
+
+	sc.mu.Lock()
+	st := sc.streams[f.StreamID]
+	sc.mu.Unlock()
+	if st == nil || st.remoteDone {
+		if f.StreamID > sc.lastStreamID {
+			return &ConnError{ErrCodeProtocol, "data on an idle stream"}
+		}
+		return &StreamError{f.StreamID, ErrCodeStreamClosed, "data on a closed stream"}
+	}
+	st.recvWindow -= n
+	if st.recvWindow < 0 {
+		return &StreamError{f.StreamID, ErrCodeFlowControl, "stream window exceeded"}
+	}
+	st.req.Body = append(st.req.Body, content...)
+	if f.Flags.Has(FlagEndStream) {
+		return sc.endRemote(st)
+	}
+	if n > 0 {
+		st.recvWindow += n
+		return sc.framer.WriteWindowUpdate(f.StreamID, uint32(n))
+	}
+	return nil
+}
+
+func (sc *serverConn) onRSTStream(f *Frame) error {
+	if f.StreamID == 0 {
+		return &ConnError{ErrCodeProtocol, "rst_stream on stream 0"}
+	}
+	if len(f.Payload) != 4 {
+		return &ConnError{ErrCodeFrameSize, "rst_stream not 4 bytes"}
+	}
+	if f.StreamID > sc.lastStreamID {
+		return &ConnError{ErrCodeProtocol, "rst_stream on an idle stream"}
+	}
+	sc.mu.Lock()
+	if st := sc.streams[f.StreamID]; st != nil {
+		sc.removeStreamLocked(st)
+	}
+	sc.mu.Unlock()
+	return nil
+}
+
+func (sc *serverConn) onSettings(f *Frame) error {
+	settings, err := f.settings()
+	if err != nil || f.Flags.Has(FlagAck) {
+		return err
+	}
+	sc.mu.Lock()
+	for _, s := range settings {
+		switch s.ID {
+		case SettingHeaderTableSize:
+			sc.encoder.SetMaxTableSize(s.Value)
+		case SettingInitialWindowSize:
+			// applies to the windows of open streams too (RFC 9113 6.9.2)
+			delta := int64(s.Value) - sc.peerInitialWindow
+			sc.peerInitialWindow = int64(s.Value)
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					sc.mu.Unlock()
+					return &ConnError{ErrCodeFlowControl, "stream window too large"}
+				}
+			}
+		case SettingMaxFrameSize:
+			sc.peerMaxFrameSize = s.Value
+		}
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	return sc.framer.WriteSettingsAck()
+}
+
+func (sc *serverConn) onWindowUpdate(f *Frame) error {
+	increment, err := f.windowIncrement()
+	if err != nil {
+		return err
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if f.StreamID == 0 {
+		sc.sendWindow += int64(increment)
+		if sc.sendWindow > maxWindowSize {
+			return &ConnError{ErrCodeFlowControl, "connection window too large"}
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+	st := sc.streams[f.StreamID]
+	if st == nil {
+		if f.StreamID > sc.lastStreamID {
+			return &ConnError{ErrCodeProtocol, "window update on an idle stream"}
+		}
+		// the stream is gone, nothing to send on it anymore
+		return nil
+	}
+	st.sendWindow += int64(increment)
+	if st.sendWindow > maxWindowSize {
+		return &StreamError{f.StreamID, ErrCodeFlowControl, "stream window too large"}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+// Tell the client the stream is over and forget it.
+func (sc *serverConn) resetStream(id uint32, code ErrCode) {
+	sc.mu.Lock()
+	if st := sc.streams[id]; st != nil {
+		sc.removeStreamLocked(st)
+	}
+	sc.mu.Unlock()
+	sc.framer.WriteRSTStream(id, code)
+}
+
+// Answer a stream with nothing but a status, e.g. when we can't even
+// build its request.
+func (sc *serverConn) writeStatusOnly(id uint32, code response.StatusCode) error {
+	fields := []hpack.HeaderField{{Name: ":status", Value: fmt.Sprint(int(code))}}
+	return sc.writeHeaders(id, true, fields)
+}
+
+// Encoding and writing happen under one lock, blocks reach the client in
+// the order the encoder saw them.
+func (sc *serverConn) writeHeaders(id uint32, endStream bool, fields []hpack.HeaderField) error {
+	sc.mu.Lock()
+	maxFrameSize := sc.peerMaxFrameSize
+	sc.mu.Unlock()
+	return sc.framer.WriteHeaders(id, endStream, maxFrameSize, func() []byte {
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		return sc.encoder.Encode(nil, fields)
+	})
+}
@@ -0,0 +1,318 @@
+package http2
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/cookie"
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+	"github.com/WaronLimsakul/learn_http/internal/hpack"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// Accept connections on a local port and speak HTTP/2 on every one.
+func serve(t *testing.T, handler Handler) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		cancel()
+		listener.Close()
+		wg.Wait()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				ServeConn(ctx, conn, handler, nil)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// net/http's client, talking HTTP/2 without TLS or an upgrade.
+func h2cClient() *http.Client {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: protocols}}
+}
+
+func writeText(w *response.Writer, code response.StatusCode, text string) {
+	w.WriteStatusLine(code)
+	w.WriteHeaders(response.GetDefaultHeaders(len(text)))
+	w.WriteBody([]byte(text))
+}
+
+func testHandler(w *response.Writer, req *request.Request) {
+	switch req.RequestLine.RequestTarget {
+	case "/echo":
+		cookie, _ := req.Headers.Get("Cookie")
+		host, _ := req.Headers.Get("Host")
+		writeText(w, response.StatusOK, fmt.Sprintf("%s %s %s|%s|%s", req.RequestLine.Method, req.RequestLine.HttpVersion, host, cookie, req.Body))
+	case "/big":
+		body := bytes.Repeat([]byte("0123456789"), 100_000)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	case "/chunked":
+		w.WriteStatusLine(response.StatusOK)
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Checksum")
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("Trailer", "X-Checksum")
+		trailers.Set("X-Checksum", "abc")
+		w.WriteTrailers(trailers)
+	case "/cookies":
+		w.SetCookie(&cookie.Cookie{Name: "a", Value: "1", Expires: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)})
+		w.SetCookie(&cookie.Cookie{Name: "b", Value: "2"})
+		writeText(w, response.StatusOK, "")
+	case "/wait":
+		<-req.Context().Done()
+	default:
+		writeText(w, response.StatusNotFound, "not found")
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	addr := serve(t, testHandler)
+	c := h2cClient()
+
+	// Test: a plain GET, pseudo-headers turned into the request line
+	res, err := c.Get("http://" + addr + "/echo")
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "HTTP/2.0", res.Proto)
+	assert.Equal(t, "GET 2 "+addr+"||", string(body))
+	assert.Equal(t, "text/plain", res.Header.Get("Content-Type"))
+	// HTTP/1.1 framing headers don't make it into HTTP/2
+	assert.Empty(t, res.Header.Get("Connection"))
+
+	// Test: a body and split cookies come through
+	req, err := http.NewRequest("POST", "http://"+addr+"/echo", strings.NewReader("ping"))
+	require.NoError(t, err)
+	req.Header.Add("Cookie", "a=1")
+	req.Header.Add("Cookie", "b=2")
+	res, err = c.Do(req)
+	require.NoError(t, err)
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, "POST 2 "+addr+"|a=1; b=2|ping", string(body))
+
+	// Test: a body larger than any window
+	res, err = c.Get("http://" + addr + "/big")
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Len(t, body, 1_000_000)
+
+	// Test: chunks become DATA frames, trailers a HEADERS frame
+	res, err = c.Get("http://" + addr + "/chunked")
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "abc", res.Trailer.Get("X-Checksum"))
+
+	// Test: every cookie is a field of its own
+	res, err = c.Get("http://" + addr + "/cookies")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Len(t, res.Cookies(), 2)
+	assert.Equal(t, "a=1; Expires=Wed, 02 Jan 2030 03:04:05 GMT", res.Header.Values("Set-Cookie")[0])
+
+	// Test: HEAD gets the headers only
+	res, err = c.Head("http://" + addr + "/big")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, int64(1_000_000), res.ContentLength)
+}
+
+func TestServeConnMultiplexing(t *testing.T) {
+	var started sync.WaitGroup
+	started.Add(10)
+	release := make(chan struct{})
+	addr := serve(t, func(w *response.Writer, req *request.Request) {
+		started.Done()
+		// every request waits for all the others, so they must be in
+		// flight at the same time
+		<-release
+		writeText(w, response.StatusOK, req.RequestLine.RequestTarget)
+	})
+	c := h2cClient()
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.Get(fmt.Sprintf("http://%s/%d", addr, i))
+			if !assert.NoError(t, err) {
+				return
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			assert.Equal(t, fmt.Sprintf("/%d", i), string(body))
+		}()
+	}
+	started.Wait()
+	close(release)
+	wg.Wait()
+}
+
+func TestServeConnCancel(t *testing.T) {
+	cancelled := make(chan struct{})
+	addr := serve(t, func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		close(cancelled)
+	})
+
+	// Test: the client giving up on a stream (RST_STREAM) cancels its context
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = h2cClient().Do(req)
+	assert.Error(t, err)
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler context never cancelled")
+	}
+}
+
+// A client made of our own framer and HPACK, for what net/http won't do.
+type rawClient struct {
+	t       *testing.T
+	conn    net.Conn
+	framer  *Framer
+	encoder *hpack.Encoder
+	decoder *hpack.Decoder
+}
+
+func dialRaw(t *testing.T, addr string, settings ...Setting) *rawClient {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c := &rawClient{
+		t:       t,
+		conn:    conn,
+		framer:  NewFramer(conn, conn),
+		encoder: hpack.NewEncoder(),
+		decoder: hpack.NewDecoder(hpack.DefaultTableSize),
+	}
+	_, err = conn.Write([]byte(ClientPreface))
+	require.NoError(t, err)
+	require.NoError(t, c.framer.WriteSettings(settings...))
+	return c
+}
+
+func (c *rawClient) get(streamID uint32, path string) {
+	block := c.encoder.Encode(nil, []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: path},
+		{Name: ":authority", Value: "example.com"},
+	})
+	require.NoError(c.t, c.framer.WriteFrame(FrameHeaders, FlagEndHeaders|FlagEndStream, streamID, block))
+}
+
+// Next frame that isn't connection housekeeping.
+func (c *rawClient) next() *Frame {
+	for {
+		f, err := c.framer.ReadFrame()
+		require.NoError(c.t, err)
+		switch f.Type {
+		case FrameSettings, FrameWindowUpdate, FramePing:
+			continue
+		case FrameHeaders:
+			_, err := c.decoder.Decode(f.Payload)
+			require.NoError(c.t, err)
+		}
+		return f
+	}
+}
+
+func TestServeConnFlowControl(t *testing.T) {
+	addr := serve(t, testHandler)
+	// the server may only send 100 bytes before we say so
+	c := dialRaw(t, addr, Setting{SettingInitialWindowSize, 100})
+	c.get(1, "/big")
+
+	headersFrame := c.next()
+	assert.Equal(t, FrameHeaders, headersFrame.Type)
+	data := c.next()
+	assert.Equal(t, FrameData, data.Type)
+	assert.Len(t, data.Payload, 100)
+
+	// nothing more until the window opens
+	c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := c.framer.ReadFrame()
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, c.framer.WriteWindowUpdate(1, 50))
+	data = c.next()
+	assert.Equal(t, FrameData, data.Type)
+	assert.Len(t, data.Payload, 50)
+}
+
+func TestServeConnErrors(t *testing.T) {
+	addr := serve(t, testHandler)
+
+	// Test: a malformed request only costs its stream
+	c := dialRaw(t, addr)
+	block := c.encoder.Encode(nil, []hpack.HeaderField{{Name: ":method", Value: "GET"}})
+	require.NoError(t, c.framer.WriteFrame(FrameHeaders, FlagEndHeaders|FlagEndStream, 1, block))
+	f := c.next()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(1), f.StreamID)
+	assert.Equal(t, ErrCodeProtocol, ErrCode(binary.BigEndian.Uint32(f.Payload)))
+	// the connection still works
+	c.get(3, "/echo")
+	assert.Equal(t, FrameHeaders, c.next().Type)
+
+	// Test: DATA on stream 0 ends the connection
+	c = dialRaw(t, addr)
+	require.NoError(t, c.framer.WriteData(0, false, []byte("x")))
+	f = c.next()
+	assert.Equal(t, FrameGoAway, f.Type)
+	assert.Equal(t, ErrCodeProtocol, ErrCode(binary.BigEndian.Uint32(f.Payload[4:])))
+	_, err := c.framer.ReadFrame()
+	assert.ErrorIs(t, err, io.EOF)
+}
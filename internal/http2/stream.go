@@ -0,0 +1,345 @@
+package http2
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+	"github.com/WaronLimsakul/learn_http/internal/hpack"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// One request/response exchange. The read loop fills in the request, the
+// handler's goroutine writes the response through it (response.Stream).
+type stream struct {
+	sc  *serverConn
+	id  uint32
+	req *request.Request
+	// cancelled when the stream is reset or the connection ends
+	ctx    context.Context
+	cancel context.CancelFunc
+	// HEAD responses carry no DATA, whatever the handler writes
+	isHead bool
+
+	// read loop only
+	remoteDone bool
+	recvWindow int64
+
+	// under sc.mu
+	sendWindow int64
+	// our END_STREAM or a RST_STREAM either way went out
+	endSent bool
+	reset   bool
+
+	// handler goroutine only
+	headersSent bool
+}
+
+func (sc *serverConn) newStream(id uint32, req *request.Request) *stream {
+	st := &stream{
+		sc:         sc,
+		id:         id,
+		req:        req,
+		isHead:     req.RequestLine.Method == "HEAD",
+		recvWindow: initialWindowSize,
+	}
+	st.ctx, st.cancel = context.WithCancel(sc.ctx)
+	sc.mu.Lock()
+	st.sendWindow = sc.peerInitialWindow
+	sc.streams[id] = st
+	sc.mu.Unlock()
+	return st
+}
+
+// The client sent its END_STREAM, the request is complete: run the handler.
+func (sc *serverConn) endRemote(st *stream) error {
+	st.remoteDone = true
+	// a Content-Length that lies about the body makes the request
+	// malformed (RFC 9113 8.1.1)
+	if val, ok := st.req.Headers.Get("Content-Length"); ok {
+		n, err := strconv.Atoi(val)
+		if err != nil || n != len(st.req.Body) {
+			return &StreamError{st.id, ErrCodeProtocol, "content-length doesn't match the body"}
+		}
+	}
+	sc.handlers.Add(1)
+	go st.run()
+	return nil
+}
+
+func (st *stream) run() {
+	defer st.sc.handlers.Done()
+	w := response.NewStreamWriter(st)
+	st.sc.handler(w, st.req.WithContext(st.ctx))
+	st.finish()
+}
+
+// After the handler: end whatever it left open and forget the stream.
+func (st *stream) finish() {
+	sc := st.sc
+	sc.mu.Lock()
+	open := !st.endSent && !st.reset
+	sc.mu.Unlock()
+	if open {
+		if st.headersSent {
+			// e.g. a fixed-length body written in parts, or no body at all
+			st.WriteData(nil, true)
+		} else {
+			// the handler never answered
+			sc.framer.WriteRSTStream(st.id, ErrCodeInternal)
+		}
+	}
+	sc.mu.Lock()
+	sc.removeStreamLocked(st)
+	sc.mu.Unlock()
+}
+
+// Caller holds sc.mu.
+func (sc *serverConn) removeStreamLocked(st *stream) {
+	st.reset = st.reset || !st.endSent
+	delete(sc.streams, st.id)
+	st.cancel()
+	sc.cond.Broadcast()
+}
+
+// Stream 1 of an upgraded connection, its request already read as HTTP/1.1.
+func (sc *serverConn) startUpgradeStream(req *request.Request) error {
+	settings, err := upgradeSettings(req)
+	if err != nil {
+		return err
+	}
+	// as if they came in a SETTINGS frame
+	for _, s := range settings {
+		switch s.ID {
+		case SettingHeaderTableSize:
+			sc.encoder.SetMaxTableSize(s.Value)
+		case SettingInitialWindowSize:
+			sc.peerInitialWindow = int64(s.Value)
+		case SettingMaxFrameSize:
+			sc.peerMaxFrameSize = s.Value
+		}
+	}
+	// hop-by-hop, they were about the switch
+	for _, name := range []string{"Connection", "Upgrade", "HTTP2-Settings"} {
+		req.Headers.Delete(name)
+	}
+	sc.mu.Lock()
+	sc.lastStreamID = 1
+	sc.mu.Unlock()
+	st := sc.newStream(1, req)
+	return sc.endRemote(st)
+}
+
+// Headers that only mean something to one HTTP/1.1 connection. They are
+// malformed in HTTP/2 requests and dropped from responses (RFC 9113 8.2.2).
+var connectionSpecific = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+func (st *stream) WriteHeaders(code response.StatusCode, h headers.Headers, cookies []string) error {
+	if code == response.StatusSwitchingProtocols {
+		return fmt.Errorf("http2: no protocol switching on a stream")
+	}
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(int(code))}}
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	// same order every time, the encoder's table gets more hits
+	sort.Strings(names)
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		if connectionSpecific[lower] {
+			continue
+		}
+		fields = append(fields, hpack.HeaderField{Name: lower, Value: h[name]})
+	}
+	for _, c := range cookies {
+		fields = append(fields, hpack.HeaderField{Name: "set-cookie", Value: c})
+	}
+
+	sc := st.sc
+	sc.mu.Lock()
+	gone := st.reset || sc.closed
+	sc.mu.Unlock()
+	if gone {
+		return errStreamClosed
+	}
+	if err := sc.writeHeaders(st.id, false, fields); err != nil {
+		return err
+	}
+	// 1xx responses come before the real one
+	if code >= 200 {
+		st.headersSent = true
+	}
+	return nil
+}
+
+// Send p as DATA frames, as fast as the windows allow.
+func (st *stream) WriteData(p []byte, endStream bool) (int, error) {
+	total := len(p)
+	if st.isHead {
+		p = nil
+	}
+	if len(p) == 0 && !endStream {
+		return total, nil
+	}
+	sc := st.sc
+	for {
+		sc.mu.Lock()
+		for len(p) > 0 && !st.reset && !sc.closed && (sc.sendWindow <= 0 || st.sendWindow <= 0) {
+			sc.cond.Wait()
+		}
+		if st.reset || sc.closed || st.endSent {
+			sc.mu.Unlock()
+			return total - len(p), errStreamClosed
+		}
+		n := min(int64(len(p)), sc.sendWindow, st.sendWindow, int64(sc.peerMaxFrameSize))
+		sc.sendWindow -= n
+		st.sendWindow -= n
+		last := endStream && n == int64(len(p))
+		if last {
+			st.endSent = true
+		}
+		sc.mu.Unlock()
+
+		if err := sc.framer.WriteData(st.id, last, p[:n]); err != nil {
+			return total - len(p), err
+		}
+		p = p[n:]
+		if len(p) == 0 {
+			return total, nil
+		}
+	}
+}
+
+// Trailers are a HEADERS frame that ends the stream, none at all is an
+// empty DATA frame that does.
+func (st *stream) WriteTrailers(h headers.Headers) error {
+	if len(h) == 0 {
+		_, err := st.WriteData(nil, true)
+		return err
+	}
+	var fields []hpack.HeaderField
+	for name, val := range h {
+		fields = append(fields, hpack.HeaderField{Name: strings.ToLower(name), Value: val})
+	}
+	sc := st.sc
+	sc.mu.Lock()
+	if st.reset || sc.closed || st.endSent {
+		sc.mu.Unlock()
+		return errStreamClosed
+	}
+	st.endSent = true
+	sc.mu.Unlock()
+	return sc.writeHeaders(st.id, true, fields)
+}
+
+// Turn a request's header block into the Request handlers know (RFC 9113 8.3.1).
+func requestFromFields(fields []hpack.HeaderField) (*request.Request, error) {
+	req := &request.Request{Headers: headers.NewHeaders()}
+	pseudo := map[string]string{}
+	var cookies []string
+	regular := false
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			if regular {
+				return nil, fmt.Errorf("pseudo-header %s after a regular field", f.Name)
+			}
+			switch f.Name {
+			case ":method", ":scheme", ":path", ":authority":
+			default:
+				return nil, fmt.Errorf("unknown pseudo-header %s", f.Name)
+			}
+			if _, dup := pseudo[f.Name]; dup {
+				return nil, fmt.Errorf("pseudo-header %s twice", f.Name)
+			}
+			pseudo[f.Name] = f.Value
+			continue
+		}
+		regular = true
+		if err := checkField(f); err != nil {
+			return nil, err
+		}
+		if f.Name == "te" && f.Value != "trailers" {
+			return nil, fmt.Errorf("te other than trailers")
+		}
+		// cookies may come one crumb per field (RFC 9113 8.2.3)
+		if f.Name == "cookie" {
+			cookies = append(cookies, f.Value)
+			continue
+		}
+		req.Headers.Set(f.Name, f.Value)
+	}
+	if len(cookies) > 0 {
+		req.Headers.Reset("cookie", strings.Join(cookies, "; "))
+	}
+
+	method, authority := pseudo[":method"], pseudo[":authority"]
+	target := pseudo[":path"]
+	if method == "" {
+		return nil, fmt.Errorf("missing :method")
+	}
+	if method == "CONNECT" {
+		// authority-form, like HTTP/1.1 (RFC 9113 8.5)
+		if authority == "" || pseudo[":scheme"] != "" || target != "" {
+			return nil, fmt.Errorf("malformed CONNECT")
+		}
+		target = authority
+	} else if pseudo[":scheme"] == "" || target == "" {
+		return nil, fmt.Errorf("missing :scheme or :path")
+	}
+	if _, ok := req.Headers.Get("Host"); !ok && authority != "" {
+		req.Headers.Set("Host", authority)
+	}
+	req.RequestLine = request.RequestLine{
+		Method:        method,
+		RequestTarget: target,
+		HttpVersion:   "2",
+	}
+	return req, nil
+}
+
+func trailersFromFields(fields []hpack.HeaderField) (headers.Headers, error) {
+	trailers := headers.NewHeaders()
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			return nil, fmt.Errorf("pseudo-header %s in trailers", f.Name)
+		}
+		if err := checkField(f); err != nil {
+			return nil, err
+		}
+		trailers.Set(f.Name, f.Value)
+	}
+	return trailers, nil
+}
+
+// Names are lowercase tokens, HTTP/1.1-only fields have no place here.
+func checkField(f hpack.HeaderField) error {
+	if f.Name == "" {
+		return fmt.Errorf("empty field name")
+	}
+	for i := 0; i < len(f.Name); i++ {
+		ch := f.Name[i]
+		if !((ch >= 'a' && ch <= 'z') ||
+			(ch >= '0' && ch <= '9') ||
+			strings.IndexByte("!#$%&'*+-.^_`|~", ch) >= 0) {
+			return fmt.Errorf("invalid field name %q", f.Name)
+		}
+	}
+	if strings.ContainsAny(f.Value, "\r\n\x00") {
+		return fmt.Errorf("invalid value for %s", f.Name)
+	}
+	if connectionSpecific[f.Name] {
+		return fmt.Errorf("connection-specific field %s", f.Name)
+	}
+	return nil
+}
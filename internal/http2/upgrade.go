@@ -0,0 +1,35 @@
+package http2
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+)
+
+// Whether req asks to switch to h2c (RFC 7540 3.2) in a way we can
+// follow. The caller answers with 101 and hands the connection to
+// ServeConn, or ignores the Upgrade and serves HTTP/1.1 as usual.
+func IsUpgrade(req *request.Request) bool {
+	_, err := upgradeSettings(req)
+	return err == nil
+}
+
+// The client's SETTINGS, sent along in HTTP2-Settings as base64url.
+func upgradeSettings(req *request.Request) ([]Setting, error) {
+	h := req.Headers
+	if !h.HasToken("Upgrade", "h2c") || !h.HasToken("Connection", "upgrade") || !h.HasToken("Connection", "http2-settings") {
+		return nil, fmt.Errorf("http2: not an h2c upgrade")
+	}
+	encoded, ok := h.Get("HTTP2-Settings")
+	// more than one would have been joined with a comma
+	if !ok || strings.Contains(encoded, ",") {
+		return nil, fmt.Errorf("http2: need exactly one HTTP2-Settings")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(encoded), "="))
+	if err != nil || len(payload)%6 != 0 {
+		return nil, fmt.Errorf("http2: bad HTTP2-Settings")
+	}
+	return parseSettings(payload)
+}
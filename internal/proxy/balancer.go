@@ -0,0 +1,360 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/WaronLimsakul/learn_http/internal/client"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+)
+
+type Strategy int
+
+const (
+	RoundRobin Strategy = iota
+	LeastConnections
+	// same value of Balancer.HashHeader always lands on the same backend
+	ConsistentHash
+)
+
+const (
+	DefaultMaxFails = 3
+	DefaultEjectFor = 30 * time.Second
+	// per health check
+	DefaultHealthTimeout = 5 * time.Second
+)
+
+// points per backend on the hash ring, more means a more even spread
+const virtualNodes = 100
+
+var ErrNoBackends = errors.New("no healthy backends")
+
+// One upstream in a Balancer, with the bookkeeping we pick by.
+type Backend struct {
+	*Upstream
+	active       atomic.Int64 // requests in flight
+	failures     atomic.Int64 // in a row
+	ejectedUntil atomic.Int64 // unix nano, passive health
+	unhealthy    atomic.Bool  // active health
+}
+
+func (b *Backend) Active() int64 {
+	return b.active.Load()
+}
+
+// Report whether the backend can take requests right now.
+func (b *Backend) Available() bool {
+	return !b.unhealthy.Load() && time.Now().UnixNano() >= b.ejectedUntil.Load()
+}
+
+type ringPoint struct {
+	hash    uint32
+	backend *Backend
+}
+
+// A Transport that spreads requests over several upstreams. Backends that
+// fail MaxFails times in a row are ejected for EjectFor, and idempotent
+// requests that fail are retried on another backend.
+type Balancer struct {
+	Backends []*Backend
+	Strategy Strategy
+	// header to hash on for ConsistentHash, e.g. "X-User-ID"
+	HashHeader string
+	// 0 means DefaultMaxFails / DefaultEjectFor
+	MaxFails int
+	EjectFor time.Duration
+	// extra attempts on other backends, only for idempotent requests
+	MaxRetries int
+	// how long one health check may take, 0 means DefaultHealthTimeout
+	HealthTimeout time.Duration
+
+	next atomic.Uint64
+	// built from Backends on first use, so they shouldn't change after that
+	ring        []ringPoint
+	ringOnce    sync.Once
+	stopHealth  chan struct{}
+	healthMutex sync.Mutex
+}
+
+// All backends share one client, so they share its connection pool too.
+func NewBalancer(strategy Strategy, c *client.Client, rawURLs ...string) (*Balancer, error) {
+	if len(rawURLs) == 0 {
+		return nil, fmt.Errorf("balancer needs at least one backend")
+	}
+	b := &Balancer{Strategy: strategy, MaxRetries: 1}
+	for _, rawURL := range rawURLs {
+		upstream, err := NewUpstream(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		upstream.Client = c
+		b.Backends = append(b.Backends, &Backend{Upstream: upstream})
+	}
+	return b, nil
+}
+
+func (b *Balancer) buildRing() {
+	for _, backend := range b.Backends {
+		for i := range virtualNodes {
+			b.ring = append(b.ring, ringPoint{
+				hash:    hashString(backend.URL.String() + "#" + strconv.Itoa(i)),
+				backend: backend,
+			})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool {
+		return b.ring[i].hash < b.ring[j].hash
+	})
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func (b *Balancer) RoundTrip(req *request.Request) (*client.Response, error) {
+	tried := map[*Backend]bool{}
+	attempts := 1
+	if client.Idempotent(req.RequestLine.Method) {
+		attempts += b.MaxRetries
+	}
+
+	var lastErr error = ErrNoBackends
+	// a 5xx we hand back if no other backend does better
+	var lastRes *client.Response
+	for range attempts {
+		// nobody is waiting for an answer anymore
+		if req.Context().Err() != nil {
+			break
+		}
+		backend := b.pick(req, tried)
+		if backend == nil {
+			break
+		}
+		tried[backend] = true
+
+		backend.active.Add(1)
+		// the Upstream changes target and Host, every attempt gets a copy
+		res, err := backend.RoundTrip(req.Clone())
+		if err != nil {
+			backend.active.Add(-1)
+			b.recordFailure(backend)
+			lastErr = err
+			log.Printf("balancer: %s failed: %v", backend.URL, err)
+			continue
+		}
+		res.Body = &trackedBody{ReadCloser: res.Body, backend: backend}
+		if !retryableStatus(int(res.StatusCode)) {
+			b.recordSuccess(backend)
+			if lastRes != nil {
+				lastRes.Body.Close()
+			}
+			return res, nil
+		}
+		b.recordFailure(backend)
+		if lastRes != nil {
+			lastRes.Body.Close()
+		}
+		lastRes = res
+	}
+	if lastRes != nil {
+		return lastRes, nil
+	}
+	return nil, lastErr
+}
+
+// These usually mean "this backend, right now" rather than "this request".
+func retryableStatus(code int) bool {
+	return code == 502 || code == 503 || code == 504
+}
+
+func (b *Balancer) recordSuccess(backend *Backend) {
+	backend.failures.Store(0)
+}
+
+func (b *Balancer) recordFailure(backend *Backend) {
+	maxFails := b.MaxFails
+	if maxFails == 0 {
+		maxFails = DefaultMaxFails
+	}
+	ejectFor := b.EjectFor
+	if ejectFor == 0 {
+		ejectFor = DefaultEjectFor
+	}
+	if backend.failures.Add(1) >= int64(maxFails) {
+		backend.ejectedUntil.Store(time.Now().Add(ejectFor).UnixNano())
+		backend.failures.Store(0)
+		log.Printf("balancer: ejecting %s for %v", backend.URL, ejectFor)
+	}
+}
+
+func (b *Balancer) pick(req *request.Request, tried map[*Backend]bool) *Backend {
+	usable := func(backend *Backend) bool {
+		return !tried[backend] && backend.Available()
+	}
+	switch b.Strategy {
+	case LeastConnections:
+		return b.pickLeastConnections(usable)
+	case ConsistentHash:
+		if key, ok := req.Headers.Get(b.HashHeader); ok && b.HashHeader != "" {
+			return b.pickHash(key, usable)
+		}
+	}
+	return b.pickRoundRobin(usable)
+}
+
+func (b *Balancer) pickRoundRobin(usable func(*Backend) bool) *Backend {
+	n := uint64(len(b.Backends))
+	start := b.next.Add(1) - 1
+	for i := range n {
+		backend := b.Backends[(start+i)%n]
+		if usable(backend) {
+			return backend
+		}
+	}
+	return nil
+}
+
+func (b *Balancer) pickLeastConnections(usable func(*Backend) bool) *Backend {
+	// start from a rotating offset so ties don't all go to the first one
+	n := uint64(len(b.Backends))
+	start := b.next.Add(1) - 1
+	var best *Backend
+	for i := range n {
+		backend := b.Backends[(start+i)%n]
+		if !usable(backend) {
+			continue
+		}
+		if best == nil || backend.Active() < best.Active() {
+			best = backend
+		}
+	}
+	return best
+}
+
+// Walk the ring clockwise from the key's hash to the first usable backend,
+// so losing one backend only moves the keys that were on it.
+func (b *Balancer) pickHash(key string, usable func(*Backend) bool) *Backend {
+	b.ringOnce.Do(b.buildRing)
+	if len(b.ring) == 0 {
+		return nil
+	}
+	h := hashString(key)
+	start := sort.Search(len(b.ring), func(i int) bool {
+		return b.ring[i].hash >= h
+	})
+	for i := range b.ring {
+		point := b.ring[(start+i)%len(b.ring)]
+		if usable(point.backend) {
+			return point.backend
+		}
+	}
+	return nil
+}
+
+// Ask every backend for path each interval, anything but 2xx/3xx (or no
+// answer) takes it out of rotation until a later check passes.
+func (b *Balancer) StartHealthChecks(path string, interval time.Duration) {
+	b.healthMutex.Lock()
+	defer b.healthMutex.Unlock()
+	if b.stopHealth != nil {
+		return
+	}
+	stop := make(chan struct{})
+	b.stopHealth = stop
+	timeout := b.HealthTimeout
+	if timeout == 0 {
+		timeout = DefaultHealthTimeout
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			b.checkAll(path, timeout)
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (b *Balancer) StopHealthChecks() {
+	b.healthMutex.Lock()
+	defer b.healthMutex.Unlock()
+	if b.stopHealth != nil {
+		close(b.stopHealth)
+		b.stopHealth = nil
+	}
+}
+
+func (b *Balancer) checkAll(path string, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, backend := range b.Backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			healthy := checkBackend(backend, path, timeout)
+			if backend.unhealthy.Swap(!healthy) == healthy {
+				log.Printf("balancer: %s healthy=%v", backend.URL, healthy)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// A backend that takes the connection but never answers fails once timeout
+// is up, instead of holding up the checks of everyone else.
+func checkBackend(backend *Backend, path string, timeout time.Duration) bool {
+	c := backend.Client
+	if c == nil {
+		c = client.DefaultClient
+	}
+	u := *backend.URL
+	u.Path = joinPath(backend.URL.Path, path)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	res, err := c.Do(&u, client.NewRequest("GET", &u, nil).WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	// drain it so the connection can go back to the pool
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	return res.StatusCode >= 200 && res.StatusCode < 400
+}
+
+// Counts the request as in flight until its body is done.
+type trackedBody struct {
+	io.ReadCloser
+	backend *Backend
+	once    sync.Once
+}
+
+func (t *trackedBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if err != nil {
+		t.release()
+	}
+	return n, err
+}
+
+func (t *trackedBody) Close() error {
+	t.release()
+	return t.ReadCloser.Close()
+}
+
+func (t *trackedBody) release() {
+	t.once.Do(func() { t.backend.active.Add(-1) })
+}
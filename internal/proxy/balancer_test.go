@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/client"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// stand-in upstream that answers with its name, or with status when it's set
+type standIn struct {
+	name   string
+	status atomic.Int64
+	hits   atomic.Int64
+	hold   chan struct{} // when set, requests wait on it before answering
+}
+
+func startStandIn(t *testing.T, name string) (*standIn, string) {
+	s := &standIn{name: name}
+	u := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget != "/health" {
+			s.hits.Add(1)
+		}
+		if s.hold != nil {
+			<-s.hold
+		}
+		code := response.StatusCode(s.status.Load())
+		if code == 0 {
+			code = response.StatusOK
+		}
+		msg := []byte(s.name)
+		w.WriteStatusLine(code)
+		w.WriteHeaders(response.GetDefaultHeaders(len(msg)))
+		w.WriteBody(msg)
+	})
+	return s, u.String()
+}
+
+// an address nobody listens on
+func deadURL(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+	return "http://" + addr
+}
+
+func roundTrip(t *testing.T, b *Balancer, method string, hdrs map[string]string) (string, response.StatusCode, error) {
+	t.Helper()
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: "/", HttpVersion: "1.1"},
+		Headers:     map[string]string{},
+	}
+	for key, val := range hdrs {
+		req.Headers.Set(key, val)
+	}
+	res, err := b.RoundTrip(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return string(body), res.StatusCode, nil
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	_, a := startStandIn(t, "a")
+	_, b := startStandIn(t, "b")
+	_, c := startStandIn(t, "c")
+	lb, err := NewBalancer(RoundRobin, &client.Client{}, a, b, c)
+	require.NoError(t, err)
+
+	seen := []string{}
+	for range 6 {
+		name, _, err := roundTrip(t, lb, "GET", nil)
+		require.NoError(t, err)
+		seen = append(seen, name)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, seen)
+}
+
+func TestBalancerLeastConnections(t *testing.T) {
+	slow, a := startStandIn(t, "slow")
+	slow.hold = make(chan struct{})
+	_, b := startStandIn(t, "fast")
+	lb, err := NewBalancer(LeastConnections, &client.Client{}, a, b)
+	require.NoError(t, err)
+
+	// park one request on the slow backend
+	done := make(chan string)
+	go func() {
+		name, _, _ := roundTrip(t, lb, "GET", nil)
+		done <- name
+	}()
+	require.Eventually(t, func() bool { return slow.hits.Load() == 1 }, time.Second, time.Millisecond)
+
+	for range 3 {
+		name, _, err := roundTrip(t, lb, "GET", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "fast", name)
+	}
+	close(slow.hold)
+	assert.Equal(t, "slow", <-done)
+	assert.Equal(t, int64(0), lb.Backends[0].Active())
+}
+
+func TestBalancerConsistentHash(t *testing.T) {
+	urls := []string{}
+	for i := range 4 {
+		_, u := startStandIn(t, fmt.Sprint("backend-", i))
+		urls = append(urls, u)
+	}
+	lb, err := NewBalancer(ConsistentHash, &client.Client{}, urls...)
+	require.NoError(t, err)
+	lb.HashHeader = "X-User"
+
+	owners := map[string]string{}
+	for i := range 20 {
+		user := fmt.Sprint("user-", i)
+		name, _, err := roundTrip(t, lb, "GET", map[string]string{"X-User": user})
+		require.NoError(t, err)
+		owners[user] = name
+		// same key, same backend
+		again, _, err := roundTrip(t, lb, "GET", map[string]string{"X-User": user})
+		require.NoError(t, err)
+		assert.Equal(t, name, again)
+	}
+
+	// take one backend out: only its keys move
+	lb.Backends[0].unhealthy.Store(true)
+	for user, owner := range owners {
+		name, _, err := roundTrip(t, lb, "GET", map[string]string{"X-User": user})
+		require.NoError(t, err)
+		if owner != "backend-0" {
+			assert.Equal(t, owner, name)
+		} else {
+			assert.NotEqual(t, "backend-0", name)
+		}
+	}
+}
+
+func TestBalancerLiteralConsistentHash(t *testing.T) {
+	_, a := startStandIn(t, "a")
+	upstream, err := NewUpstream(a)
+	require.NoError(t, err)
+	// no NewBalancer, the ring still gets built
+	lb := &Balancer{
+		Backends:   []*Backend{{Upstream: upstream}},
+		Strategy:   ConsistentHash,
+		HashHeader: "X-User",
+	}
+	name, _, err := roundTrip(t, lb, "GET", map[string]string{"X-User": "ann"})
+	require.NoError(t, err)
+	assert.Equal(t, "a", name)
+}
+
+func TestBalancerRetryAndEjection(t *testing.T) {
+	_, alive := startStandIn(t, "alive")
+	lb, err := NewBalancer(RoundRobin, &client.Client{}, deadURL(t), alive)
+	require.NoError(t, err)
+	lb.MaxFails = 2
+	lb.EjectFor = time.Hour
+
+	// Test: GET to the dead one is retried on the other
+	for range 4 {
+		name, _, err := roundTrip(t, lb, "GET", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "alive", name)
+	}
+	// two failures in a row got the dead one ejected
+	assert.False(t, lb.Backends[0].Available())
+
+	// Test: POST is not retried
+	lb.Backends[0].ejectedUntil.Store(0)
+	lb.next.Store(0)
+	_, _, err = roundTrip(t, lb, "POST", nil)
+	require.Error(t, err)
+
+	// Test: 503 is retried elsewhere, and handed back when it's all we have
+	sick, sickURL := startStandIn(t, "sick")
+	sick.status.Store(503)
+	lb, err = NewBalancer(RoundRobin, &client.Client{}, sickURL, alive)
+	require.NoError(t, err)
+	name, code, err := roundTrip(t, lb, "GET", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "alive", name)
+	assert.Equal(t, response.StatusOK, code)
+
+	lb, err = NewBalancer(RoundRobin, &client.Client{}, sickURL)
+	require.NoError(t, err)
+	_, code, err = roundTrip(t, lb, "GET", nil)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(503), code)
+
+	// Test: nothing available
+	lb, err = NewBalancer(RoundRobin, &client.Client{}, alive)
+	require.NoError(t, err)
+	lb.Backends[0].unhealthy.Store(true)
+	_, _, err = roundTrip(t, lb, "GET", nil)
+	assert.ErrorIs(t, err, ErrNoBackends)
+}
+
+func TestBalancerHealthChecks(t *testing.T) {
+	flaky, a := startStandIn(t, "flaky")
+	_, b := startStandIn(t, "steady")
+	lb, err := NewBalancer(RoundRobin, &client.Client{}, a, b)
+	require.NoError(t, err)
+
+	flaky.status.Store(500)
+	lb.StartHealthChecks("/health", 5*time.Millisecond)
+	defer lb.StopHealthChecks()
+	require.Eventually(t, func() bool { return !lb.Backends[0].Available() }, time.Second, time.Millisecond)
+	for range 3 {
+		name, _, err := roundTrip(t, lb, "GET", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "steady", name)
+	}
+
+	// recovers once the check passes again
+	flaky.status.Store(200)
+	require.Eventually(t, func() bool { return lb.Backends[0].Available() }, time.Second, time.Millisecond)
+}
+
+func TestBalancerHealthCheckTimeout(t *testing.T) {
+	// takes the connection, never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	flaky, b := startStandIn(t, "flaky")
+	lb, err := NewBalancer(RoundRobin, &client.Client{}, "http://"+listener.Addr().String(), b)
+	require.NoError(t, err)
+	lb.HealthTimeout = 20 * time.Millisecond
+
+	flaky.status.Store(500)
+	lb.StartHealthChecks("/health", 5*time.Millisecond)
+	defer lb.StopHealthChecks()
+	require.Eventually(t, func() bool {
+		return !lb.Backends[0].Available() && !lb.Backends[1].Available()
+	}, time.Second, time.Millisecond)
+
+	// the hung backend doesn't stop the checks, the other one recovers
+	flaky.status.Store(200)
+	require.Eventually(t, func() bool { return lb.Backends[1].Available() }, time.Second, time.Millisecond)
+	assert.False(t, lb.Backends[0].Available())
+}
+
+func TestProxyWithBalancer(t *testing.T) {
+	_, a := startStandIn(t, "a")
+	_, b := startStandIn(t, "b")
+	lb, err := NewBalancer(RoundRobin, &client.Client{}, a, b)
+	require.NoError(t, err)
+	proxyURL := startServer(t, (&Proxy{Transport: lb}).Handle)
+
+	_, first := send(t, proxyURL, client.NewRequest("GET", proxyURL, nil))
+	_, second := send(t, proxyURL, client.NewRequest("GET", proxyURL, nil))
+	assert.ElementsMatch(t, []string{"a", "b"}, []string{first, second})
+}
@@ -0,0 +1,302 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/WaronLimsakul/learn_http/internal/client"
+	"github.com/WaronLimsakul/learn_http/internal/digest"
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// Sends the outgoing request somewhere and gives back the upstream response.
+// The request target is already rewritten and relative to the upstream.
+type Transport interface {
+	RoundTrip(req *request.Request) (*client.Response, error)
+}
+
+// A Transport for one fixed upstream, e.g. https://httpbin.org
+type Upstream struct {
+	URL    *url.URL
+	Client *client.Client // nil means client.DefaultClient
+}
+
+func NewUpstream(rawURL string) (*Upstream, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported upstream scheme: %s", u.Scheme)
+	}
+	return &Upstream{URL: u}, nil
+}
+
+func (u *Upstream) RoundTrip(req *request.Request) (*client.Response, error) {
+	c := u.Client
+	if c == nil {
+		c = client.DefaultClient
+	}
+	// the upstream can live under a path of its own
+	req.RequestLine.RequestTarget = joinPath(u.URL.Path, req.RequestLine.RequestTarget)
+	req.Headers.Reset("Host", u.URL.Host)
+	return c.Do(u.URL, req)
+}
+
+func joinPath(base, target string) string {
+	base = strings.TrimSuffix(base, "/")
+	if !strings.HasPrefix(target, "/") {
+		target = "/" + target
+	}
+	return base + target
+}
+
+// Replace Prefix at the start of the request path with Replacement.
+type Rewrite struct {
+	Prefix      string
+	Replacement string
+}
+
+// A reverse proxy handler. Any method goes through, the upstream's status,
+// headers, body and trailers come back, and the response body is streamed
+// as chunks as soon as they arrive. The request body is not streamed: the
+// server reads it whole before the handler runs, so uploads are buffered in
+// memory and sent upstream in one go.
+type Proxy struct {
+	Transport Transport
+	// first matching prefix wins, no match means the path is sent as-is
+	Rewrites []Rewrite
+	// "https" when we sit behind TLS, used for X-Forwarded-Proto
+	Scheme string
+	// adds Content-Digest/Repr-Digest trailers (RFC 9530), plus the older
+	// X-Content-SHA256 and X-Content-Length
+	ChecksumTrailers bool
+}
+
+func NewSingleHost(rawURL string, rewrites ...Rewrite) (*Proxy, error) {
+	upstream, err := NewUpstream(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Proxy{Transport: upstream, Rewrites: rewrites}, nil
+}
+
+// Headers that only describe one connection, never forwarded (RFC 9110 7.6.1).
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopByHop(h headers.Headers) {
+	// Connection can name extra headers that are hop-by-hop too
+	if conn, ok := h.Get("Connection"); ok {
+		for _, name := range strings.Split(conn, ",") {
+			h.Delete(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Delete(name)
+	}
+}
+
+// Use as a server.Handler: server.Serve(port, p.Handle)
+func (p *Proxy) Handle(w *response.Writer, req *request.Request) {
+	outReq := p.outgoingRequest(req)
+	res, err := p.Transport.RoundTrip(outReq)
+	if err != nil {
+		log.Printf("proxy: error reaching upstream: %v", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			writeGatewayTimeout(w)
+			return
+		}
+		writeBadGateway(w)
+		return
+	}
+	defer res.Body.Close()
+	p.writeResponse(w, req, res)
+}
+
+func (p *Proxy) rewritePath(target string) string {
+	for _, rule := range p.Rewrites {
+		if strings.HasPrefix(target, rule.Prefix) {
+			return rule.Replacement + strings.TrimPrefix(target, rule.Prefix)
+		}
+	}
+	return target
+}
+
+func (p *Proxy) outgoingRequest(req *request.Request) *request.Request {
+	h := req.Headers.Clone()
+	removeHopByHop(h)
+
+	scheme := p.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	host, _ := req.Headers.Get("Host")
+	clientIP := req.RemoteAddr
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		clientIP = ip
+	}
+	if clientIP != "" {
+		// Set appends, so earlier proxies in the chain stay in the list
+		h.Set("X-Forwarded-For", clientIP)
+	}
+	h.Reset("X-Forwarded-Proto", scheme)
+	if host != "" {
+		h.Reset("X-Forwarded-Host", host)
+	}
+	h.Set("Forwarded", forwardedElement(clientIP, host, scheme))
+
+	outReq := &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        req.RequestLine.Method,
+			RequestTarget: p.rewritePath(req.RequestLine.RequestTarget),
+			HttpVersion:   "1.1",
+		},
+		Headers: h,
+		// already all in memory, see Proxy
+		Body:       req.Body,
+		RemoteAddr: req.RemoteAddr,
+	}
+	// the upstream call stops when our client leaves or the deadline hits
+	return outReq.WithContext(req.Context())
+}
+
+// One element of the Forwarded header (RFC 7239), e.g.
+// for=192.0.2.1;host=example.com;proto=http
+func forwardedElement(clientIP, host, scheme string) string {
+	parts := []string{}
+	if clientIP != "" {
+		if strings.Contains(clientIP, ":") {
+			// IPv6 has to be quoted and bracketed
+			parts = append(parts, `for="[`+clientIP+`]"`)
+		} else {
+			parts = append(parts, "for="+clientIP)
+		}
+	}
+	if host != "" {
+		parts = append(parts, "host="+quoteIfNeeded(host))
+	}
+	parts = append(parts, "proto="+scheme)
+	return strings.Join(parts, ";")
+}
+
+func quoteIfNeeded(s string) string {
+	if strings.ContainsAny(s, ":[]\" ") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func (p *Proxy) writeResponse(w *response.Writer, req *request.Request, res *client.Response) {
+	h := res.Headers.Clone()
+	announced, _ := res.Headers.Get("Trailer")
+	removeHopByHop(h)
+	for _, c := range res.Cookies {
+		// each on its own line again, Expires has a comma in it
+		if err := w.SetRawCookie(c); err != nil {
+			log.Printf("proxy: dropping upstream cookie: %v", err)
+		}
+	}
+
+	if !hasBody(req.RequestLine.Method, res.StatusCode) {
+		w.WriteStatusLine(res.StatusCode)
+		w.WriteHeaders(h)
+		return
+	}
+
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	if announced != "" {
+		h.Set("Trailer", announced)
+	}
+	writeChunk := w.WriteChunkedBody
+	var dw *response.DigestWriter
+	if p.ChecksumTrailers {
+		dw = response.NewDigestWriter(w, digest.SHA256, digest.SHA512)
+		// a 206 body is only part of the representation
+		dw.Repr = res.StatusCode != 206
+		dw.Legacy = true
+		dw.AnnounceTrailers(h)
+		writeChunk = dw.WriteChunkedBody
+	}
+	w.WriteStatusLine(res.StatusCode)
+	w.WriteHeaders(h)
+
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := res.Body.Read(buffer)
+		if n > 0 {
+			// when .Read(), it fill from start to n-1 bytes
+			if _, err := writeChunk(buffer[:n]); err != nil {
+				log.Printf("proxy: error writing chunked body: %v", err)
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// too late to change the status, cut the stream short instead
+			log.Printf("proxy: error reading upstream body: %v", err)
+			return
+		}
+	}
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		log.Printf("proxy: error writing last chunk: %v", err)
+		return
+	}
+
+	trailers := headers.NewHeaders()
+	for key, val := range res.Trailers {
+		trailers.Set(key, val)
+		trailers.Set("Trailer", key)
+	}
+	writeTrailers := w.WriteTrailers
+	if dw != nil {
+		// digest trailers from upstream get replaced by ours, same bytes anyway
+		writeTrailers = dw.WriteTrailers
+	}
+	if err := writeTrailers(trailers); err != nil {
+		log.Printf("proxy: error writing trailers: %v", err)
+	}
+}
+
+// HEAD, 1xx, 204 and 304 responses never have a body (RFC 9110 6.4.1).
+func hasBody(method string, code response.StatusCode) bool {
+	if method == "HEAD" || code == 204 || code == 304 {
+		return false
+	}
+	return code >= 200
+}
+
+func writeBadGateway(w *response.Writer) {
+	msg := []byte("couldn't reach upstream")
+	w.WriteStatusLine(response.StatusBadGateway)
+	w.WriteHeaders(response.GetDefaultHeaders(len(msg)))
+	w.WriteBody(msg)
+}
+
+func writeGatewayTimeout(w *response.Writer) {
+	msg := []byte("upstream took too long")
+	w.WriteStatusLine(response.StatusGatewayTimeout)
+	w.WriteHeaders(response.GetDefaultHeaders(len(msg)))
+	w.WriteBody(msg)
+}
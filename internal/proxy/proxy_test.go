@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/client"
+	"github.com/WaronLimsakul/learn_http/internal/cookie"
+	"github.com/WaronLimsakul/learn_http/internal/digest"
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+	"github.com/WaronLimsakul/learn_http/internal/server"
+)
+
+// what the upstream saw, sent back as JSON
+type echo struct {
+	Method  string
+	Target  string
+	Headers headers.Headers
+	Body    string
+}
+
+func startServer(t *testing.T, handler server.Handler) *url.URL {
+	srv, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	// Addr is [::]:port, talk to it over IPv4 so the client IP is predictable
+	_, port, err := net.SplitHostPort(srv.Addr().String())
+	require.NoError(t, err)
+	u, err := url.Parse("http://127.0.0.1:" + port)
+	require.NoError(t, err)
+	return u
+}
+
+func echoUpstream(w *response.Writer, req *request.Request) {
+	switch req.RequestLine.RequestTarget {
+	case "/api/missing":
+		msg := []byte("nope")
+		w.WriteStatusLine(404)
+		h := response.GetDefaultHeaders(len(msg))
+		h.Set("X-Upstream", "yes")
+		h.Set("Keep-Alive", "timeout=5")
+		w.WriteHeaders(h)
+		w.WriteBody(msg)
+		return
+	case "/api/cookies":
+		w.SetCookie(&cookie.Cookie{Name: "a", Value: "1", Expires: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)})
+		w.SetCookie(&cookie.Cookie{Name: "b", Value: "2", HttpOnly: true})
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		w.WriteBody(nil)
+		return
+	case "/api/trailers":
+		w.WriteStatusLine(response.StatusOK)
+		h := response.GetDefaultHeaders(0)
+		h.Delete("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Done")
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("part one, "))
+		w.WriteChunkedBody([]byte("part two"))
+		w.WriteChunkedBodyDone()
+		h.Set("X-Done", "yes")
+		w.WriteTrailers(h)
+		return
+	}
+	body, _ := json.Marshal(echo{
+		Method:  req.RequestLine.Method,
+		Target:  req.RequestLine.RequestTarget,
+		Headers: req.Headers,
+		Body:    string(req.Body),
+	})
+	w.WriteStatusLine(response.StatusOK)
+	h := response.GetDefaultHeaders(len(body))
+	h.Reset("Content-Type", "application/json")
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+func send(t *testing.T, proxyURL *url.URL, req *request.Request) (*client.Response, string) {
+	t.Helper()
+	c := &client.Client{MaxIdlePerHost: -1}
+	res, err := c.Do(proxyURL, req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func TestProxy(t *testing.T) {
+	upstreamURL := startServer(t, echoUpstream)
+	p, err := NewSingleHost(upstreamURL.String()+"/api", Rewrite{Prefix: "/httpbin", Replacement: ""})
+	require.NoError(t, err)
+	p.ChecksumTrailers = true
+	proxyURL := startServer(t, p.Handle)
+
+	// Test: POST with body, hop-by-hop stripped, forwarding headers added
+	target, _ := url.Parse(proxyURL.String() + "/httpbin/post?x=1")
+	req := client.NewRequest("POST", target, []byte(`{"hi":1}`))
+	req.Headers.Set("Connection", "X-Secret")
+	req.Headers.Set("X-Secret", "hop")
+	req.Headers.Set("Proxy-Authorization", "Basic abc")
+	req.Headers.Set("X-Forwarded-For", "203.0.113.9")
+	req.Headers.Set("X-Custom", "kept")
+	res, body := send(t, proxyURL, req)
+	require.Equal(t, response.StatusOK, res.StatusCode)
+
+	var seen echo
+	require.NoError(t, json.Unmarshal([]byte(body), &seen))
+	assert.Equal(t, "POST", seen.Method)
+	assert.Equal(t, "/api/post?x=1", seen.Target)
+	assert.Equal(t, `{"hi":1}`, seen.Body)
+	assert.Equal(t, "kept", seen.Headers["x-custom"])
+	assert.Equal(t, upstreamURL.Host, seen.Headers["host"])
+	assert.NotContains(t, seen.Headers, "x-secret")
+	assert.NotContains(t, seen.Headers, "proxy-authorization")
+	assert.Equal(t, "203.0.113.9, 127.0.0.1", seen.Headers["x-forwarded-for"])
+	assert.Equal(t, "http", seen.Headers["x-forwarded-proto"])
+	assert.Equal(t, proxyURL.Host, seen.Headers["x-forwarded-host"])
+	assert.Equal(t, fmt.Sprintf(`for=127.0.0.1;host="%s";proto=http`, proxyURL.Host), seen.Headers["forwarded"])
+	assert.Equal(t, "application/json", res.Headers["content-type"])
+	assert.Len(t, res.Trailers["x-content-sha256"], 64)
+	assert.Equal(t, fmt.Sprint(len(body)), res.Trailers["x-content-length"])
+	sums, err := digest.Parse(res.Trailers["content-digest"])
+	require.NoError(t, err)
+	bodySum := sha256.Sum256([]byte(body))
+	assert.Equal(t, bodySum[:], sums[digest.SHA256])
+	assert.Len(t, sums[digest.SHA512], 64)
+	assert.Equal(t, res.Trailers["content-digest"], res.Trailers["repr-digest"])
+
+	// Test: upstream status and headers come through, hop-by-hop don't
+	target, _ = url.Parse(proxyURL.String() + "/httpbin/missing")
+	res, body = send(t, proxyURL, client.NewRequest("GET", target, nil))
+	assert.Equal(t, response.StatusCode(404), res.StatusCode)
+	assert.Equal(t, "Not Found", res.Reason)
+	assert.Equal(t, "yes", res.Headers["x-upstream"])
+	assert.NotContains(t, res.Headers, "keep-alive")
+	assert.Equal(t, "nope", body)
+
+	// Test: upstream cookies keep a line each, the comma in Expires too
+	target, _ = url.Parse(proxyURL.String() + "/httpbin/cookies")
+	res, _ = send(t, proxyURL, client.NewRequest("GET", target, nil))
+	assert.Equal(t, []string{"a=1; Expires=Wed, 02 Jan 2030 03:04:05 GMT", "b=2; HttpOnly"}, res.Cookies)
+
+	// Test: upstream trailers are passed along
+	target, _ = url.Parse(proxyURL.String() + "/httpbin/trailers")
+	res, body = send(t, proxyURL, client.NewRequest("GET", target, nil))
+	assert.Equal(t, "part one, part two", body)
+	assert.Equal(t, "yes", res.Trailers["x-done"])
+	assert.True(t, strings.Contains(res.Headers["trailer"], "X-Done"))
+
+	// Test: HEAD gets headers but no body
+	target, _ = url.Parse(proxyURL.String() + "/httpbin/head")
+	res, body = send(t, proxyURL, client.NewRequest("HEAD", target, nil))
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.Empty(t, body)
+
+	// Test: the client's connection to us stays open between requests
+	keepAlive := &client.Client{}
+	target, _ = url.Parse(proxyURL.String() + "/httpbin/get")
+	for range 2 {
+		res, err := keepAlive.Do(proxyURL, client.NewRequest("GET", target, nil))
+		require.NoError(t, err)
+		assert.NotContains(t, res.Headers, "connection")
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+	assert.Equal(t, int64(1), keepAlive.Stats().Dials)
+	assert.Equal(t, int64(1), keepAlive.Stats().Reuses)
+}
+
+func TestProxyBadGateway(t *testing.T) {
+	// nothing listens on port 1
+	p, err := NewSingleHost("http://127.0.0.1:1")
+	require.NoError(t, err)
+	proxyURL := startServer(t, p.Handle)
+
+	res, _ := send(t, proxyURL, client.NewRequest("GET", proxyURL, nil))
+	assert.Equal(t, response.StatusBadGateway, res.StatusCode)
+}
+
+func TestProxyGatewayTimeout(t *testing.T) {
+	upstreamURL := startServer(t, func(w *response.Writer, req *request.Request) {
+		// hold on until the proxy gives up
+		<-req.Context().Done()
+	})
+	p, err := NewSingleHost(upstreamURL.String())
+	require.NoError(t, err)
+	proxyURL := startServer(t, server.Timeout(100*time.Millisecond, p.Handle))
+
+	res, _ := send(t, proxyURL, client.NewRequest("GET", proxyURL, nil))
+	assert.Equal(t, response.StatusGatewayTimeout, res.StatusCode)
+}
+
+func TestForwardedElement(t *testing.T) {
+	assert.Equal(t, "for=192.0.2.1;host=example.com;proto=https", forwardedElement("192.0.2.1", "example.com", "https"))
+	assert.Equal(t, `for="[2001:db8::1]";proto=http`, forwardedElement("2001:db8::1", "", "http"))
+}
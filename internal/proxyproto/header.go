@@ -0,0 +1,303 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// What a load balancer tells us about the connection it passes on.
+type Header struct {
+	Version int
+	// false for the balancer's own connections, e.g. health checks (v2
+	// LOCAL, v1 UNKNOWN). The addresses are unset then.
+	Proxied     bool
+	Source      net.Addr
+	Destination net.Addr
+	// v2 only, in the order they came
+	TLVs []TLV
+}
+
+// A type-length-value extension of a v2 header.
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// TLV types from the spec (2.2), balancers may add their own from 0xE0 up.
+const (
+	TypeALPN      byte = 0x01
+	TypeAuthority byte = 0x02
+	TypeCRC32C    byte = 0x03
+	TypeNoop      byte = 0x04
+	TypeUniqueID  byte = 0x05
+	TypeSSL       byte = 0x20
+	TypeNetNS     byte = 0x30
+)
+
+// First TLV of type t.
+func (h *Header) TLV(t byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// A v1 line is never longer than this, CRLF included.
+const v1MaxLen = 107
+
+var ErrNoHeader = errors.New("proxyproto: no PROXY header")
+
+// Look at the first bytes for a header without consuming anything.
+// Stops at the first byte that can't be one, so a client that sends
+// something short and waits isn't stuck here.
+func hasHeader(r *bufio.Reader) (bool, error) {
+	for n := 1; ; n++ {
+		peek, err := r.Peek(n)
+		if err != nil {
+			return false, err
+		}
+		v1 := bytes.HasPrefix(v1Prefix, peek)
+		v2 := bytes.HasPrefix(v2Signature, peek)
+		if !v1 && !v2 {
+			return false, nil
+		}
+		if (v1 && n == len(v1Prefix)) || (v2 && n == len(v2Signature)) {
+			return true, nil
+		}
+	}
+}
+
+// Parse the header off the front of r, either version.
+func readHeader(r *bufio.Reader) (*Header, error) {
+	ok, err := hasHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNoHeader
+	}
+	peek, _ := r.Peek(1)
+	if peek[0] == 'P' {
+		return readV1(r)
+	}
+	return readV2(r)
+}
+
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) == v1MaxLen {
+			return nil, fmt.Errorf("proxyproto: v1 header too long")
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, fmt.Errorf("proxyproto: v1 header without CRLF")
+	}
+	fields := strings.Split(text, " ")
+	h := &Header{Version: 1}
+	// whatever follows UNKNOWN is to be ignored
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("proxyproto: malformed v1 header %q", text)
+	}
+	var want int
+	switch fields[1] {
+	case "TCP4":
+		want = net.IPv4len
+	case "TCP6":
+		want = net.IPv6len
+	default:
+		return nil, fmt.Errorf("proxyproto: unknown v1 protocol %q", fields[1])
+	}
+	src, err := v1Addr(fields[2], fields[4], want)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := v1Addr(fields[3], fields[5], want)
+	if err != nil {
+		return nil, err
+	}
+	h.Proxied, h.Source, h.Destination = true, src, dst
+	return h, nil
+}
+
+func v1Addr(ip, port string, ipLen int) (*net.TCPAddr, error) {
+	parsed := net.ParseIP(ip)
+	// TCP4 takes dotted quads only, TCP6 anything but
+	isV4 := parsed.To4() != nil && !strings.Contains(ip, ":")
+	if parsed == nil || isV4 != (ipLen == net.IPv4len) {
+		return nil, fmt.Errorf("proxyproto: bad v1 address %q", ip)
+	}
+	// no sign, no leading zeros
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("proxyproto: bad v1 port %q", port)
+	}
+	return &net.TCPAddr{IP: parsed, Port: int(p)}, nil
+}
+
+// v2 address families, transport in the low nibble
+const (
+	familyUnspec = 0x0
+	familyInet   = 0x1
+	familyInet6  = 0x2
+	familyUnix   = 0x3
+)
+
+// Signature, version/command, family/transport, length.
+const v2HeaderLen = 16
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	head := make([]byte, v2HeaderLen)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if version := head[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("proxyproto: v2 signature with version %d", version)
+	}
+	h := &Header{Version: 2}
+	switch command := head[12] & 0xf; command {
+	case 0x0: // LOCAL
+	case 0x1: // PROXY
+		h.Proxied = true
+	default:
+		return nil, fmt.Errorf("proxyproto: unknown v2 command %d", command)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	family, transport := head[13]>>4, head[13]&0xf
+	var addrLen int
+	switch family {
+	case familyUnspec:
+	case familyInet:
+		addrLen = 2*net.IPv4len + 4
+	case familyInet6:
+		addrLen = 2*net.IPv6len + 4
+	case familyUnix:
+		addrLen = 2 * 108
+	default:
+		return nil, fmt.Errorf("proxyproto: unknown v2 address family %d", family)
+	}
+	if transport > 2 {
+		return nil, fmt.Errorf("proxyproto: unknown v2 transport %d", transport)
+	}
+	if len(body) < addrLen {
+		return nil, fmt.Errorf("proxyproto: v2 addresses cut short")
+	}
+	// LOCAL may carry addresses too, they mean nothing
+	if h.Proxied {
+		if family == familyUnspec {
+			h.Proxied = false
+		} else {
+			h.Source, h.Destination = v2Addrs(family, transport, body[:addrLen])
+		}
+	}
+
+	tlvs, err := parseTLVs(body[addrLen:])
+	if err != nil {
+		return nil, err
+	}
+	h.TLVs = tlvs
+	if sum, ok := h.TLV(TypeCRC32C); ok {
+		if err := checkCRC(head, body, addrLen, sum); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func v2Addrs(family, transport byte, b []byte) (net.Addr, net.Addr) {
+	if family == familyUnix {
+		network := "unix"
+		if transport == 2 {
+			network = "unixgram"
+		}
+		name := func(p []byte) string {
+			if i := bytes.IndexByte(p, 0); i >= 0 {
+				p = p[:i]
+			}
+			return string(p)
+		}
+		return &net.UnixAddr{Name: name(b[:108]), Net: network},
+			&net.UnixAddr{Name: name(b[108:]), Net: network}
+	}
+	ipLen := (len(b) - 4) / 2
+	src := net.IP(bytes.Clone(b[:ipLen]))
+	dst := net.IP(bytes.Clone(b[ipLen : 2*ipLen]))
+	srcPort := int(binary.BigEndian.Uint16(b[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(b[2*ipLen+2:]))
+	if transport == 2 {
+		return &net.UDPAddr{IP: src, Port: srcPort}, &net.UDPAddr{IP: dst, Port: dstPort}
+	}
+	return &net.TCPAddr{IP: src, Port: srcPort}, &net.TCPAddr{IP: dst, Port: dstPort}
+}
+
+func parseTLVs(b []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, fmt.Errorf("proxyproto: truncated TLV")
+		}
+		n := int(binary.BigEndian.Uint16(b[1:]))
+		if len(b) < 3+n {
+			return nil, fmt.Errorf("proxyproto: TLV longer than the header")
+		}
+		tlvs = append(tlvs, TLV{Type: b[0], Value: b[3 : 3+n]})
+		b = b[3+n:]
+	}
+	return tlvs, nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// The checksum covers the whole header with its own value zeroed (2.2.3).
+func checkCRC(head, body []byte, addrLen int, sum []byte) error {
+	if len(sum) != 4 {
+		return fmt.Errorf("proxyproto: CRC32C TLV of %d bytes", len(sum))
+	}
+	want := binary.BigEndian.Uint32(sum)
+	zeroed := bytes.Clone(body)
+	for b := zeroed[addrLen:]; len(b) >= 3; {
+		n := int(binary.BigEndian.Uint16(b[1:]))
+		if b[0] == TypeCRC32C {
+			clear(b[3 : 3+n])
+			break
+		}
+		b = b[3+n:]
+	}
+	crc := crc32.Update(crc32.Checksum(head, castagnoli), castagnoli, zeroed)
+	if crc != want {
+		return fmt.Errorf("proxyproto: CRC32C mismatch")
+	}
+	return nil
+}
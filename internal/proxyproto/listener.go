@@ -0,0 +1,137 @@
+// PROXY protocol v1 and v2 (haproxy.org/download/3.0/doc/proxy-protocol.txt):
+// a load balancer opens each connection with a header that says who the
+// client really is.
+package proxyproto
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// How long a trusted source gets to send its header if Listener doesn't say.
+const DefaultHeaderTimeout = 10 * time.Second
+
+// Wraps a listener so that RemoteAddr on its connections is the client
+// the balancer speaks for, not the balancer. Serve it with
+// server.ServeListener.
+type Listener struct {
+	net.Listener
+	// sources allowed to send a header, e.g. the balancer's subnet.
+	// Anyone else who sends one is cut off, a client could claim any
+	// address otherwise.
+	Trusted []netip.Prefix
+	// trusted sources have to send a header, or they're cut off too
+	Required bool
+	// DefaultHeaderTimeout if 0
+	HeaderTimeout time.Duration
+}
+
+// Accept doesn't wait for the header, a slow client would hold up every
+// other one. It's read on the connection's first Read or RemoteAddr.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	timeout := l.HeaderTimeout
+	if timeout == 0 {
+		timeout = DefaultHeaderTimeout
+	}
+	return &Conn{
+		Conn:     conn,
+		reader:   bufio.NewReader(conn),
+		trusted:  l.trusts(conn.RemoteAddr()),
+		required: l.Required,
+		timeout:  timeout,
+	}, nil
+}
+
+func (l *Listener) trusts(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcp.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range l.Trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// A connection that may have come through a balancer.
+type Conn struct {
+	net.Conn
+	reader   *bufio.Reader
+	trusted  bool
+	required bool
+	timeout  time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+// Read past the header, if there is one. Nothing is read if the
+// connection was refused.
+func (c *Conn) Read(p []byte) (int, error) {
+	if err := c.readHeader(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(p)
+}
+
+// The client according to the header, the peer if there isn't one.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.readHeader() == nil && c.header != nil && c.header.Proxied {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// The address the client connected to, e.g. on the balancer.
+func (c *Conn) LocalAddr() net.Addr {
+	if c.readHeader() == nil && c.header != nil && c.header.Proxied {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// The header as it came, nil if the connection didn't start with one.
+func (c *Conn) ProxyHeader() (*Header, error) {
+	err := c.readHeader()
+	return c.header, err
+}
+
+func (c *Conn) readHeader() error {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		header, err := readHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		switch {
+		case err == ErrNoHeader:
+			if c.trusted && c.required {
+				c.err = fmt.Errorf("proxyproto: no header from %s", c.Conn.RemoteAddr())
+			}
+		case err != nil:
+			c.err = err
+		case !c.trusted:
+			c.err = fmt.Errorf("proxyproto: header from untrusted %s", c.Conn.RemoteAddr())
+		default:
+			c.header = header
+		}
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+	return c.err
+}
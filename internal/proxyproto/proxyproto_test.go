@@ -0,0 +1,176 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+	"github.com/WaronLimsakul/learn_http/internal/server"
+)
+
+func parse(raw string) (*Header, string, error) {
+	r := bufio.NewReader(strings.NewReader(raw))
+	h, err := readHeader(r)
+	rest, _ := io.ReadAll(r)
+	return h, string(rest), err
+}
+
+// A v2 PROXY header for TCP over IPv4, tlvs appended as they are.
+func v2Header(tlvs []byte) []byte {
+	body := []byte{192, 0, 2, 1, 198, 51, 100, 1}
+	body = binary.BigEndian.AppendUint16(body, 56324)
+	body = binary.BigEndian.AppendUint16(body, 443)
+	body = append(body, tlvs...)
+	b := append([]byte{}, v2Signature...)
+	b = append(b, 0x21, 0x11)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(body)))
+	return append(b, body...)
+}
+
+func TestReadHeaderV1(t *testing.T) {
+	h, rest, err := parse("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 1, h.Version)
+	assert.True(t, h.Proxied)
+	assert.Equal(t, "192.0.2.1:56324", h.Source.String())
+	assert.Equal(t, "198.51.100.1:443", h.Destination.String())
+	assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+
+	h, _, err = parse("PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:1", h.Source.String())
+
+	// Test: UNKNOWN is the balancer itself
+	h, _, err = parse("PROXY UNKNOWN whatever\r\n")
+	require.NoError(t, err)
+	assert.False(t, h.Proxied)
+
+	// Test: no header at all
+	_, rest, err = parse("GET / HTTP/1.1\r\n")
+	assert.ErrorIs(t, err, ErrNoHeader)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+
+	for _, bad := range []string{
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n",
+		"PROXY TCP4 2001:db8::1 198.51.100.1 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 056324 443\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 70000 443\r\n",
+		"PROXY UDP4 192.0.2.1 198.51.100.1 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 1 2\n",
+		"PROXY " + strings.Repeat("x", 200) + "\r\n",
+	} {
+		_, _, err := parse(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestReadHeaderV2(t *testing.T) {
+	tlvs := []byte{TypeAuthority, 0, 11}
+	tlvs = append(tlvs, "example.com"...)
+	tlvs = append(tlvs, 0xE0, 0, 2, 'h', 'i')
+	h, rest, err := parse(string(v2Header(tlvs)) + "GET")
+	require.NoError(t, err)
+	assert.Equal(t, 2, h.Version)
+	assert.True(t, h.Proxied)
+	assert.Equal(t, "192.0.2.1:56324", h.Source.String())
+	assert.Equal(t, "198.51.100.1:443", h.Destination.String())
+	authority, ok := h.TLV(TypeAuthority)
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", string(authority))
+	assert.Equal(t, TLV{0xE0, []byte("hi")}, h.TLVs[1])
+	assert.Equal(t, "GET", rest)
+
+	// Test: a checksum is checked
+	withCRC := v2Header([]byte{TypeCRC32C, 0, 4, 0, 0, 0, 0})
+	sum := crc32.Checksum(withCRC, crc32.MakeTable(crc32.Castagnoli))
+	binary.BigEndian.PutUint32(withCRC[len(withCRC)-4:], sum)
+	_, _, err = parse(string(withCRC))
+	assert.NoError(t, err)
+	withCRC[len(withCRC)-1]++
+	_, _, err = parse(string(withCRC))
+	assert.Error(t, err)
+
+	// Test: LOCAL connections keep their own addresses
+	local := v2Header(nil)
+	local[12] = 0x20
+	h, _, err = parse(string(local))
+	require.NoError(t, err)
+	assert.False(t, h.Proxied)
+	assert.Nil(t, h.Source)
+
+	// Test: a TLV running past the end
+	_, _, err = parse(string(v2Header([]byte{TypeNoop, 0, 9, 1})))
+	assert.Error(t, err)
+	// version 1 in a v2 signature
+	bad := v2Header(nil)
+	bad[12] = 0x11
+	_, _, err = parse(string(bad))
+	assert.Error(t, err)
+}
+
+// A server that answers with the request's RemoteAddr, behind a Listener.
+func serveBehind(t *testing.T, listener *Listener) string {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener.Listener = inner
+	srv := server.ServeListener(listener, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(req.RemoteAddr)))
+		w.WriteBody([]byte(req.RemoteAddr))
+	})
+	t.Cleanup(func() { srv.Close() })
+	return srv.Addr().String()
+}
+
+func send(t *testing.T, addr, raw string) (*response.Response, error) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	return response.ResponseFromReader(conn, "GET")
+}
+
+func TestListener(t *testing.T) {
+	local := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	addr := serveBehind(t, &Listener{Trusted: local})
+	plain := "GET / HTTP/1.1\r\nHost: x\r\n\r\n"
+
+	// Test: the request sees the client behind the balancer
+	res, err := send(t, addr, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"+plain)
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:56324", string(res.Body))
+	res, err = send(t, addr, string(v2Header(nil))+plain)
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:56324", string(res.Body))
+
+	// Test: without a header it's the peer
+	res, err = send(t, addr, plain)
+	require.NoError(t, err)
+	assert.Contains(t, string(res.Body), "127.0.0.1:")
+
+	// Test: required, the header can't be left out
+	addr = serveBehind(t, &Listener{Trusted: local, Required: true})
+	_, err = send(t, addr, plain)
+	assert.Error(t, err)
+
+	// Test: untrusted sources don't get to claim an address
+	addr = serveBehind(t, &Listener{Trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}})
+	_, err = send(t, addr, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"+plain)
+	assert.Error(t, err)
+	res, err = send(t, addr, plain)
+	require.NoError(t, err)
+	assert.Contains(t, string(res.Body), "127.0.0.1:")
+}
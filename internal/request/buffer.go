@@ -0,0 +1,70 @@
+package request
+
+import (
+	"io"
+	"sync"
+)
+
+// Most requests fit their whole head in here.
+const bufferSize = 4096
+
+// Grown buffers bigger than this go to the GC instead of back to the pool.
+const maxPooledBufferSize = 64 << 10
+
+// Same idea as bufio.Reader: unread data lives in buf[r:w]. When the
+// parser consumes bytes we just move r, and only when the end is full
+// do we slide the unread part back to the front.
+type buffer struct {
+	buf []byte
+	r   int
+	w   int
+}
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return &buffer{buf: make([]byte, bufferSize)}
+	},
+}
+
+func getBuffer() *buffer {
+	return bufferPool.Get().(*buffer)
+}
+
+func putBuffer(b *buffer) {
+	if len(b.buf) > maxPooledBufferSize {
+		return
+	}
+	b.r, b.w = 0, 0
+	bufferPool.Put(b)
+}
+
+func (b *buffer) unread() []byte {
+	return b.buf[b.r:b.w]
+}
+
+func (b *buffer) consume(n int) {
+	b.r += n
+	if b.r == b.w {
+		// nothing left, start over from the front for free
+		b.r, b.w = 0, 0
+	}
+}
+
+// Read once from reader into the free space at the end.
+func (b *buffer) fill(reader io.Reader) (int, error) {
+	if b.w == len(b.buf) {
+		if b.r > 0 {
+			copy(b.buf, b.buf[b.r:b.w])
+			b.w -= b.r
+			b.r = 0
+		} else {
+			// one line doesn't fit in the whole buffer, so grow it
+			grown := make([]byte, len(b.buf)*2)
+			copy(grown, b.buf[:b.w])
+			b.buf = grown
+		}
+	}
+	n, err := reader.Read(b.buf[b.w:])
+	b.w += n
+	return n, err
+}
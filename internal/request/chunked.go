@@ -0,0 +1,70 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Never read a chunk size with more hex digits than fit in an int.
+const maxChunkSizeDigits = 15
+
+// Decode a chunked body (RFC 9112 7.1) into r.Body and its trailer section
+// into r.Trailers. Once done, the request looks like it came with a
+// Content-Length, so it can be written out again as-is.
+func (r *Request) parseChunked(data []byte) (int, error) {
+	switch r.state {
+	case parsingChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		// ignore chunk extensions like "1A;name=val"
+		sizeText, _, _ := strings.Cut(string(data[:idx]), ";")
+		sizeText = strings.TrimSpace(sizeText)
+		if sizeText == "" || len(sizeText) > maxChunkSizeDigits {
+			return 0, fmt.Errorf("invalid chunk size: %s", sizeText)
+		}
+		size, err := strconv.ParseUint(sizeText, 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid chunk size: %s", sizeText)
+		}
+		if size == 0 {
+			r.state = parsingTrailers
+		} else {
+			r.chunkLeft = int(size)
+			r.state = parsingChunkData
+		}
+		return idx + 2, nil
+	case parsingChunkData:
+		n := min(len(data), r.chunkLeft)
+		r.Body = append(r.Body, data[:n]...)
+		r.chunkLeft -= n
+		if r.chunkLeft == 0 {
+			r.state = parsingChunkEnd
+		}
+		return n, nil
+	case parsingChunkEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, fmt.Errorf("missing crlf after chunk")
+		}
+		r.state = parsingChunkSize
+		return 2, nil
+	case parsingTrailers:
+		n, trailersDone, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if trailersDone {
+			r.Headers.Delete("Transfer-Encoding")
+			r.Headers.Reset("Content-Length", strconv.Itoa(len(r.Body)))
+			r.state = done
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("invalid request state: %v", r.state)
+}
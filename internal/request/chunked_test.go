@@ -0,0 +1,100 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedBodyParse(t *testing.T) {
+	raw := "POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Trailer: X-Sum\r\n" +
+		"\r\n" +
+		"6;ext=1\r\nhello \r\n" +
+		"A\r\nchunked wo\r\n" +
+		"3\r\nrld\r\n" +
+		"0\r\n" +
+		"X-Sum: abc\r\n" +
+		"\r\n" +
+		"GET /next HTTP/1.1\r\n"
+
+	// Test: any read size gives the same body and trailers
+	for _, perRead := range []int{1, 3, 17, len(raw)} {
+		req, err := RequestFromReader(&chunkReader{data: raw, numBytesPerRead: perRead})
+		require.NoError(t, err)
+		assert.Equal(t, "hello chunked world", string(req.Body))
+		assert.Equal(t, "abc", req.Trailers["x-sum"])
+		// framing now says what the body really is
+		assert.Equal(t, "19", req.Headers["content-length"])
+		assert.NotContains(t, req.Headers, "transfer-encoding")
+	}
+
+	// Test: no chunks, no trailers
+	req, err := RequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+		numBytesPerRead: 2,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, req.Body)
+	assert.Empty(t, req.Trailers)
+
+	// Test: bad framing
+	bad := []string{
+		"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+		"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabcde\r\n0\r\n\r\n",
+		"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nfffffffffffffffff\r\n",
+		"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n",
+		"POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n",
+		"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n0\r\n\r\n",
+	}
+	for _, data := range bad {
+		_, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: 4})
+		assert.Error(t, err, data)
+	}
+}
+
+func TestVerifyDigest(t *testing.T) {
+	parse := func(raw string) *Request {
+		t.Helper()
+		req, err := RequestFromReader(&chunkReader{data: raw, numBytesPerRead: 5})
+		require.NoError(t, err)
+		return req
+	}
+	// sha-256 and sha-512 of {"hello": "world"} from RFC 9530
+	sha256Field := "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:"
+	sha512Field := "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:"
+	body := `{"hello": "world"}`
+
+	// Test: header digest
+	req := parse("POST / HTTP/1.1\r\nContent-Digest: " + sha256Field + ", " + sha512Field +
+		"\r\nContent-Length: 18\r\n\r\n" + body)
+	assert.NoError(t, req.VerifyDigest())
+
+	// Test: trailer digest on a chunked upload
+	req = parse("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nTrailer: Content-Digest\r\n\r\n" +
+		"12\r\n" + body + "\r\n0\r\nContent-Digest: " + sha512Field + "\r\n\r\n")
+	assert.NoError(t, req.VerifyDigest())
+
+	// Test: tampered body
+	req = parse("POST / HTTP/1.1\r\nContent-Digest: " + sha256Field + "\r\nContent-Length: 18\r\n\r\n" +
+		`{"hello": "WORLD"}`)
+	assert.ErrorIs(t, req.VerifyDigest(), ErrDigestMismatch)
+
+	// Test: trailer disagrees even though the header is fine
+	req = parse("POST / HTTP/1.1\r\nContent-Digest: " + sha256Field + "\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"12\r\n" + body + "\r\n0\r\nContent-Digest: sha-256=:AAAA:\r\n\r\n")
+	assert.ErrorIs(t, req.VerifyDigest(), ErrDigestMismatch)
+
+	// Test: unknown algorithms and no digest at all are fine
+	req = parse("POST / HTTP/1.1\r\nContent-Digest: md5=:AAAA:\r\nContent-Length: 18\r\n\r\n" + body)
+	assert.NoError(t, req.VerifyDigest())
+	req = parse("POST / HTTP/1.1\r\nContent-Length: 18\r\n\r\n" + body)
+	assert.NoError(t, req.VerifyDigest())
+
+	// Test: garbage field
+	req = parse("POST / HTTP/1.1\r\nContent-Digest: sha-256=nope\r\nContent-Length: 18\r\n\r\n" + body)
+	assert.ErrorIs(t, req.VerifyDigest(), ErrDigestMismatch)
+}
@@ -0,0 +1,38 @@
+package request
+
+import "context"
+
+// The request's context, never nil. The server cancels it when the client
+// goes away, the server shuts down or a deadline runs out.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// A shallow copy of r that carries ctx instead.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// A copy of r for sending on, e.g. to an upstream. Headers and trailers can
+// be changed without touching r, the body is shared and must not be.
+func (r *Request) Clone() *Request {
+	clone := &Request{
+		RequestLine: r.RequestLine,
+		Headers:     r.Headers.Clone(),
+		Body:        r.Body,
+		RemoteAddr:  r.RemoteAddr,
+		ctx:         r.ctx,
+	}
+	if r.Trailers != nil {
+		clone.Trailers = r.Trailers.Clone()
+	}
+	return clone
+}
@@ -0,0 +1,19 @@
+package request
+
+import "github.com/WaronLimsakul/learn_http/internal/cookie"
+
+// The cookies the client sent, in order. Pairs that aren't valid are left out.
+func (r *Request) Cookies() []*cookie.Cookie {
+	header, _ := r.Headers.Get("Cookie")
+	return cookie.Parse(header)
+}
+
+// The first cookie called name.
+func (r *Request) Cookie(name string) (*cookie.Cookie, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
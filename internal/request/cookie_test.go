@@ -0,0 +1,24 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookies(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\n" +
+		"Cookie: session=abc; theme=dark\r\nCookie: lang=en\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Len(t, r.Cookies(), 3)
+	c, ok := r.Cookie("theme")
+	require.True(t, ok)
+	assert.Equal(t, "dark", c.Value)
+	c, ok = r.Cookie("lang")
+	require.True(t, ok)
+	assert.Equal(t, "en", c.Value)
+	_, ok = r.Cookie("missing")
+	assert.False(t, ok)
+}
@@ -0,0 +1,82 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnsupportedEncoding = errors.New("unsupported content-encoding")
+	ErrBodyTooLarge        = errors.New("decompressed body exceeds limit")
+)
+
+// Content-Encoding values DecompressBody knows how to undo.
+const SupportedEncodings = "gzip, deflate"
+
+// Replace a gzip/deflate encoded body with the decoded bytes. It never
+// decodes more than maxSize bytes, so a tiny zip bomb can't eat our memory.
+// After this the request looks like it was sent uncompressed.
+func (r *Request) DecompressBody(maxSize int64) error {
+	field, found := r.Headers.Get("Content-Encoding")
+	if !found {
+		return nil
+	}
+	// codings are listed in the order they were applied, so undo backwards
+	codings := strings.Split(field, ",")
+	body := r.Body
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		decoded, err := decodeBody(coding, body, maxSize)
+		if err != nil {
+			return err
+		}
+		body = decoded
+	}
+	r.Body = body
+	r.Headers.Delete("Content-Encoding")
+	r.Headers.Reset("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+func decodeBody(coding string, body []byte, maxSize int64) ([]byte, error) {
+	var reader io.Reader
+	switch coding {
+	case "identity", "":
+		return body, nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer zr.Close()
+		reader = zr
+	case "deflate":
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			// some clients send raw deflate without the zlib wrapper
+			reader = flate.NewReader(bytes.NewReader(body))
+		} else {
+			defer zr.Close()
+			reader = zr
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, coding)
+	}
+
+	// read one byte past the limit so we can tell "exactly max" from "too big"
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s body: %w", coding, err)
+	}
+	if int64(len(decoded)) > maxSize {
+		return nil, ErrBodyTooLarge
+	}
+	return decoded, nil
+}
@@ -0,0 +1,86 @@
+package request
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func encodedRequest(t *testing.T, encoding string, body []byte) *Request {
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Encoding: " + encoding + "\r\n" +
+			"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+			"\r\n" +
+			string(body),
+		numBytesPerRead: 7,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	return r
+}
+
+func TestDecompressBody(t *testing.T) {
+	payload := []byte(`{"hello": "world", "list": [1, 2, 3]}`)
+
+	// Test: gzip body
+	r := encodedRequest(t, "gzip", gzipBytes(t, payload))
+	require.NoError(t, r.DecompressBody(1024))
+	assert.Equal(t, payload, r.Body)
+	_, found := r.Headers.Get("Content-Encoding")
+	assert.False(t, found)
+	assert.Equal(t, strconv.Itoa(len(payload)), r.Headers["content-length"])
+
+	// Test: deflate (zlib) body
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(payload)
+	zw.Close()
+	r = encodedRequest(t, "deflate", buf.Bytes())
+	require.NoError(t, r.DecompressBody(1024))
+	assert.Equal(t, payload, r.Body)
+
+	// Test: no Content-Encoding leaves the body alone
+	reader := &chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.DecompressBody(1024))
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: unsupported encoding
+	r = encodedRequest(t, "br", []byte("whatever"))
+	assert.ErrorIs(t, r.DecompressBody(1024), ErrUnsupportedEncoding)
+
+	// Test: zip bomb hits the limit
+	bomb := gzipBytes(t, bytes.Repeat([]byte{0}, 1<<20))
+	r = encodedRequest(t, "gzip", bomb)
+	assert.ErrorIs(t, r.DecompressBody(4096), ErrBodyTooLarge)
+
+	// Test: exactly at the limit is fine
+	r = encodedRequest(t, "gzip", gzipBytes(t, payload))
+	require.NoError(t, r.DecompressBody(int64(len(payload))))
+
+	// Test: corrupt gzip
+	r = encodedRequest(t, "gzip", []byte("definitely not gzip"))
+	err = r.DecompressBody(1024)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnsupportedEncoding)
+}
@@ -0,0 +1,43 @@
+package request
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/WaronLimsakul/learn_http/internal/digest"
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+)
+
+var ErrDigestMismatch = errors.New("content-digest does not match body")
+
+// Algorithms we check in Content-Digest, others are ignored (RFC 9530 3).
+var verifiedAlgorithms = []digest.Algorithm{digest.SHA256, digest.SHA512}
+
+// Check Content-Digest, from the headers and/or the trailers, against the
+// body as it came over the wire (so before DecompressBody). No digest, or
+// only algorithms we don't check, is not an error.
+func (r *Request) VerifyDigest() error {
+	var d *digest.Digester
+	for _, fields := range []headers.Headers{r.Headers, r.Trailers} {
+		field, found := fields.Get("Content-Digest")
+		if !found {
+			continue
+		}
+		sums, err := digest.Parse(field)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrDigestMismatch, err)
+		}
+		if d == nil {
+			d = digest.New(verifiedAlgorithms...)
+			d.Write(r.Body)
+		}
+		for _, a := range verifiedAlgorithms {
+			want, ok := sums[a]
+			if ok && subtle.ConstantTimeCompare(want, d.Sum(a)) != 1 {
+				return fmt.Errorf("%w (%s)", ErrDigestMismatch, a)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+)
+
+var (
+	ErrNotForm      = errors.New("not a form body")
+	ErrFormTooLarge = errors.New("form exceeds limit")
+)
+
+// A decoded form post.
+type Form struct {
+	Values url.Values
+	Files  map[string][]*FormFile
+}
+
+// An uploaded file. Small ones stay in memory, the rest wait in a temp
+// file until Form.RemoveAll.
+type FormFile struct {
+	// base name only, a path the client sent is cut off
+	Filename    string
+	ContentType string
+	Header      headers.Headers
+	Size        int64
+
+	data []byte
+	path string
+}
+
+// Limits for ParseForm, zero fields get the defaults below.
+type FormLimits struct {
+	// the whole body. ParseForm only checks it once the server has read
+	// r.Body into memory, it rejects a big upload but doesn't save the
+	// memory. ReadMultipartForm stops reading at it.
+	MaxBodySize int64
+	// fields and files together
+	MaxParts int
+	// one value that isn't a file
+	MaxFieldSize int64
+	// file bytes kept in memory across all files, the file that doesn't
+	// fit anymore goes to a temp file
+	MaxMemory int64
+	// for those temp files, os.TempDir() if empty
+	TempDir string
+}
+
+const (
+	DefaultMaxFormBodySize  = 32 << 20
+	DefaultMaxFormParts     = 1000
+	DefaultMaxFormFieldSize = 1 << 20
+	DefaultMaxFormMemory    = 10 << 20
+)
+
+func (l *FormLimits) orDefaults() FormLimits {
+	var out FormLimits
+	if l != nil {
+		out = *l
+	}
+	if out.MaxBodySize == 0 {
+		out.MaxBodySize = DefaultMaxFormBodySize
+	}
+	if out.MaxParts == 0 {
+		out.MaxParts = DefaultMaxFormParts
+	}
+	if out.MaxFieldSize == 0 {
+		out.MaxFieldSize = DefaultMaxFormFieldSize
+	}
+	if out.MaxMemory == 0 {
+		out.MaxMemory = DefaultMaxFormMemory
+	}
+	return out
+}
+
+// Decode an application/x-www-form-urlencoded or multipart/form-data
+// body. limits may be nil. Call RemoveAll on the form when done with its
+// files. The body is already in memory by now, see FormLimits.MaxBodySize.
+func (r *Request) ParseForm(limits *FormLimits) (*Form, error) {
+	l := limits.orDefaults()
+	contentType, _ := r.Headers.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotForm, err)
+	}
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		return parseURLEncoded(r.Body, l)
+	case "multipart/form-data":
+		if params["boundary"] == "" {
+			return nil, fmt.Errorf("%w: no boundary", ErrMalformedMultipart)
+		}
+		return ReadMultipartForm(bytes.NewReader(r.Body), params["boundary"], &l)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotForm, mediaType)
+}
+
+func parseURLEncoded(body []byte, l FormLimits) (*Form, error) {
+	if int64(len(body)) > l.MaxBodySize {
+		return nil, fmt.Errorf("%w: body of %d bytes", ErrFormTooLarge, len(body))
+	}
+	if n := bytes.Count(body, []byte("&")) + 1; n > l.MaxParts {
+		return nil, fmt.Errorf("%w: %d fields", ErrFormTooLarge, n)
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, vals := range values {
+		for _, v := range vals {
+			if int64(len(v)) > l.MaxFieldSize {
+				return nil, fmt.Errorf("%w: field %s", ErrFormTooLarge, name)
+			}
+		}
+	}
+	return &Form{Values: values, Files: map[string][]*FormFile{}}, nil
+}
+
+// Decode multipart/form-data from r as it comes, for bodies that aren't
+// in memory.
+func ReadMultipartForm(r io.Reader, boundary string, limits *FormLimits) (form *Form, err error) {
+	l := limits.orDefaults()
+	form = &Form{Values: url.Values{}, Files: map[string][]*FormFile{}}
+	defer func() {
+		if err != nil {
+			form.RemoveAll()
+			form = nil
+		}
+	}()
+	mr := NewMultipartReader(&limitedReader{r: r, left: l.MaxBodySize}, boundary)
+	memoryLeft := l.MaxMemory
+	for parts := 0; ; parts++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			return form, err
+		}
+		if parts == l.MaxParts {
+			return form, fmt.Errorf("%w: more than %d parts", ErrFormTooLarge, l.MaxParts)
+		}
+		if part.Name == "" {
+			// not a form field, RFC 7578 4.2 says they all have a name
+			continue
+		}
+
+		// only a filename tells a file from a field
+		if part.Filename == "" {
+			value, err := io.ReadAll(io.LimitReader(part, l.MaxFieldSize+1))
+			if err != nil {
+				return form, err
+			}
+			if int64(len(value)) > l.MaxFieldSize {
+				return form, fmt.Errorf("%w: field %s", ErrFormTooLarge, part.Name)
+			}
+			form.Values.Add(part.Name, string(value))
+			continue
+		}
+
+		file, err := readFormFile(part, &memoryLeft, l.TempDir)
+		// on the form even half written, so RemoveAll finds its temp file
+		if file != nil {
+			form.Files[part.Name] = append(form.Files[part.Name], file)
+		}
+		if err != nil {
+			return form, err
+		}
+	}
+}
+
+// Into memory while there's memory left, to a temp file after that.
+func readFormFile(part *Part, memoryLeft *int64, tempDir string) (*FormFile, error) {
+	contentType, ok := part.Header.Get("Content-Type")
+	if !ok {
+		contentType = "application/octet-stream"
+	}
+	file := &FormFile{
+		Filename:    baseName(part.Filename),
+		ContentType: contentType,
+		Header:      part.Header,
+	}
+	data, err := io.ReadAll(io.LimitReader(part, *memoryLeft+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) <= *memoryLeft {
+		*memoryLeft -= int64(len(data))
+		file.data, file.Size = data, int64(len(data))
+		return file, nil
+	}
+
+	tmp, err := os.CreateTemp(tempDir, "form-")
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Close()
+	file.path = tmp.Name()
+	n, err := io.Copy(tmp, io.MultiReader(bytes.NewReader(data), part))
+	file.Size = n
+	return file, err
+}
+
+// "C:\Users\ann\cv.pdf" and "../../cv.pdf" are both "cv.pdf".
+func baseName(filename string) string {
+	name := path.Base(strings.ReplaceAll(filename, `\`, "/"))
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// Fails with ErrFormTooLarge instead of ending quietly like io.LimitReader.
+type limitedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.left <= 0 {
+		// right at the limit is fine, one byte more isn't
+		var probe [1]byte
+		if n, err := lr.r.Read(probe[:]); n == 0 && err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: body too large", ErrFormTooLarge)
+	}
+	if int64(len(p)) > lr.left {
+		p = p[:lr.left]
+	}
+	n, err := lr.r.Read(p)
+	lr.left -= int64(n)
+	return n, err
+}
+
+// The file's content, from memory or its temp file.
+func (f *FormFile) Open() (io.ReadSeekCloser, error) {
+	if f.path != "" {
+		return os.Open(f.path)
+	}
+	return nopCloser{bytes.NewReader(f.data)}, nil
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// Delete the temp files of every file on the form.
+func (f *Form) RemoveAll() error {
+	var errs []error
+	for _, files := range f.Files {
+		for _, file := range files {
+			if file.path == "" {
+				continue
+			}
+			if err := os.Remove(file.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// First value of the field, "" if there is none.
+func (f *Form) Get(name string) string {
+	return f.Values.Get(name)
+}
+
+// First file under name.
+func (f *Form) File(name string) (*FormFile, bool) {
+	files := f.Files[name]
+	if len(files) == 0 {
+		return nil, false
+	}
+	return files[0], true
+}
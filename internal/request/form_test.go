@@ -0,0 +1,132 @@
+package request
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func formRequest(t *testing.T, contentType, body string) *Request {
+	t.Helper()
+	raw := "POST /form HTTP/1.1\r\nHost: x\r\nContent-Type: " + contentType +
+		"\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	r, err := RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return r
+}
+
+func TestParseFormURLEncoded(t *testing.T) {
+	r := formRequest(t, "application/x-www-form-urlencoded", "name=Ann+Lee&tag=a&tag=b%26c&empty=")
+	form, err := r.ParseForm(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Ann Lee", form.Get("name"))
+	assert.Equal(t, []string{"a", "b&c"}, form.Values["tag"])
+	assert.Equal(t, "", form.Get("empty"))
+
+	// Test: limits
+	_, err = r.ParseForm(&FormLimits{MaxParts: 3})
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+	_, err = r.ParseForm(&FormLimits{MaxFieldSize: 4})
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+	_, err = r.ParseForm(&FormLimits{MaxBodySize: 10})
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+
+	// Test: not a form at all
+	_, err = formRequest(t, "application/json", "{}").ParseForm(nil)
+	assert.ErrorIs(t, err, ErrNotForm)
+}
+
+const multipartBody = "preamble, ignored\r\n" +
+	"--XyZ\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+	"My upload\r\n" +
+	"--XyZ\r\n" +
+	"Content-Disposition: form-data; name=\"small\"; filename=\"C:\\\\Users\\\\ann\\\\notes.txt\"\r\n" +
+	"Content-Type: text/plain\r\n\r\n" +
+	"line one\r\n--not the boundary\r\n" +
+	"--XyZ\r\n" +
+	"Content-Disposition: form-data; name=\"big\"; filename=\"../../data.bin\"\r\n\r\n" +
+	"0123456789abcdefghij\r\n" +
+	"--XyZ--\r\n" +
+	"epilogue, ignored"
+
+func TestParseFormMultipart(t *testing.T) {
+	r := formRequest(t, `multipart/form-data; boundary="XyZ"`, multipartBody)
+	// the first file fits in memory, the second doesn't anymore
+	form, err := r.ParseForm(&FormLimits{MaxMemory: 30, TempDir: t.TempDir()})
+	require.NoError(t, err)
+	defer form.RemoveAll()
+	assert.Equal(t, "My upload", form.Get("title"))
+
+	small, ok := form.File("small")
+	require.True(t, ok)
+	assert.Equal(t, "notes.txt", small.Filename)
+	assert.Equal(t, "text/plain", small.ContentType)
+	assert.Empty(t, small.path)
+	f, err := small.Open()
+	require.NoError(t, err)
+	content, _ := io.ReadAll(f)
+	f.Close()
+	assert.Equal(t, "line one\r\n--not the boundary", string(content))
+
+	big, ok := form.File("big")
+	require.True(t, ok)
+	assert.Equal(t, "data.bin", big.Filename)
+	assert.Equal(t, "application/octet-stream", big.ContentType)
+	assert.Equal(t, int64(20), big.Size)
+	require.NotEmpty(t, big.path)
+	f, err = big.Open()
+	require.NoError(t, err)
+	content, _ = io.ReadAll(f)
+	f.Close()
+	assert.Equal(t, "0123456789abcdefghij", string(content))
+
+	// Test: RemoveAll cleans the temp files up
+	require.NoError(t, form.RemoveAll())
+	_, err = os.Stat(big.path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestParseFormMultipartLimits(t *testing.T) {
+	r := formRequest(t, "multipart/form-data; boundary=XyZ", multipartBody)
+	dir := t.TempDir()
+	for _, limits := range []*FormLimits{
+		{MaxParts: 2, TempDir: dir},
+		{MaxFieldSize: 5, TempDir: dir},
+		{MaxBodySize: 100, TempDir: dir},
+	} {
+		_, err := r.ParseForm(limits)
+		assert.ErrorIs(t, err, ErrFormTooLarge)
+	}
+	// nothing left behind by the forms that failed
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Test: a body that stops in the middle of a part
+	cut := formRequest(t, "multipart/form-data; boundary=XyZ", multipartBody[:150])
+	_, err = cut.ParseForm(nil)
+	assert.ErrorIs(t, err, ErrMalformedMultipart)
+	_, err = formRequest(t, "multipart/form-data", multipartBody).ParseForm(nil)
+	assert.ErrorIs(t, err, ErrMalformedMultipart)
+}
+
+func TestMultipartReaderSmallReads(t *testing.T) {
+	// the boundary arrives a few bytes at a time
+	mr := NewMultipartReader(&chunkReader{data: multipartBody, numBytesPerRead: 3}, "XyZ")
+	var names []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, part.Name)
+	}
+	assert.Equal(t, []string{"title", "small", "big"}, names)
+}
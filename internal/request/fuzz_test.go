@@ -0,0 +1,87 @@
+package request
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// inputs from the hand-written tests, good and bad
+var fuzzSeeds = []string{
+	"GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+	"GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+	"POST /coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+	"/coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+	"/coffee GET HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+	"GET / HTTP/1.1\r\n\r\n",
+	"GET / HTTP/1.1\r\nAccept: application/json\r\n Accept: text/html\r\n\r\n",
+	"GET / HTTP/1.1\r\nHoSt: localhost:42069\r\nUser-AGenT: curl/7.81.0\r\nAcCEpt: */*\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost localhost:42069\r\n\r\n",
+	"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 13\r\n\r\nhello world!\n",
+	"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 0\r\n\r\n",
+	"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 20\r\n\r\npartial content",
+	"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\n\r\npartial content",
+	"POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nX-Sum: 1\r\n\r\n",
+}
+
+func assertSameRequest(t *testing.T, expected, actual *Request) {
+	t.Helper()
+	assert.Equal(t, expected.RequestLine, actual.RequestLine)
+	assert.Equal(t, expected.Headers, actual.Headers)
+	assertSameBody(t, expected, actual)
+}
+
+// After a round trip. A repeated empty field leaves "a, " behind, which
+// reads back as "a,", so values are compared trimmed.
+func assertSameReparsed(t *testing.T, expected, actual *Request) {
+	t.Helper()
+	assert.Equal(t, expected.RequestLine, actual.RequestLine)
+	require.Len(t, actual.Headers, len(expected.Headers))
+	for key, val := range expected.Headers {
+		assert.Equal(t, strings.TrimSpace(val), actual.Headers[key], key)
+	}
+	assertSameBody(t, expected, actual)
+}
+
+func assertSameBody(t *testing.T, expected, actual *Request) {
+	t.Helper()
+	// nil and empty body mean the same thing
+	assert.True(t, bytes.Equal(expected.Body, actual.Body),
+		"body: %q vs %q", expected.Body, actual.Body)
+}
+
+func FuzzRequestFromReader(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed, uint8(1))
+		f.Add(seed, uint8(7))
+	}
+	f.Fuzz(func(t *testing.T, data string, chunk uint8) {
+		whole, wholeErr := RequestFromReader(&chunkReader{
+			data:            data,
+			numBytesPerRead: len(data) + 1,
+		})
+		chunked, chunkedErr := RequestFromReader(&chunkReader{
+			data:            data,
+			numBytesPerRead: int(chunk)%16 + 1,
+		})
+
+		// how the bytes arrive must never change the result
+		require.Equal(t, wholeErr == nil, chunkedErr == nil,
+			"whole: %v, chunked: %v", wholeErr, chunkedErr)
+		if wholeErr != nil {
+			return
+		}
+		assertSameRequest(t, whole, chunked)
+
+		// whatever we accept, we can write out and read back the same
+		var buf bytes.Buffer
+		_, err := whole.WriteTo(&buf)
+		require.NoError(t, err)
+		again, err := RequestFromReader(&buf)
+		require.NoError(t, err, "reparsing %q", buf.String())
+		assertSameReparsed(t, whole, again)
+	})
+}
@@ -0,0 +1,84 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+var ErrInvalidHost = errors.New("invalid host")
+
+// The host the request is for, lowercased, port and all. An absolute-form
+// target wins over the Host header (RFC 9112 3.2.2).
+func (r *Request) Host() string {
+	target := r.RequestLine.RequestTarget
+	if !strings.HasPrefix(target, "/") && target != "*" {
+		if u, err := url.Parse(target); err == nil && u.IsAbs() && u.Host != "" {
+			return strings.ToLower(u.Host)
+		}
+	}
+	host, _ := r.Headers.Get("Host")
+	return strings.ToLower(host)
+}
+
+// HTTP/1.1 requests have to carry exactly one Host, and a valid one
+// (RFC 9112 3.2). It may be empty when there is no authority to name.
+// Only means something for requests the parser read, HTTP/2 requests
+// name their host in :authority and aren't checked.
+func (r *Request) CheckHost() error {
+	if r.RequestLine.HttpVersion != "1.1" {
+		return nil
+	}
+	if r.hostLines != 1 {
+		return fmt.Errorf("%w: %d host fields", ErrInvalidHost, r.hostLines)
+	}
+	host, _ := r.Headers.Get("Host")
+	if host != "" && !validHost(host) {
+		return fmt.Errorf("%w: %q", ErrInvalidHost, host)
+	}
+	return nil
+}
+
+func isHostLine(line []byte) bool {
+	return len(line) > 5 && line[4] == ':' && bytes.EqualFold(line[:4], []byte("host"))
+}
+
+// uri-host [ ":" port ] (RFC 3986 3.2.2), IPv6 literals in brackets.
+func validHost(host string) bool {
+	if strings.HasPrefix(host, "[") {
+		end := strings.IndexByte(host, ']')
+		if end < 0 || !validIPLiteral(host[1:end]) {
+			return false
+		}
+		host = host[end+1:]
+		if host == "" {
+			return true
+		}
+		if host[0] != ':' {
+			return false
+		}
+		return allDigits(host[1:]) || host == ":"
+	}
+	name, port, hasPort := strings.Cut(host, ":")
+	if hasPort && port != "" && !allDigits(port) {
+		return false
+	}
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		if !((ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') ||
+			(ch >= '0' && ch <= '9') || strings.IndexByte("-._~%!$&'()*+,;=", ch) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func validIPLiteral(s string) bool {
+	return strings.Contains(s, ":") && net.ParseIP(s) != nil
+}
@@ -0,0 +1,48 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckHost(t *testing.T) {
+	check := func(raw string) error {
+		r, err := RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		return r.CheckHost()
+	}
+	for _, good := range []string{
+		"GET / HTTP/1.1\r\nHost: example.test\r\n\r\n",
+		"GET / HTTP/1.1\r\nhost: Example.test:8080\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: 192.0.2.1\r\n\r\n",
+		"OPTIONS * HTTP/1.1\r\nHost: \r\n\r\n",
+	} {
+		assert.NoError(t, check(good), good)
+	}
+	for _, bad := range []string{
+		"GET / HTTP/1.1\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: a.test\r\nHost: b.test\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: \r\nHost: b.test\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: a.test/x\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: a.test:80x\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: [zz]\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: a b\r\n\r\n",
+	} {
+		assert.ErrorIs(t, check(bad), ErrInvalidHost, bad)
+	}
+}
+
+func TestHost(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: API.example.test:8080\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "api.example.test:8080", r.Host())
+
+	// Test: absolute-form beats the header
+	r, err = RequestFromReader(strings.NewReader("GET http://static.example.test/x HTTP/1.1\r\nHost: api.example.test\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "static.example.test", r.Host())
+}
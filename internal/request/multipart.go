@@ -0,0 +1,155 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+)
+
+var ErrMalformedMultipart = errors.New("malformed multipart body")
+
+// A part's header block is never allowed to be bigger than this.
+const maxPartHeaderSize = 16 << 10
+
+// Reads a multipart body (RFC 2046 5.1) part by part as it arrives,
+// holding no more than a buffer of it at once.
+type MultipartReader struct {
+	br *bufio.Reader
+	// "\r\n--boundary", the CRLF belongs to the delimiter, not the part
+	delim   []byte
+	current *Part
+	done    bool
+}
+
+func NewMultipartReader(r io.Reader, boundary string) *MultipartReader {
+	// the first delimiter may open the body without a CRLF before it,
+	// pretend there was one so every delimiter looks the same
+	return &MultipartReader{
+		br:    bufio.NewReader(io.MultiReader(bytes.NewReader([]byte(crlf)), r)),
+		delim: []byte(crlf + "--" + boundary),
+	}
+}
+
+// One part of the body. Read it up to io.EOF, or don't, NextPart skips
+// whatever is left.
+type Part struct {
+	Header headers.Headers
+	// from Content-Disposition, "" when it doesn't say
+	Name     string
+	Filename string
+
+	mr  *MultipartReader
+	eof bool
+}
+
+// The next part, io.EOF after the last one.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+	if mr.current == nil {
+		// the preamble, nobody wants it
+		mr.current = &Part{mr: mr}
+	}
+	if _, err := io.Copy(io.Discard, mr.current); err != nil {
+		return nil, err
+	}
+	mr.br.Discard(len(mr.delim))
+
+	// "--" closes the body, anything after is the epilogue
+	next, err := mr.br.Peek(2)
+	if err != nil {
+		return nil, fmt.Errorf("%w: body ends after a delimiter", ErrMalformedMultipart)
+	}
+	if string(next) == "--" {
+		mr.done = true
+		return nil, io.EOF
+	}
+	// transport padding, then CRLF
+	line, err := mr.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.Trim(line, " \t\r\n")) != 0 {
+		return nil, fmt.Errorf("%w: junk after a delimiter", ErrMalformedMultipart)
+	}
+
+	part := &Part{Header: headers.NewHeaders(), mr: mr}
+	size := 0
+	for {
+		line, err := mr.readLine()
+		if err != nil {
+			return nil, err
+		}
+		size += len(line)
+		if size > maxPartHeaderSize {
+			return nil, fmt.Errorf("%w: part header too large", ErrMalformedMultipart)
+		}
+		_, done, err := part.Header.Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedMultipart, err)
+		}
+		if done {
+			break
+		}
+	}
+	if cd, ok := part.Header.Get("Content-Disposition"); ok {
+		if _, params, err := mime.ParseMediaType(cd); err == nil {
+			part.Name, part.Filename = params["name"], params["filename"]
+		}
+	}
+	mr.current = part
+	return part, nil
+}
+
+// A whole line, CRLF included.
+func (mr *MultipartReader) readLine() ([]byte, error) {
+	line, err := mr.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("%w: line too long", ErrMalformedMultipart)
+	}
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedMultipart, io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(line, []byte(crlf)) {
+		return nil, fmt.Errorf("%w: bare LF", ErrMalformedMultipart)
+	}
+	return line, nil
+}
+
+// Everything up to the next delimiter.
+func (p *Part) Read(b []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	br, delim := p.mr.br, p.mr.delim
+	// enough to tell whether a delimiter starts here
+	if _, err := br.Peek(len(delim)); err != nil {
+		if err == io.EOF {
+			return 0, fmt.Errorf("%w: %w", ErrMalformedMultipart, io.ErrUnexpectedEOF)
+		}
+		return 0, err
+	}
+	buffered, _ := br.Peek(br.Buffered())
+	if i := bytes.Index(buffered, delim); i >= 0 {
+		if i == 0 {
+			p.eof = true
+			return 0, io.EOF
+		}
+		buffered = buffered[:i]
+	} else {
+		// the last bytes may be the start of a delimiter
+		buffered = buffered[:len(buffered)-len(delim)+1]
+	}
+	n := copy(b, buffered)
+	br.Discard(n)
+	return n, nil
+}
@@ -2,6 +2,7 @@ package request
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"fmt"
 	"strings"
@@ -16,13 +17,26 @@ const (
 	initialized requestState = iota
 	parsingHeaders
 	parsingBody
+	parsingChunkSize
+	parsingChunkData
+	parsingChunkEnd // the crlf after each chunk
+	parsingTrailers
 	done
 )
 type Request struct {
 	RequestLine RequestLine
 	Headers headers.Headers
 	Body []byte
+	// fields sent after a chunked body, empty otherwise
+	Trailers headers.Headers
+	// who sent it, as "ip:port". Filled in by the server, not the parser.
+	RemoteAddr string
+	// see Context, unexported so nobody swaps it out from under a handler
+	ctx context.Context
 	state requestState
+	bodyLen int // from Content-Length, looked up once
+	chunkLeft int // bytes left in the current chunk
+	hostLines int // Host fields as sent, the map merges repeats
 }
 
 type RequestLine struct {
@@ -37,47 +51,73 @@ const crlf = "\r\n"
 
 // Loop reading + parsing until the request is done or there are any error
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	const bufferSize = 8
-	buffer := make([]byte, bufferSize)
+	buf := getBuffer()
+	defer putBuffer(buf)
+	return readRequest(reader, buf)
+}
+
+// Reads requests one after another off a connection. Whatever arrives
+// past a request (e.g. the next one, pipelined) stays buffered for the
+// next call instead of getting lost.
+type Reader struct {
+	reader io.Reader
+	buf *buffer
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{reader: reader, buf: getBuffer()}
+}
+
+// io.EOF means the connection ended cleanly between two requests.
+func (r *Reader) ReadRequest() (*Request, error) {
+	return readRequest(r.reader, r.buf)
+}
+
+// A copy of the bytes read but not parsed yet, e.g. for whoever takes the
+// connection over after an Upgrade.
+func (r *Reader) Buffered() []byte {
+	return bytes.Clone(r.buf.unread())
+}
+
+// Hand the buffer back. The Reader can't be used afterwards.
+func (r *Reader) Release() {
+	putBuffer(r.buf)
+	r.buf = nil
+}
+
+// Parse whatever is already buffered first, only go to the reader when
+// the parser says it needs more. Bytes after the request stay in buf.
+func readRequest(reader io.Reader, buf *buffer) (*Request, error) {
 	req := Request {
 		Headers: headers.NewHeaders(),
-		Body: make([]byte, 0),
+		Trailers: headers.NewHeaders(),
 		state: initialized,
 	}
-	readIdx := 0
-	for req.state != done {
-		if readIdx >= len(buffer) {
-			newBuff := make([]byte, len(buffer) * 2)
-			copy(newBuff, buffer)
-			buffer = newBuff
+	for {
+		consumed, err := req.parse(buf.unread())
+		if err != nil {
+			return nil, err
+		}
+		buf.consume(consumed)
+		if req.state == done {
+			return &req, nil
 		}
+
 		// read will read until it can't. So don't be scared of lost chunk
-		read, err := reader.Read(buffer[readIdx:])
+		read, err := buf.fill(reader)
 		// io.EOF in my implementation means we already read EVERYTHING
 		// and there is NOT EVEN a BYTE to read from.
-		if err == io.EOF {
-			if req.state != done {
-				return nil, fmt.Errorf("incomplete request")
+		if read == 0 && err != nil {
+			if err == io.EOF {
+				// nothing at all is a clean end, not a broken request
+				if req.state == initialized && len(buf.unread()) == 0 {
+					return nil, io.EOF
+				}
+				return nil, fmt.Errorf("incomplete request: %w", io.ErrUnexpectedEOF)
 			}
-			break
-		}
-		readIdx += read
-		consumed, err := req.parse(buffer[:readIdx])
-		if err != nil {
 			return nil, err
 		}
-
-		if consumed > 0 {
-			// shift parsed data out
-			newBuff := make([]byte, len(buffer))
-			copy(newBuff, buffer[consumed:])
-			buffer = newBuff
-			// Don't forget to shif the index back, or there will
-			// be a gap of nil in the buffer
-			readIdx -= consumed
-		}
 	}
-	return &req, nil
 }
 
 // - Manage data that stream to request and update the states
@@ -113,32 +153,27 @@ func (r *Request) parseSingle(data []byte) (bytesParsed int, err error) {
 	case parsingHeaders:
 		var parsingDone bool
 		bytesParsed, parsingDone, err = r.Headers.Parse(data)
+		if bytesParsed > 0 && !parsingDone && isHostLine(data) {
+			r.hostLines++
+		}
 		if parsingDone {
-			r.state = parsingBody
+			err = r.startBody()
 		}
 	case parsingBody:
-		reportedLen, found := r.Headers.Get("Content-Length")
-		if !found {
-			r.state = done
-			return 0, nil
+		// only take what Content-Length promised, the rest isn't ours
+		remaining := r.bodyLen - len(r.Body)
+		if len(data) > remaining {
+			data = data[:remaining]
 		}
 		r.Body = append(r.Body, data...)
-		// I to A is "Int to ASCII".
-		contentLen, err := strconv.Atoi(reportedLen)
-		if err != nil {
-			return 0, fmt.Errorf("invalid content-lenght field: %s", reportedLen)
-		}
-		if len(r.Body) > contentLen {
-			return 0, fmt.Errorf(
-				"bad content-lenght: reported: %d | actual: %d",
-				contentLen, len(r.Body),
-			)
-		} else if len(r.Body) == contentLen {
+		if len(r.Body) == r.bodyLen {
 			r.state = done
 		}
 		// If body still less than reported length, then it's ok
 		// because we still not finish parsing
 		return len(data), nil
+	case parsingChunkSize, parsingChunkData, parsingChunkEnd, parsingTrailers:
+		return r.parseChunked(data)
 	case done:
 		return 0, nil
 	default:
@@ -147,6 +182,61 @@ func (r *Request) parseSingle(data []byte) (bytesParsed int, err error) {
 	return
 }
 
+// Don't trust Content-Length for preallocation beyond this.
+const maxBodyPrealloc = 1 << 20
+
+// Read the framing headers once when they are complete, instead of
+// looking them up again every time more body arrives.
+func (r *Request) startBody() error {
+	if te, found := r.Headers.Get("Transfer-Encoding"); found {
+		// both at once is how requests get smuggled (RFC 9112 6.3)
+		if _, hasLen := r.Headers.Get("Content-Length"); hasLen {
+			return fmt.Errorf("both transfer-encoding and content-length")
+		}
+		// chunked is the only transfer coding we can undo
+		if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+			return fmt.Errorf("unsupported transfer-encoding: %s", te)
+		}
+		r.Body = []byte{}
+		r.state = parsingChunkSize
+		return nil
+	}
+	reportedLen, found := r.Headers.Get("Content-Length")
+	if !found {
+		r.state = done
+		return nil
+	}
+	// Content-Length is 1*DIGIT, Atoi alone would also take "+5" or "-0"
+	if !allDigits(reportedLen) {
+		return fmt.Errorf("invalid content-lenght field: %s", reportedLen)
+	}
+	// I to A is "Int to ASCII".
+	contentLen, err := strconv.Atoi(reportedLen)
+	if err != nil {
+		return fmt.Errorf("invalid content-lenght field: %s", reportedLen)
+	}
+	if contentLen == 0 {
+		r.state = done
+		return nil
+	}
+	r.bodyLen = contentLen
+	r.Body = make([]byte, 0, min(contentLen, maxBodyPrealloc))
+	r.state = parsingBody
+	return nil
+}
+
+func allDigits(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, ch := range s {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 // return the bytes it consumed, request line ptr, err
 func parseRequestLine(data []byte) (int, *RequestLine, error) {
 	idx := bytes.Index(data, []byte(crlf))
@@ -165,42 +255,40 @@ func parseRequestLine(data []byte) (int, *RequestLine, error) {
 }
 
 func requestLineFromString(s string) (*RequestLine, error) {
-	parts := strings.Split(s, " ")
-		// request line should have 3 parts
-		if len(parts) != 3 {
-			return nil, fmt.Errorf("couldn't parse request line: %s", s)
-		}
+	// request line should have 3 parts. Cut instead of Split so we
+	// don't allocate a slice for every request.
+	method, rest, found := strings.Cut(s, " ")
+	requestTarget, versionText, found2 := strings.Cut(rest, " ")
+	if !found || !found2 || strings.Contains(versionText, " ") {
+		return nil, fmt.Errorf("couldn't parse request line: %s", s)
+	}
 
-		// first part: method
-		method := parts[0]
-		for _, ch := range method {
-			if ch < 'A' || ch > 'Z' {
-				return nil, fmt.Errorf("method not upper case: %s", parts[0])
-			}
+	// first part: method
+	for _, ch := range method {
+		if ch < 'A' || ch > 'Z' {
+			return nil, fmt.Errorf("method not upper case: %s", method)
 		}
-		// second part: target (nothing to check)
-		requestTarget := parts[1]
+	}
+	// second part: target (nothing to check)
 
-		// last part: version
-		versionParts := strings.Split(parts[2], "/")
-		if len(versionParts) != 2 {
-			return nil, fmt.Errorf("malformed start-line: %s", s)
-		}
+	// last part: version
+	httpPart, version, found := strings.Cut(versionText, "/")
+	if !found || strings.Contains(version, "/") {
+		return nil, fmt.Errorf("malformed start-line: %s", s)
+	}
 
-		httpPart := versionParts[0]
-		if httpPart != "HTTP" {
-			return nil, fmt.Errorf("unrecognized HTTP-version: %s", httpPart)
-		}
-		version := versionParts[1]
-		if version != "1.1" {
-			return nil, fmt.Errorf("unrecognized HTTP-version: %s", version)
-		}
+	if httpPart != "HTTP" {
+		return nil, fmt.Errorf("unrecognized HTTP-version: %s", httpPart)
+	}
+	if version != "1.1" {
+		return nil, fmt.Errorf("unrecognized HTTP-version: %s", version)
+	}
 
-		return &RequestLine{
-			Method: method,
-			RequestTarget: requestTarget,
-			HttpVersion: version,
-		}, nil
+	return &RequestLine{
+		Method: method,
+		RequestTarget: requestTarget,
+		HttpVersion: version,
+	}, nil
 }
 
 func (r *Request) PrintRequest() {
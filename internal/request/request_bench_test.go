@@ -0,0 +1,67 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const typicalRequest = "GET /coffee HTTP/1.1\r\n" +
+	"Host: localhost:42069\r\n" +
+	"User-Agent: curl/7.81.0\r\n" +
+	"Accept: */*\r\n" +
+	"Accept-Encoding: gzip, deflate\r\n" +
+	"Connection: keep-alive\r\n" +
+	"\r\n"
+
+// a request with a lot of big headers, like a browser with many cookies
+func largeRequest() string {
+	var sb strings.Builder
+	sb.WriteString("POST /submit HTTP/1.1\r\nHost: localhost:42069\r\n")
+	for i := range 60 {
+		fmt.Fprintf(&sb, "X-Header-%d: %s\r\n", i, strings.Repeat("v", 100))
+	}
+	body := strings.Repeat("b", 2048)
+	fmt.Fprintf(&sb, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return sb.String()
+}
+
+func benchmarkRequest(b *testing.B, raw string) {
+	data := []byte(raw)
+	reader := bytes.NewReader(data)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		reader.Reset(data)
+		if _, err := RequestFromReader(reader); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRequestFromReaderTypical(b *testing.B) {
+	benchmarkRequest(b, typicalRequest)
+}
+
+func BenchmarkRequestFromReaderLargeHeaders(b *testing.B) {
+	benchmarkRequest(b, largeRequest())
+}
+
+// Same request, but the network hands it to us in pieces of different sizes.
+func BenchmarkRequestFromReaderChunkSizes(b *testing.B) {
+	raw := largeRequest()
+	for _, size := range []int{1, 8, 64, 512, 4096} {
+		b.Run(fmt.Sprintf("chunk=%d", size), func(b *testing.B) {
+			reader := &chunkReader{data: raw, numBytesPerRead: size}
+			b.ReportAllocs()
+			b.SetBytes(int64(len(raw)))
+			for b.Loop() {
+				reader.pos = 0
+				if _, err := RequestFromReader(reader); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
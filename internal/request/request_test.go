@@ -1,8 +1,10 @@
 package request
 
 import (
+	"context"
 	"testing"
 	"io"
+	"strings"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -225,3 +227,81 @@ func TestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 }
+
+func TestContentLengthDigitsOnly(t *testing.T) {
+	// Atoi would take all of these, Content-Length is 1*DIGIT
+	for _, length := range []string{"+5", "-0", "-5", " 5 5", "0x5", "5.0"} {
+		reader := &chunkReader{
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Length: " + length + "\r\n" +
+				"\r\n" +
+				"hello",
+			numBytesPerRead: 3,
+		}
+		_, err := RequestFromReader(reader)
+		assert.Error(t, err, length)
+	}
+
+	// Test: leading zeros are still digits
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 005\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+}
+
+func TestReaderPipelined(t *testing.T) {
+	reader := NewReader(&chunkReader{
+		data: "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 2\r\n\r\nhi" +
+			"GET /b HTTP/1.1\r\nHost: x\r\n\r\n" +
+			"GET /c HT",
+		numBytesPerRead: 7,
+	})
+	defer reader.Release()
+
+	// Test: requests come out one at a time, in order
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/a", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hi", string(r.Body))
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/b", r.RequestLine.RequestTarget)
+
+	// Test: half a request at the end is an error, not a clean end
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, "GET /c HT", string(reader.Buffered()))
+
+	// Test: nothing at all after the last request is io.EOF
+	reader = NewReader(&chunkReader{data: "GET / HTTP/1.1\r\nHost: x\r\n\r\n", numBytesPerRead: 5})
+	defer reader.Release()
+	_, err = reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = reader.ReadRequest()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestClone(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\nAccept: */*\r\n\r\n"))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	clone := r.Clone()
+	clone.Headers.Reset("Host", "upstream")
+	clone.Headers.Set("Accept", "text/html")
+	clone.RequestLine.RequestTarget = "/api/"
+	assert.Equal(t, "x", r.Headers["host"])
+	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "/", r.RequestLine.RequestTarget)
+	assert.Equal(t, ctx, clone.Context())
+}
@@ -0,0 +1,43 @@
+package request
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Write the request back out in wire format, e.g. to send it upstream.
+// Headers go out sorted so the same request always gives the same bytes.
+// Content-Length is fixed up to match Body if they disagree.
+func (r *Request) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteString(r.RequestLine.Method + " " + r.RequestLine.RequestTarget +
+		" HTTP/" + r.RequestLine.HttpVersion + crlf)
+
+	keys := make([]string, 0, len(r.Headers))
+	for key := range r.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	wroteLen := false
+	for _, key := range keys {
+		val := r.Headers[key]
+		if key == "content-length" {
+			if n, err := strconv.Atoi(val); err != nil || n != len(r.Body) {
+				val = strconv.Itoa(len(r.Body))
+			}
+			wroteLen = true
+		}
+		buf.WriteString(key + ": " + val + crlf)
+	}
+	if !wroteLen && len(r.Body) > 0 {
+		buf.WriteString("content-length: " + strconv.Itoa(len(r.Body)) + crlf)
+	}
+	buf.WriteString(crlf)
+	buf.Write(r.Body)
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
@@ -0,0 +1,246 @@
+package response
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+)
+
+// Bodies smaller than this are not worth the gzip header + CPU.
+const DefaultMinCompressSize = 1024
+
+// One coding from Accept-Encoding, e.g. "gzip;q=0.8"
+type AcceptedEncoding struct {
+	Coding string
+	Q      float64
+}
+
+// Parse Accept-Encoding into codings sorted by q-value (highest first).
+// Malformed q-values are treated as q=0 so they never get picked.
+func ParseAcceptEncoding(field string) []AcceptedEncoding {
+	accepted := []AcceptedEncoding{}
+	for _, part := range strings.Split(field, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		coding, params, _ := strings.Cut(part, ";")
+		enc := AcceptedEncoding{
+			Coding: strings.ToLower(strings.TrimSpace(coding)),
+			Q:      1,
+		}
+		for _, param := range strings.Split(params, ";") {
+			key, val, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			enc.Q = q
+		}
+		accepted = append(accepted, enc)
+	}
+	// stable, so the client's order breaks ties
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].Q > accepted[j].Q
+	})
+	return accepted
+}
+
+// Pick the coding we should use for a response. Return "" for identity.
+func NegotiateEncoding(acceptEncoding string) string {
+	supported := []string{"gzip", "deflate"}
+	accepted := ParseAcceptEncoding(acceptEncoding)
+
+	best, bestQ := "", 0.0
+	for _, coding := range supported {
+		q := codingQ(accepted, coding)
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// q-value of one coding, falling back to "*" when it isn't listed.
+func codingQ(accepted []AcceptedEncoding, coding string) float64 {
+	wildcard := -1.0
+	for _, enc := range accepted {
+		if enc.Coding == coding {
+			return enc.Q
+		}
+		if enc.Coding == "*" && wildcard < 0 {
+			wildcard = enc.Q
+		}
+	}
+	if wildcard < 0 {
+		return 0
+	}
+	return wildcard
+}
+
+// Report whether compressing this media type is worth it. Images, video,
+// audio and archives are already compressed, so we leave them alone.
+func Compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json",
+		"application/javascript",
+		"application/xml",
+		"application/x-www-form-urlencoded",
+		"image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// CompressWriter sits in front of a Writer and compresses the body with the
+// coding negotiated from Accept-Encoding. Compressed bodies always go through
+// the chunked path since we don't know the final length up front.
+type CompressWriter struct {
+	w        *Writer
+	encoding string
+	// Bodies with a known Content-Length below this are sent as-is.
+	MinSize int
+	// Set when answering HEAD, there is no body to compress then.
+	Head bool
+
+	code    StatusCode
+	zw      io.WriteCloser // nil when we are passing through
+	chunked bool
+}
+
+func NewCompressWriter(w *Writer, acceptEncoding string) *CompressWriter {
+	return &CompressWriter{
+		w:        w,
+		encoding: NegotiateEncoding(acceptEncoding),
+		MinSize:  DefaultMinCompressSize,
+	}
+}
+
+func (cw *CompressWriter) WriteStatusLine(code StatusCode) error {
+	cw.code = code
+	return cw.w.WriteStatusLine(code)
+}
+
+// Decide whether to compress based on the headers, then send a copy
+// rewritten to match. h itself is left alone.
+func (cw *CompressWriter) WriteHeaders(h headers.Headers) error {
+	h = h.Clone()
+	if cw.shouldCompress(h) {
+		h.Delete("Content-Length")
+		h.Reset("Transfer-Encoding", "chunked")
+		h.Reset("Content-Encoding", cw.encoding)
+		zw, err := cw.newCompressor()
+		if err != nil {
+			return err
+		}
+		cw.zw = zw
+	}
+	// the response depends on Accept-Encoding either way, so caches must know
+	if cw.encoding != "" {
+		h.Set("Vary", "Accept-Encoding")
+	}
+	te, _ := h.Get("Transfer-Encoding")
+	cw.chunked = strings.EqualFold(te, "chunked")
+	return cw.w.WriteHeaders(h)
+}
+
+func (cw *CompressWriter) shouldCompress(h headers.Headers) bool {
+	if cw.encoding == "" {
+		return false
+	}
+	// a gzip stream where no body may go would be read as the next response
+	if cw.Head || bodyless(cw.code) {
+		return false
+	}
+	if _, ok := h.Get("Content-Encoding"); ok {
+		return false
+	}
+	contentType, _ := h.Get("Content-Type")
+	if !Compressible(contentType) {
+		return false
+	}
+	if reported, ok := h.Get("Content-Length"); ok {
+		contentLen, err := strconv.Atoi(reported)
+		if err == nil && contentLen < cw.MinSize {
+			return false
+		}
+	}
+	return true
+}
+
+func (cw *CompressWriter) newCompressor() (io.WriteCloser, error) {
+	sink := chunkSink{w: cw.w}
+	switch cw.encoding {
+	case "gzip":
+		return gzip.NewWriter(sink), nil
+	case "deflate":
+		// "deflate" in HTTP means zlib-wrapped deflate (RFC 9110 8.4.1.2)
+		return zlib.NewWriter(sink), nil
+	}
+	return nil, fmt.Errorf("unsupported encoding: %s", cw.encoding)
+}
+
+// Can be called many times. Compressed data may not reach the client until
+// the compressor fills a block or Close is called.
+func (cw *CompressWriter) Write(p []byte) (int, error) {
+	if cw.zw != nil {
+		return cw.zw.Write(p)
+	}
+	if cw.chunked {
+		return cw.w.WriteChunkedBody(p)
+	}
+	return cw.w.writeBodyPart(p)
+}
+
+// Flush the compressor and finish the chunked body (if any).
+func (cw *CompressWriter) Close() error {
+	if cw.zw != nil {
+		if err := cw.zw.Close(); err != nil {
+			return err
+		}
+	}
+	if !cw.chunked {
+		cw.w.state = done
+		return nil
+	}
+	if _, err := cw.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return cw.w.WriteTrailers(headers.NewHeaders())
+}
+
+// Turn every compressor output into one chunk.
+type chunkSink struct {
+	w *Writer
+}
+
+func (s chunkSink) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		// zero-length chunk means end of body, don't send it by accident
+		return 0, nil
+	}
+	if _, err := s.w.WriteChunkedBody(p); err != nil {
+		return 0, err
+	}
+	// report only the payload, not the chunk framing
+	return len(p), nil
+}
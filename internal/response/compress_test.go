@@ -0,0 +1,178 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// net.Conn that only records what gets written to it
+type bufConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *bufConn) Write(p []byte) (int, error) {
+	return c.buf.Write(p)
+}
+
+func TestParseAcceptEncoding(t *testing.T) {
+	// Test: q-values sort, ties keep client order
+	accepted := ParseAcceptEncoding("deflate;q=0.5, gzip, br;q=1.0, *;q=0")
+	require.Len(t, accepted, 4)
+	assert.Equal(t, "gzip", accepted[0].Coding)
+	assert.Equal(t, "br", accepted[1].Coding)
+	assert.Equal(t, "deflate", accepted[2].Coding)
+	assert.Equal(t, 0.5, accepted[2].Q)
+	assert.Equal(t, "*", accepted[3].Coding)
+
+	// Test: malformed q-value is never picked
+	accepted = ParseAcceptEncoding("gzip;q=banana")
+	require.Len(t, accepted, 1)
+	assert.Equal(t, 0.0, accepted[0].Q)
+
+	// Test: empty field
+	assert.Empty(t, ParseAcceptEncoding(""))
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	assert.Equal(t, "gzip", NegotiateEncoding("gzip, deflate, br"))
+	assert.Equal(t, "deflate", NegotiateEncoding("gzip;q=0.2, deflate"))
+	assert.Equal(t, "deflate", NegotiateEncoding("gzip;q=0, *"))
+	assert.Equal(t, "gzip", NegotiateEncoding("*"))
+	assert.Equal(t, "", NegotiateEncoding("br"))
+	assert.Equal(t, "", NegotiateEncoding("gzip;q=0, deflate;q=0"))
+	assert.Equal(t, "", NegotiateEncoding(""))
+}
+
+func TestCompressible(t *testing.T) {
+	assert.True(t, Compressible("text/html"))
+	assert.True(t, Compressible("application/json; charset=utf-8"))
+	assert.True(t, Compressible("application/problem+json"))
+	assert.False(t, Compressible("video/mp4"))
+	assert.False(t, Compressible("image/png"))
+	assert.False(t, Compressible("application/zip"))
+	assert.False(t, Compressible(""))
+}
+
+func TestCompressWriter(t *testing.T) {
+	body := bytes.Repeat([]byte("<p>hello compression</p>\n"), 200)
+
+	// Test: gzip html body
+	conn := &bufConn{}
+	cw := NewCompressWriter(NewResponseWriter(conn), "gzip, deflate")
+	require.NoError(t, cw.WriteStatusLine(StatusOK))
+	h := GetDefaultHeaders(len(body))
+	h.Reset("Content-Type", "text/html")
+	require.NoError(t, cw.WriteHeaders(h))
+	_, err := cw.Write(body[:100])
+	require.NoError(t, err)
+	_, err = cw.Write(body[100:])
+	require.NoError(t, err)
+	require.NoError(t, cw.Close())
+
+	res, err := ResponseFromReader(&conn.buf, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", res.Headers["content-encoding"])
+	assert.Equal(t, "Accept-Encoding", res.Headers["vary"])
+	assert.Equal(t, "chunked", res.Headers["transfer-encoding"])
+	_, found := res.Headers.Get("Content-Length")
+	assert.False(t, found)
+	assert.Less(t, len(res.Body), len(body))
+	zr, err := gzip.NewReader(bytes.NewReader(res.Body))
+	require.NoError(t, err)
+	decompressed, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, body, decompressed)
+
+	// Test: deflate when the client prefers it
+	conn = &bufConn{}
+	cw = NewCompressWriter(NewResponseWriter(conn), "deflate, gzip;q=0.5")
+	require.NoError(t, cw.WriteStatusLine(StatusOK))
+	h = GetDefaultHeaders(len(body))
+	require.NoError(t, cw.WriteHeaders(h))
+	_, err = cw.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, cw.Close())
+
+	res, err = ResponseFromReader(&conn.buf, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "deflate", res.Headers["content-encoding"])
+	zr2, err := zlib.NewReader(bytes.NewReader(res.Body))
+	require.NoError(t, err)
+	decompressed, err = io.ReadAll(zr2)
+	require.NoError(t, err)
+	assert.Equal(t, body, decompressed)
+
+	// Test: video is passed through untouched
+	conn = &bufConn{}
+	cw = NewCompressWriter(NewResponseWriter(conn), "gzip")
+	require.NoError(t, cw.WriteStatusLine(StatusOK))
+	h = GetDefaultHeaders(len(body))
+	h.Reset("Content-Type", "video/mp4")
+	require.NoError(t, cw.WriteHeaders(h))
+	_, err = cw.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, cw.Close())
+	res, err = ResponseFromReader(&conn.buf, "GET")
+	require.NoError(t, err)
+	_, found = res.Headers.Get("Content-Encoding")
+	assert.False(t, found)
+	assert.Equal(t, strconv.Itoa(len(body)), res.Headers["content-length"])
+	assert.Equal(t, body, res.Body)
+
+	// Test: small body stays below the threshold
+	conn = &bufConn{}
+	cw = NewCompressWriter(NewResponseWriter(conn), "gzip")
+	require.NoError(t, cw.WriteStatusLine(StatusOK))
+	require.NoError(t, cw.WriteHeaders(GetDefaultHeaders(5)))
+	_, err = cw.Write([]byte("small"))
+	require.NoError(t, err)
+	require.NoError(t, cw.Close())
+	res, err = ResponseFromReader(&conn.buf, "GET")
+	require.NoError(t, err)
+	_, found = res.Headers.Get("Content-Encoding")
+	assert.False(t, found)
+	assert.Equal(t, "Accept-Encoding", res.Headers["vary"])
+	assert.Equal(t, "small", string(res.Body))
+}
+
+func TestCompressWriterNoBody(t *testing.T) {
+	for _, tc := range []struct {
+		code StatusCode
+		head bool
+	}{
+		{code: 204},
+		{code: 304},
+		{code: StatusOK, head: true},
+	} {
+		conn := &bufConn{}
+		cw := NewCompressWriter(NewResponseWriter(conn), "gzip")
+		cw.Head = tc.head
+		require.NoError(t, cw.WriteStatusLine(tc.code))
+		h := GetDefaultHeaders(4096)
+		h.Reset("Content-Type", "text/html")
+		require.NoError(t, cw.WriteHeaders(h))
+		require.NoError(t, cw.Close())
+		// the caller's headers are untouched either way
+		assert.NotContains(t, h, "vary")
+
+		method := "GET"
+		if tc.head {
+			method = "HEAD"
+		}
+		res, err := ResponseFromReader(&conn.buf, method)
+		require.NoError(t, err)
+		assert.NotContains(t, res.Headers, "content-encoding", tc.code)
+		assert.NotContains(t, res.Headers, "transfer-encoding", tc.code)
+		// nothing left over to be mistaken for the next response
+		assert.Zero(t, conn.buf.Len(), tc.code)
+	}
+}
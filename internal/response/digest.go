@@ -0,0 +1,94 @@
+package response
+
+import (
+	"encoding/hex"
+	"strconv"
+
+	"github.com/WaronLimsakul/learn_http/internal/digest"
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+)
+
+// Wraps a Writer sending a chunked body and digests every chunk on its way
+// out, then sends the digests as trailers (RFC 9530). Nothing is buffered.
+//
+//	dw := NewDigestWriter(w, digest.SHA256)
+//	dw.AnnounceTrailers(h)
+//	w.WriteStatusLine(...); w.WriteHeaders(h)
+//	dw.WriteChunkedBody(...)
+//	dw.WriteChunkedBodyDone()
+//	dw.WriteTrailers(trailers)
+type DigestWriter struct {
+	w      *Writer
+	Digest *digest.Digester
+	// also send Repr-Digest. Only right when the body is the whole
+	// representation, so not for 206 responses.
+	Repr bool
+	// also send X-Content-SHA256 (hex) and X-Content-Length, for clients
+	// that predate Content-Digest. Needs digest.SHA256 among the algorithms.
+	Legacy bool
+}
+
+// No algorithms means digest.SHA256.
+func NewDigestWriter(w *Writer, algorithms ...digest.Algorithm) *DigestWriter {
+	return &DigestWriter{w: w, Digest: digest.New(algorithms...)}
+}
+
+// Names of the trailer fields we will send.
+func (dw *DigestWriter) TrailerNames() []string {
+	names := []string{"Content-Digest"}
+	if dw.Repr {
+		names = append(names, "Repr-Digest")
+	}
+	if dw.Legacy {
+		names = append(names, "X-Content-SHA256", "X-Content-Length")
+	}
+	return names
+}
+
+// Add our fields to the Trailer header, call before WriteHeaders.
+func (dw *DigestWriter) AnnounceTrailers(h headers.Headers) {
+	for _, name := range dw.TrailerNames() {
+		if !h.HasToken("Trailer", name) {
+			h.Set("Trailer", name)
+		}
+	}
+}
+
+func (dw *DigestWriter) WriteChunkedBody(p []byte) (int, error) {
+	n, err := dw.w.WriteChunkedBody(p)
+	if err == nil {
+		dw.Digest.Write(p)
+	}
+	return n, err
+}
+
+// So it can sit behind io.Copy. Returns len(p) on success.
+func (dw *DigestWriter) Write(p []byte) (int, error) {
+	if _, err := dw.WriteChunkedBody(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (dw *DigestWriter) WriteChunkedBodyDone() (int, error) {
+	return dw.w.WriteChunkedBodyDone()
+}
+
+// Send h's trailers along with ours. h may be nil.
+func (dw *DigestWriter) WriteTrailers(h headers.Headers) error {
+	trailers := headers.NewHeaders()
+	for key, val := range h {
+		trailers[key] = val
+	}
+	field := dw.Digest.Field()
+	trailers.Reset("Content-Digest", field)
+	if dw.Repr {
+		trailers.Reset("Repr-Digest", field)
+	}
+	if dw.Legacy {
+		trailers.Reset("X-Content-SHA256", hex.EncodeToString(dw.Digest.Sum(digest.SHA256)))
+		trailers.Reset("X-Content-Length", strconv.FormatInt(dw.Digest.Len(), 10))
+	}
+	dw.AnnounceTrailers(trailers)
+	return dw.w.WriteTrailers(trailers)
+}
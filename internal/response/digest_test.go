@@ -0,0 +1,73 @@
+package response
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/digest"
+)
+
+func TestDigestWriter(t *testing.T) {
+	body := bytes.Repeat([]byte("streamed, never buffered\n"), 1000)
+	conn := &bufConn{}
+	w := NewResponseWriter(conn)
+	dw := NewDigestWriter(w, digest.SHA256, digest.CRC32C)
+	dw.Repr = true
+	dw.Legacy = true
+
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Extra")
+	dw.AnnounceTrailers(h)
+	assert.Equal(t, "X-Extra, Content-Digest, Repr-Digest, X-Content-SHA256, X-Content-Length", h["trailer"])
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+
+	_, err := dw.WriteChunkedBody(body[:7])
+	require.NoError(t, err)
+	_, err = io.Copy(dw, bytes.NewReader(body[7:]))
+	require.NoError(t, err)
+	_, err = dw.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, dw.WriteTrailers(map[string]string{"x-extra": "1", "trailer": "X-Extra"}))
+
+	res, err := ResponseFromReader(&conn.buf, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, body, res.Body)
+	assert.Equal(t, "1", res.Trailers["x-extra"])
+
+	sums, err := digest.Parse(res.Trailers["content-digest"])
+	require.NoError(t, err)
+	want := sha256.Sum256(body)
+	assert.Equal(t, want[:], sums[digest.SHA256])
+	assert.Len(t, sums[digest.CRC32C], 4)
+	assert.Equal(t, res.Trailers["content-digest"], res.Trailers["repr-digest"])
+	assert.Equal(t, hex.EncodeToString(want[:]), res.Trailers["x-content-sha256"])
+	assert.Equal(t, strconv.Itoa(len(body)), res.Trailers["x-content-length"])
+
+	// Test: defaults send only Content-Digest with sha-256
+	conn = &bufConn{}
+	w = NewResponseWriter(conn)
+	dw = NewDigestWriter(w)
+	h = GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	dw.AnnounceTrailers(h)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(h)
+	dw.WriteChunkedBodyDone()
+	require.NoError(t, dw.WriteTrailers(nil))
+	res, err = ResponseFromReader(&conn.buf, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "sha-256=:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=:", res.Trailers["content-digest"])
+	_, found := res.Trailers.Get("Repr-Digest")
+	assert.False(t, found)
+}
@@ -0,0 +1,329 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+)
+
+type responseState int
+
+const (
+	parsingStatusLine responseState = iota
+	parsingHeaders
+	parsingFixedBody // Content-Length
+	parsingCloseBody // read until the server hangs up
+	parsingChunkSize
+	parsingChunkData
+	parsingChunkEnd // the crlf after each chunk
+	parsingTrailers
+	parseDone
+)
+
+// A parsed response, mostly for tests and clients. Writer is what we use
+// to send one.
+type Response struct {
+	HttpVersion string
+	StatusCode  StatusCode
+	Reason      string
+	Headers     headers.Headers
+	// Set-Cookie values one per line as sent, they'd break if merged into
+	// Headers with commas
+	Cookies  []string
+	Trailers headers.Headers
+	Body     []byte
+}
+
+const parseBufferSize = 4096
+
+// The response side of RequestFromReader: same state machine idea, but
+// the body is handed out through Read instead of collected up front.
+type BodyReader struct {
+	res       *Response
+	state     responseState
+	method    string
+	reader    io.Reader
+	buf       []byte
+	r         int
+	w         int
+	remaining int64 // bytes left in the fixed body or current chunk
+	// body ends when the connection does, so it can't be reused
+	closeDelimited bool
+	// the header line being parsed
+	line headers.Headers
+}
+
+// Parse a whole response from reader, body and trailers included.
+// method is the request method, a response to HEAD never has a body.
+func ResponseFromReader(reader io.Reader, method string) (*Response, error) {
+	res, body, err := ReadResponseHead(reader, method)
+	if err != nil {
+		return nil, err
+	}
+	res.Body, err = io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Parse only the status line and headers. The returned reader streams the
+// body (already de-chunked) and fills res.Trailers once it hits io.EOF.
+// Bytes read past the end of the response are kept in Buffered.
+func ReadResponseHead(reader io.Reader, method string) (*Response, *BodyReader, error) {
+	p := &BodyReader{
+		res: &Response{
+			Headers:  headers.NewHeaders(),
+			Trailers: headers.NewHeaders(),
+		},
+		state:  parsingStatusLine,
+		method: method,
+		reader: reader,
+		buf:    make([]byte, parseBufferSize),
+	}
+	for p.state == parsingStatusLine || p.state == parsingHeaders {
+		consumed, err := p.parseHead(p.buf[p.r:p.w])
+		if err != nil {
+			return nil, nil, err
+		}
+		p.r += consumed
+		if consumed > 0 {
+			continue
+		}
+		if err := p.fill(); err != nil {
+			if err == io.EOF {
+				return nil, nil, fmt.Errorf("incomplete response head")
+			}
+			return nil, nil, err
+		}
+	}
+	return p.res, p, nil
+}
+
+func (p *BodyReader) parseHead(data []byte) (int, error) {
+	switch p.state {
+	case parsingStatusLine:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		if err := p.parseStatusLine(string(data[:idx])); err != nil {
+			return 0, err
+		}
+		p.state = parsingHeaders
+		return idx + 2, nil
+	case parsingHeaders:
+		// one line at a time, so a Set-Cookie can be kept apart
+		if p.line == nil {
+			p.line = headers.NewHeaders()
+		}
+		line := p.line
+		clear(line)
+		n, headersDone, err := line.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		for key, val := range line {
+			if key == "set-cookie" {
+				p.res.Cookies = append(p.res.Cookies, val)
+				continue
+			}
+			p.res.Headers.Set(key, val)
+		}
+		if headersDone {
+			err = p.startBody()
+		}
+		return n, err
+	}
+	return 0, fmt.Errorf("invalid response state: %v", p.state)
+}
+
+// e.g. "HTTP/1.1 404 Not Found", the reason can be empty
+func (p *BodyReader) parseStatusLine(s string) error {
+	version, rest, found := strings.Cut(s, " ")
+	if !found {
+		return fmt.Errorf("couldn't parse status line: %s", s)
+	}
+	httpPart, versionNum, found := strings.Cut(version, "/")
+	if !found || httpPart != "HTTP" {
+		return fmt.Errorf("unrecognized HTTP-version: %s", version)
+	}
+	code, reason, _ := strings.Cut(rest, " ")
+	if len(code) != 3 {
+		return fmt.Errorf("invalid status code: %s", code)
+	}
+	statusCode, err := strconv.Atoi(code)
+	if err != nil || statusCode < 100 {
+		return fmt.Errorf("invalid status code: %s", code)
+	}
+	p.res.HttpVersion = versionNum
+	p.res.StatusCode = StatusCode(statusCode)
+	p.res.Reason = reason
+	return nil
+}
+
+// Pick the body framing once the headers are in (RFC 9112 section 6.3).
+func (p *BodyReader) startBody() error {
+	code := p.res.StatusCode
+	// 1xx are interim, the real response comes right after
+	if code >= 100 && code < 200 && code != 101 {
+		p.res.Headers = headers.NewHeaders()
+		p.state = parsingStatusLine
+		return nil
+	}
+	if p.method == "HEAD" || code == 204 || code == 304 || code < 200 {
+		p.state = parseDone
+		return nil
+	}
+	if te, ok := p.res.Headers.Get("Transfer-Encoding"); ok {
+		codings := strings.Split(te, ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			p.state = parsingCloseBody
+			p.closeDelimited = true
+			return nil
+		}
+		p.state = parsingChunkSize
+		return nil
+	}
+	if reported, ok := p.res.Headers.Get("Content-Length"); ok {
+		contentLen, err := strconv.ParseInt(reported, 10, 64)
+		if err != nil || contentLen < 0 {
+			return fmt.Errorf("invalid content-length field: %s", reported)
+		}
+		p.remaining = contentLen
+		p.state = parsingFixedBody
+		if contentLen == 0 {
+			p.state = parseDone
+		}
+		return nil
+	}
+	p.state = parsingCloseBody
+	p.closeDelimited = true
+	return nil
+}
+
+// Copy as much body as we can from the buffered data into out.
+// Returns bytes written to out and bytes consumed from data.
+func (p *BodyReader) parseBody(data, out []byte) (int, int, error) {
+	switch p.state {
+	case parsingFixedBody, parsingChunkData:
+		n := copy(out, data[:min(int64(len(data)), p.remaining)])
+		p.remaining -= int64(n)
+		if p.remaining == 0 {
+			if p.state == parsingFixedBody {
+				p.state = parseDone
+			} else {
+				p.state = parsingChunkEnd
+			}
+		}
+		return n, n, nil
+	case parsingCloseBody:
+		n := copy(out, data)
+		return n, n, nil
+	case parsingChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, 0, nil
+		}
+		// ignore chunk extensions like "1A;name=val"
+		sizeText, _, _ := strings.Cut(string(data[:idx]), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 16, 64)
+		if err != nil || size < 0 {
+			return 0, 0, fmt.Errorf("invalid chunk size: %s", sizeText)
+		}
+		if size == 0 {
+			p.state = parsingTrailers
+		} else {
+			p.remaining = size
+			p.state = parsingChunkData
+		}
+		return 0, idx + 2, nil
+	case parsingChunkEnd:
+		if len(data) < 2 {
+			return 0, 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, 0, fmt.Errorf("missing crlf after chunk")
+		}
+		p.state = parsingChunkSize
+		return 0, 2, nil
+	case parsingTrailers:
+		n, trailersDone, err := p.res.Trailers.Parse(data)
+		if trailersDone {
+			p.state = parseDone
+		}
+		return 0, n, err
+	}
+	return 0, 0, fmt.Errorf("invalid response state: %v", p.state)
+}
+
+func (p *BodyReader) Read(out []byte) (int, error) {
+	if len(out) == 0 {
+		return 0, nil
+	}
+	for p.state != parseDone {
+		n, consumed, err := p.parseBody(p.buf[p.r:p.w], out)
+		p.r += consumed
+		if err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		if consumed > 0 {
+			continue
+		}
+		err = p.fill()
+		if err == io.EOF {
+			if p.state == parsingCloseBody {
+				p.state = parseDone
+				break
+			}
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 0, io.EOF
+}
+
+// Bytes we read from the connection that belong to whatever comes after
+// this response. Only meaningful once Read has returned io.EOF.
+func (p *BodyReader) Buffered() []byte {
+	return p.buf[p.r:p.w]
+}
+
+// Report whether the body is framed by the connection closing.
+func (p *BodyReader) CloseDelimited() bool {
+	return p.closeDelimited
+}
+
+// Read more from the connection, making room the same way the request
+// buffer does: slide unread bytes to the front, grow only when full.
+func (p *BodyReader) fill() error {
+	if p.r == p.w {
+		p.r, p.w = 0, 0
+	}
+	if p.w == len(p.buf) {
+		if p.r > 0 {
+			copy(p.buf, p.buf[p.r:p.w])
+			p.w -= p.r
+			p.r = 0
+		} else {
+			grown := make([]byte, len(p.buf)*2)
+			copy(grown, p.buf[:p.w])
+			p.buf = grown
+		}
+	}
+	n, err := p.reader.Read(p.buf[p.w:])
+	p.w += n
+	if n > 0 {
+		return nil
+	}
+	return err
+}
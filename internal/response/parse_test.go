@@ -0,0 +1,163 @@
+package response
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// hand out at most numBytesPerRead bytes per call, like a slow network
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := min(cr.pos+cr.numBytesPerRead, len(cr.data))
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+	return n, nil
+}
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: Content-Length body
+	reader := &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 13\r\nContent-Type: text/plain\r\n\r\nhello world!\n",
+		numBytesPerRead: 3,
+	}
+	res, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", res.HttpVersion)
+	assert.Equal(t, StatusOK, res.StatusCode)
+	assert.Equal(t, "OK", res.Reason)
+	assert.Equal(t, "text/plain", res.Headers["content-type"])
+	assert.Equal(t, "hello world!\n", string(res.Body))
+
+	// Test: chunked body with extensions and trailers
+	reader = &chunkReader{
+		data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n" +
+			"5;note=hi\r\nhello\r\n1\r\n!\r\n0\r\nX-Sum: 42\r\n\r\n",
+		numBytesPerRead: 1,
+	}
+	res, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello!", string(res.Body))
+	assert.Equal(t, "42", res.Trailers["x-sum"])
+
+	// Test: close-delimited body, empty reason
+	reader = &chunkReader{
+		data:            "HTTP/1.1 500 \r\n\r\nbye bye",
+		numBytesPerRead: 4,
+	}
+	res, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusServerError, res.StatusCode)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, "bye bye", string(res.Body))
+
+	// Test: HEAD has headers describing a body that never comes
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	res, err = ResponseFromReader(reader, "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "1000", res.Headers["content-length"])
+	assert.Empty(t, res.Body)
+
+	// Test: 204 and 304 never have a body, even without framing headers
+	for _, raw := range []string{
+		"HTTP/1.1 204 No Content\r\n\r\n",
+		"HTTP/1.1 304 Not Modified\r\nETag: \"abc\"\r\nContent-Length: 10\r\n\r\n",
+	} {
+		res, err = ResponseFromReader(&chunkReader{data: raw, numBytesPerRead: 2}, "GET")
+		require.NoError(t, err)
+		assert.Empty(t, res.Body)
+	}
+
+	// Test: Set-Cookie lines stay apart, other repeats merge
+	reader = &chunkReader{
+		data: "HTTP/1.1 200 OK\r\nSet-Cookie: a=1; Expires=Wed, 02 Jan 2030 03:04:05 GMT\r\n" +
+			"Vary: Accept\r\nset-cookie: b=2\r\nVary: Cookie\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	res, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a=1; Expires=Wed, 02 Jan 2030 03:04:05 GMT", "b=2"}, res.Cookies)
+	assert.NotContains(t, res.Headers, "set-cookie")
+	assert.Equal(t, "Accept, Cookie", res.Headers["vary"])
+
+	// Test: truncated chunked body
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nA\r\nonly",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: bad chunk size
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+
+	// Test: malformed status lines
+	for _, raw := range []string{
+		"HTTP/1.1\r\n\r\n",
+		"HTTP/1.1 2000 OK\r\n\r\n",
+		"HTTX/1.1 200 OK\r\n\r\n",
+		"HTTP/1.1 abc OK\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n",
+	} {
+		_, err = ResponseFromReader(&chunkReader{data: raw, numBytesPerRead: 3}, "GET")
+		require.Error(t, err, raw)
+	}
+}
+
+func TestWriterOutputParses(t *testing.T) {
+	// Test: fixed-length response
+	conn := &bufConn{}
+	w := NewResponseWriter(conn)
+	require.NoError(t, w.WriteStatusLine(StatusBadRequest))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(4)))
+	_, err := w.WriteBody([]byte("oops"))
+	require.NoError(t, err)
+
+	res, err := ResponseFromReader(&conn.buf, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusBadRequest, res.StatusCode)
+	assert.Equal(t, "Bad Request", res.Reason)
+	// the length frames it, the connection can stay open
+	assert.NotContains(t, res.Headers, "connection")
+	assert.Equal(t, "oops", string(res.Body))
+
+	// Test: chunked response with trailers
+	conn = &bufConn{}
+	w = NewResponseWriter(conn)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Content-Length")
+	require.NoError(t, w.WriteHeaders(h))
+	w.WriteChunkedBody([]byte("first "))
+	w.WriteChunkedBody([]byte("second"))
+	w.WriteChunkedBodyDone()
+	h.Set("X-Content-Length", "12")
+	require.NoError(t, w.WriteTrailers(h))
+
+	res, err = ResponseFromReader(&conn.buf, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "first second", string(res.Body))
+	assert.Equal(t, "12", res.Trailers["x-content-length"])
+	_, found := res.Headers.Get("Content-Length")
+	assert.False(t, found)
+}
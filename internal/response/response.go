@@ -6,16 +6,71 @@ import (
 	"strings"
 	"net"
 
+	"github.com/WaronLimsakul/learn_http/internal/cookie"
 	"github.com/WaronLimsakul/learn_http/internal/headers"
 )
 
 type StatusCode int
 const (
+	StatusSwitchingProtocols StatusCode = 101
 	StatusOK StatusCode = 200
-	StatusBadRequest = 400
-	StatusServerError = 500
+	StatusMovedPermanently StatusCode = 301
+	StatusPermanentRedirect StatusCode = 308
+	StatusBadRequest StatusCode = 400
+	StatusForbidden StatusCode = 403
+	StatusNotFound StatusCode = 404
+	StatusPayloadTooLarge StatusCode = 413
+	StatusUnsupportedMediaType StatusCode = 415
+	StatusUpgradeRequired StatusCode = 426
+	StatusServerError StatusCode = 500
+	StatusBadGateway StatusCode = 502
+	StatusGatewayTimeout StatusCode = 504
 )
 
+// Proxies pass through whatever code upstream gives, so know more than we send.
+var reasonPhrases = map[StatusCode]string{
+	100: "Continue",
+	101: "Switching Protocols",
+	200: "OK",
+	201: "Created",
+	202: "Accepted",
+	204: "No Content",
+	206: "Partial Content",
+	301: "Moved Permanently",
+	302: "Found",
+	303: "See Other",
+	304: "Not Modified",
+	307: "Temporary Redirect",
+	308: "Permanent Redirect",
+	400: "Bad Request",
+	401: "Unauthorized",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	408: "Request Timeout",
+	409: "Conflict",
+	410: "Gone",
+	411: "Length Required",
+	412: "Precondition Failed",
+	413: "Content Too Large",
+	415: "Unsupported Media Type",
+	418: "I'm a teapot",
+	421: "Misdirected Request",
+	422: "Unprocessable Content",
+	426: "Upgrade Required",
+	429: "Too Many Requests",
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Gateway Timeout",
+}
+
+// Reason phrase for code, "" when we don't know it.
+func StatusText(code StatusCode) string {
+	return reasonPhrases[code]
+}
+
 type writerState int
 
 const (
@@ -28,7 +83,21 @@ const (
 
 type Writer struct {
 	conn net.Conn
+	// set instead of conn when the response doesn't go out as HTTP/1.1
+	stream Stream
+	// kept for stream, which sends it together with the headers, and to
+	// know if the response has a body at all
+	code StatusCode
 	state writerState
+	// from AddHeader, for middleware that wraps the real handler
+	extra headers.Headers
+	// from SetCookie, one Set-Cookie line each
+	cookies []string
+	// from OnWriteHeaders, run once just before the headers go out
+	beforeHeaders []func()
+	hijacked bool
+	// the client can't tell where the response ends, or was told we close
+	closeAfter bool
 }
 
 const crlf = "\r\n"
@@ -40,20 +109,81 @@ func NewResponseWriter(conn net.Conn) *Writer {
 	}
 }
 
+// Where a Writer sends the response when it isn't HTTP/1.1 bytes on a
+// connection, e.g. an HTTP/2 stream. It gets the parts instead of the wire
+// format. The response ends with endStream or WriteTrailers.
+type Stream interface {
+	// cookies are Set-Cookie values, they can't share a field
+	WriteHeaders(code StatusCode, h headers.Headers, cookies []string) error
+	WriteData(p []byte, endStream bool) (int, error)
+	WriteTrailers(h headers.Headers) error
+}
+
+// Handlers can't tell this Writer from one on a connection.
+func NewStreamWriter(stream Stream) *Writer {
+	return &Writer{
+		stream: stream,
+		state: initialized,
+	}
+}
+
+// A connection that has to let go of something before it is handed over,
+// e.g. the server's background read.
+type Hijacker interface {
+	Hijack() net.Conn
+}
+
+// Take the connection away from the server, e.g. after a 101 response.
+// The writer is done after this and the server won't close the connection,
+// that is the caller's job now.
+func (w *Writer) Hijack() (net.Conn, error) {
+	if w.hijacked {
+		return nil, fmt.Errorf("connection already hijacked")
+	}
+	if w.stream != nil {
+		return nil, fmt.Errorf("can't hijack a stream")
+	}
+	w.hijacked = true
+	w.state = done
+	if hj, ok := w.conn.(Hijacker); ok {
+		return hj.Hijack(), nil
+	}
+	return w.conn, nil
+}
+
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
+
+// Whether the connection can take another request after this response:
+// it was finished, and in a way the client knows where it ends.
+func (w *Writer) Reusable() bool {
+	if w.hijacked || w.closeAfter {
+		return false
+	}
+	if w.state == done {
+		return true
+	}
+	// headers are all there is to these
+	return w.state == writingBody && bodyless(w.code)
+}
+
+func bodyless(code StatusCode) bool {
+	return code < 200 || code == 204 || code == 304
+}
+
 // not sure if we need to write crlf
 func (w *Writer) WriteStatusLine(code StatusCode) error {
 	if w.state != initialized {
 		return fmt.Errorf("invalid writer state: %d", w.state)
 	}
-	statusLine := fmt.Sprintf("HTTP/1.1 %d", code)
-	switch code {
-		case 200:
-			statusLine += " OK"
-		case 400:
-			statusLine += " Bad Request"
-		case 500:
-			statusLine += " Internal Server Error"
+	w.code = code
+	if w.stream != nil {
+		w.state = writingHeaders
+		return nil
 	}
+	// the space stays even when we don't know a reason phrase
+	statusLine := fmt.Sprintf("HTTP/1.1 %d %s", code, StatusText(code))
 	statusLine += crlf
 	_, err := w.conn.Write([]byte(statusLine))
 	w.state = writingHeaders
@@ -63,40 +193,142 @@ func (w *Writer) WriteStatusLine(code StatusCode) error {
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	h := headers.NewHeaders()
 	h.Set("Content-Length", strconv.Itoa(contentLen))
-	h.Set("Connection", "close")
 	h.Set("Content-Type", "text/plain")
 	return h
 }
 
+// Have WriteHeaders also send key, unless the handler sets it itself.
+// Middleware use this to add to responses they don't write.
+func (w *Writer) AddHeader(key, val string) {
+	if w.extra == nil {
+		w.extra = headers.NewHeaders()
+	}
+	w.extra.Set(key, val)
+}
+
+// Have WriteHeaders send c on a Set-Cookie line of its own. Set-Cookie
+// values can't be joined with commas like other fields, Expires has one.
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if w.state != initialized && w.state != writingHeaders {
+		return fmt.Errorf("cookie after the headers went out")
+	}
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	w.cookies = append(w.cookies, c.String())
+	return nil
+}
+
+// Like SetCookie for a Set-Cookie value that is already written out, e.g.
+// one a proxy got from its upstream.
+func (w *Writer) SetRawCookie(value string) error {
+	if w.state != initialized && w.state != writingHeaders {
+		return fmt.Errorf("cookie after the headers went out")
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("line break in cookie: %q", value)
+	}
+	w.cookies = append(w.cookies, value)
+	return nil
+}
+
+// Run fn when the handler writes its headers, before they go out. For
+// middleware whose headers depend on what the handler did, e.g. a session
+// cookie. fn may call AddHeader and SetCookie.
+func (w *Writer) OnWriteHeaders(fn func()) {
+	w.beforeHeaders = append(w.beforeHeaders, fn)
+}
+
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	if w.state != writingHeaders {
 		return fmt.Errorf("invalid writer state: %d", w.state)
 	}
+	hooks := w.beforeHeaders
+	w.beforeHeaders = nil
+	for _, fn := range hooks {
+		fn()
+	}
+	if w.stream != nil {
+		w.state = writingBody
+		return w.stream.WriteHeaders(w.code, w.withExtra(headers), w.cookies)
+	}
+	w.closeAfter = w.closeAfter || mustClose(w.code, w.withExtra(headers))
 	resHeaders := ""
 	for key, val := range headers {
 		resHeaders += key + ":"
 		resHeaders += " " + val
 		resHeaders += crlf
 	}
+	for key, val := range w.extra {
+		if _, ok := headers.Get(key); !ok {
+			resHeaders += key + ": " + val + crlf
+		}
+	}
+	for _, c := range w.cookies {
+		resHeaders += "Set-Cookie: " + c + crlf
+	}
 	resHeaders += crlf
 	_, err := w.conn.Write([]byte(resHeaders))
 	w.state = writingBody
 	return err
 }
 
+// Without a length or chunks, only closing the connection ends the body.
+func mustClose(code StatusCode, h headers.Headers) bool {
+	if h.HasToken("Connection", "close") {
+		return true
+	}
+	if bodyless(code) {
+		return false
+	}
+	if _, ok := h.Get("Content-Length"); ok {
+		return false
+	}
+	return !h.HasToken("Transfer-Encoding", "chunked")
+}
+
+// h plus the extra headers it doesn't set itself, h stays untouched.
+func (w *Writer) withExtra(h headers.Headers) headers.Headers {
+	all := headers.NewHeaders()
+	for key, val := range w.extra {
+		all.Reset(key, val)
+	}
+	for key, val := range h {
+		all.Reset(key, val)
+	}
+	return all
+}
+
 func (w *Writer) WriteBody(p []byte) (n int, err error) {
 	if w.state != writingBody {
 		return 0, fmt.Errorf("invalid writer state: %d", w.state)
 	}
-	n, err = w.conn.Write(p)
 	w.state = done
-	return
+	if w.stream != nil {
+		return w.stream.WriteData(p, true)
+	}
+	return w.conn.Write(p)
+}
+
+// Write part of a fixed-length body without finishing the response.
+func (w *Writer) writeBodyPart(p []byte) (n int, err error) {
+	if w.state != writingBody {
+		return 0, fmt.Errorf("invalid writer state: %d", w.state)
+	}
+	if w.stream != nil {
+		return w.stream.WriteData(p, false)
+	}
+	return w.conn.Write(p)
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (n int, err error) {
 	if w.state != writingBody {
 		return 0, fmt.Errorf("invalid writer state: %d", w.state)
 	}
+	// streams frame the data themselves
+	if w.stream != nil {
+		return w.stream.WriteData(p, false)
+	}
 	chunk := []byte{}
 	firstLine := []byte(fmt.Sprintf("%X", len(p)) + crlf)
 	chunk = append(chunk, firstLine...)
@@ -110,21 +342,28 @@ func (w *Writer) WriteChunkedBodyDone() (n int, err error) {
 	if w.state != writingBody {
 		return 0, fmt.Errorf("invalid writer state: %d", w.state)
 	}
-	n, err = w.conn.Write([]byte("0\r\n"))
 	w.state = writingTrailers
-	return
+	if w.stream != nil {
+		return 0, nil
+	}
+	return w.conn.Write([]byte("0\r\n"))
 }
 
 func (w *Writer) WriteTrailers(h headers.Headers) error {
 	if w.state != writingTrailers {
 		return fmt.Errorf("cannot writing in state: %v", w.state)
 	}
+	if w.stream != nil {
+		w.state = done
+		return w.writeStreamTrailers(h)
+	}
 	end := crlf
 	trailerField, ok := h.Get("Trailer")
 	// no trailer, can end with crlf right away
 	if !ok {
-		w.conn.Write([]byte(end))
-		return nil
+		w.state = done
+		_, err := w.conn.Write([]byte(end))
+		return err
 	}
 
 	trailer := ""
@@ -137,5 +376,22 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 		trailer += key + ":" + val + crlf
 	}
 	_, err := w.conn.Write([]byte(trailer + crlf))
+	w.state = done
 	return err
 }
+
+// Same lookup as the chunked case, the stream frames the fields.
+func (w *Writer) writeStreamTrailers(h headers.Headers) error {
+	trailers := headers.NewHeaders()
+	trailerField, ok := h.Get("Trailer")
+	if ok {
+		for _, key := range strings.Split(trailerField, ", ") {
+			val, ok := h.Get(key)
+			if !ok {
+				return fmt.Errorf("couldn't find key: %s in headers", key)
+			}
+			trailers.Reset(key, val)
+		}
+	}
+	return w.stream.WriteTrailers(trailers)
+}
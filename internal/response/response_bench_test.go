@@ -0,0 +1,56 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func BenchmarkWriteBody(b *testing.B) {
+	for _, size := range []int{128, 16 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			body := bytes.Repeat([]byte("a"), size)
+			conn := &bufConn{}
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			for b.Loop() {
+				conn.buf.Reset()
+				w := NewResponseWriter(conn)
+				w.WriteStatusLine(StatusOK)
+				w.WriteHeaders(GetDefaultHeaders(size))
+				if _, err := w.WriteBody(body); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWriteChunkedBody(b *testing.B) {
+	const chunkSize = 1024
+	for _, size := range []int{128, 16 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			body := bytes.Repeat([]byte("a"), size)
+			conn := &bufConn{}
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			for b.Loop() {
+				conn.buf.Reset()
+				w := NewResponseWriter(conn)
+				w.WriteStatusLine(StatusOK)
+				h := GetDefaultHeaders(0)
+				h.Delete("Content-Length")
+				h.Set("Transfer-Encoding", "chunked")
+				w.WriteHeaders(h)
+				for start := 0; start < size; start += chunkSize {
+					end := min(start+chunkSize, size)
+					if _, err := w.WriteChunkedBody(body[start:end]); err != nil {
+						b.Fatal(err)
+					}
+				}
+				w.WriteChunkedBodyDone()
+				w.WriteTrailers(h)
+			}
+		})
+	}
+}
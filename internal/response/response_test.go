@@ -0,0 +1,45 @@
+package response
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/cookie"
+)
+
+func TestWriterSetCookie(t *testing.T) {
+	conn := &bufConn{}
+	w := NewResponseWriter(conn)
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "a", Value: "1", Expires: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)}))
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	assert.Error(t, w.SetCookie(&cookie.Cookie{Name: "c", Value: "x;y"}))
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(GetDefaultHeaders(0))
+	w.WriteBody(nil)
+	assert.Error(t, w.SetCookie(&cookie.Cookie{Name: "late"}))
+
+	// each on its own line, the comma in Expires can't split anything
+	out := conn.buf.String()
+	assert.Contains(t, out, "\r\nSet-Cookie: a=1; Expires=Wed, 02 Jan 2030 03:04:05 GMT\r\n")
+	assert.Contains(t, out, "\r\nSet-Cookie: b=2; HttpOnly\r\n")
+}
+
+func TestWriterSetRawCookie(t *testing.T) {
+	conn := &bufConn{}
+	w := NewResponseWriter(conn)
+	require.NoError(t, w.SetRawCookie("a=1; Expires=Wed, 02 Jan 2030 03:04:05 GMT"))
+	// Test: no sneaking in extra header lines
+	assert.Error(t, w.SetRawCookie("b=2\r\nX-Evil: 1"))
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(GetDefaultHeaders(0))
+	w.WriteBody(nil)
+	assert.Error(t, w.SetRawCookie("late=1"))
+
+	res, err := ResponseFromReader(&conn.buf, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a=1; Expires=Wed, 02 Jan 2030 03:04:05 GMT"}, res.Cookies)
+	assert.NotContains(t, res.Headers, "x-evil")
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A connection that keeps one read going in the background while the
+// handler runs, so we hear about the client hanging up even though we
+// aren't reading. A byte that arrives meanwhile is kept for the next Read.
+type watchedConn struct {
+	net.Conn
+
+	mu      sync.Mutex
+	pending []byte
+	// closed when the background read returns, nil when none is running
+	readDone chan struct{}
+	// lets go of the connection's requests before a handler takes it over
+	onHijack func()
+}
+
+// Serve pending bytes first, then the connection.
+func (c *watchedConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		c.mu.Unlock()
+		return n, nil
+	}
+	c.mu.Unlock()
+	return c.Conn.Read(p)
+}
+
+// Whether the connection starts with prefix. Reads no further than the
+// first byte that differs and puts it all back for the next Read.
+func (c *watchedConn) startsWith(prefix string) bool {
+	buf := make([]byte, 0, len(prefix))
+	for len(buf) < len(prefix) {
+		n, err := c.Read(buf[len(buf):len(prefix)])
+		buf = buf[:len(buf)+n]
+		if err != nil || !strings.HasPrefix(prefix, string(buf)) {
+			break
+		}
+	}
+	c.unread(buf)
+	return string(buf) == prefix
+}
+
+// Start watching, gone runs if the client closes or the connection breaks.
+func (c *watchedConn) startBackgroundRead(gone func()) {
+	done := make(chan struct{})
+	c.mu.Lock()
+	c.readDone = done
+	c.mu.Unlock()
+	go func() {
+		defer close(done)
+		var b [1]byte
+		n, err := c.Conn.Read(b[:])
+		if n > 0 {
+			// the client is still there, it just said more
+			c.mu.Lock()
+			c.pending = append(c.pending, b[0])
+			c.mu.Unlock()
+		}
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			gone()
+		}
+	}()
+}
+
+// Interrupt the background read and wait for it, so we can read ourselves.
+func (c *watchedConn) stopBackgroundRead() {
+	c.mu.Lock()
+	done := c.readDone
+	c.readDone = nil
+	c.mu.Unlock()
+	if done == nil {
+		return
+	}
+	// a deadline in the past wakes the read up
+	c.Conn.SetReadDeadline(time.Unix(1, 0))
+	<-done
+	c.Conn.SetReadDeadline(time.Time{})
+}
+
+// response.Writer calls this before handing the connection to a handler.
+func (c *watchedConn) Hijack() net.Conn {
+	c.stopBackgroundRead()
+	if c.onHijack != nil {
+		c.onHijack()
+	}
+	return c
+}
+
+// Have Read serve p before anything else, e.g. bytes read ahead that the
+// new owner of the connection should see.
+func (c *watchedConn) unread(p []byte) {
+	c.mu.Lock()
+	c.pending = append(p, c.pending...)
+	c.mu.Unlock()
+}
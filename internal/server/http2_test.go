@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/hpack"
+	"github.com/WaronLimsakul/learn_http/internal/http2"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+func protoHandler(w *response.Writer, req *request.Request) {
+	msg := req.RequestLine.HttpVersion + " " + string(req.Body)
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(msg)))
+	w.WriteBody([]byte(msg))
+}
+
+func TestServeHTTP2PriorKnowledge(t *testing.T) {
+	srv, err := Serve(0, protoHandler)
+	require.NoError(t, err)
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Addr().String())
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	c := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+
+	res, err := c.Post("http://127.0.0.1:"+port+"/", "text/plain", strings.NewReader("hi"))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, "HTTP/2.0", res.Proto)
+	assert.Equal(t, "2 hi", string(body))
+
+	// Test: uploads are checked against their digest here too
+	req, err := http.NewRequest("POST", "http://127.0.0.1:"+port+"/", strings.NewReader("hi"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Digest", "sha-256=:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=:")
+	res, err = c.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// Test: HTTP/1.1 on the same port is untouched
+	res, err = http.Get("http://127.0.0.1:" + port + "/")
+	require.NoError(t, err)
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, "1.1 ", string(body))
+}
+
+func TestServeHTTP2Upgrade(t *testing.T) {
+	srv, err := Serve(0, protoHandler)
+	require.NoError(t, err)
+	defer srv.Close()
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// empty HTTP2-Settings: the client is happy with the defaults
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 2\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: \r\n\r\nhi"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	statusLine, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", statusLine)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+
+	// from here on it's HTTP/2, the answer to the POST comes on stream 1
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+	framer := http2.NewFramer(conn, reader)
+	require.NoError(t, framer.WriteSettings())
+	decoder := hpack.NewDecoder(hpack.DefaultTableSize)
+	var status, body string
+	for body == "" {
+		f, err := framer.ReadFrame()
+		require.NoError(t, err)
+		switch f.Type {
+		case http2.FrameHeaders:
+			assert.Equal(t, uint32(1), f.StreamID)
+			fields, err := decoder.Decode(f.Payload)
+			require.NoError(t, err)
+			status = fields[0].Value
+		case http2.FrameData:
+			assert.Equal(t, uint32(1), f.StreamID)
+			body = string(f.Payload)
+		}
+	}
+	assert.Equal(t, "200", status)
+	assert.Equal(t, "1.1 hi", body)
+}
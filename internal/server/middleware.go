@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// Wrap a handler so gzip/deflate request bodies reach it already decoded.
+// Bodies that decode past maxSize get 413, unknown codings get 415.
+func DecompressRequests(maxSize int64, handler Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		err := req.DecompressBody(maxSize)
+		switch {
+		case err == nil:
+			handler(w, req)
+		case errors.Is(err, request.ErrUnsupportedEncoding):
+			// tell the client what we would have accepted (RFC 7694)
+			msg := err.Error()
+			w.WriteStatusLine(response.StatusUnsupportedMediaType)
+			h := response.GetDefaultHeaders(len(msg))
+			h.Set("Accept-Encoding", request.SupportedEncodings)
+			w.WriteHeaders(h)
+			w.WriteBody([]byte(msg))
+		case errors.Is(err, request.ErrBodyTooLarge):
+			writeError(w, &HandlerError{
+				StatusCode: response.StatusPayloadTooLarge,
+				Message:    err.Error(),
+			})
+		default:
+			writeError(w, &HandlerError{
+				StatusCode: response.StatusBadRequest,
+				Message:    err.Error(),
+			})
+		}
+	}
+}
+
+// One year, what the preload list asks for.
+const DefaultHSTSMaxAge = 365 * 24 * time.Hour
+
+// What Strict-Transport-Security tells browsers (RFC 6797 6.1).
+type HSTSPolicy struct {
+	// 0 tells browsers to forget the policy
+	MaxAge            time.Duration
+	IncludeSubDomains bool
+	// ask to be put on browsers' built-in lists. The list also wants
+	// IncludeSubDomains and at least DefaultHSTSMaxAge.
+	Preload bool
+}
+
+func (p HSTSPolicy) String() string {
+	field := "max-age=" + strconv.FormatInt(int64(p.MaxAge/time.Second), 10)
+	if p.IncludeSubDomains {
+		field += "; includeSubDomains"
+	}
+	if p.Preload {
+		field += "; preload"
+	}
+	return field
+}
+
+// Wrap a handler so every response carries the HSTS policy. Only for the
+// HTTPS side, browsers ignore it over plain HTTP anyway (RFC 6797 7.2).
+func HSTS(policy HSTSPolicy, handler Handler) Handler {
+	field := policy.String()
+	return func(w *response.Writer, req *request.Request) {
+		w.AddHeader("Strict-Transport-Security", field)
+		handler(w, req)
+	}
+}
+
+// Give each request through handler at most d, e.g. for one slow route.
+// The deadline is on the request's context, so the handler and the upstream
+// calls it makes with that context stop together. Answering (the proxy
+// sends 504) is still up to the handler.
+func Timeout(d time.Duration, handler Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), d)
+		defer cancel()
+		handler(w, req.WithContext(ctx))
+	}
+}
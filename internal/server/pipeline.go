@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+)
+
+// Let each HTTP/1.1 connection read and parse up to n requests ahead while
+// a handler runs, for clients that pipeline. Responses still go out one at
+// a time in request order. 0 (the default) reads the next request only
+// once the response before it is done. Only new connections see a change.
+func (s *Server) SetParseAhead(n int) {
+	s.parseAhead.Store(int32(max(n, 0)))
+}
+
+type parsed struct {
+	req *request.Request
+	err error
+	// the reader waits after this one until told to go on
+	paused bool
+}
+
+// Where the requests of one connection come from. With parse ahead a
+// goroutine keeps reading into ahead, otherwise next reads them itself.
+type pipeline struct {
+	conn   *watchedConn
+	reader *request.Reader
+	// cancels the connection's context, for when the client goes away
+	gone func()
+	// checked before every read, Close wakes reads up through a deadline
+	ctx context.Context
+
+	ahead  chan parsed
+	resume chan struct{}
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+func newPipeline(ctx context.Context, conn *watchedConn, n int, gone func()) *pipeline {
+	p := &pipeline{
+		conn:   conn,
+		reader: request.NewReader(conn),
+		gone:   gone,
+		ctx:    ctx,
+	}
+	if n > 0 {
+		p.ahead = make(chan parsed, n)
+		p.resume = make(chan struct{})
+		p.quit = make(chan struct{})
+		p.done = make(chan struct{})
+		go p.readAhead()
+	}
+	return p
+}
+
+func (p *pipeline) read() parsed {
+	if err := p.ctx.Err(); err != nil {
+		return parsed{err: err}
+	}
+	req, err := p.reader.ReadRequest()
+	if err != nil {
+		return parsed{err: err}
+	}
+	// whatever comes after these isn't a request we should read yet
+	_, upgrade := req.Headers.Get("Upgrade")
+	return parsed{
+		req:    req,
+		paused: upgrade || req.Headers.HasToken("Connection", "close") || req.RequestLine.Method == "CONNECT",
+	}
+}
+
+func (p *pipeline) readAhead() {
+	defer close(p.done)
+	for {
+		next := p.read()
+		if next.err != nil && isDisconnect(next.err) {
+			// the handler still running should stop too
+			p.gone()
+		}
+		select {
+		case p.ahead <- next:
+		case <-p.quit:
+			return
+		}
+		if next.err != nil {
+			return
+		}
+		if next.paused {
+			select {
+			case <-p.resume:
+			case <-p.quit:
+				return
+			}
+		}
+	}
+}
+
+// Deadlines are ours, to wake the reader up, not the client leaving.
+func isDisconnect(err error) bool {
+	var netErr net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return false
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
+
+// The next request, in the order they came.
+func (p *pipeline) next() parsed {
+	if p.ahead == nil {
+		return p.read()
+	}
+	return <-p.ahead
+}
+
+// Whether nobody is reading the connection while the handler for last
+// runs, so the server has to watch for the client going away itself.
+func (p *pipeline) idle(last parsed) bool {
+	return p.ahead == nil || last.paused
+}
+
+// Carry on after a paused request whose response left the connection open.
+func (p *pipeline) proceed(last parsed) {
+	if p.ahead != nil && last.paused {
+		p.resume <- struct{}{}
+	}
+}
+
+// Stop reading for good. Requests read ahead are dropped, bytes read but
+// not parsed are kept, see Buffered.
+func (p *pipeline) stop() {
+	if p.ahead == nil {
+		return
+	}
+	select {
+	case <-p.quit:
+		return
+	default:
+	}
+	close(p.quit)
+	// a deadline in the past wakes up a read in progress
+	p.conn.Conn.SetReadDeadline(time.Unix(1, 0))
+	<-p.done
+	p.conn.Conn.SetReadDeadline(time.Time{})
+}
+
+func (p *pipeline) release() {
+	p.stop()
+	p.reader.Release()
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// Answers with the target, the stock headers leave the connection open.
+func targetHandler(w *response.Writer, req *request.Request) {
+	// the first request takes longest, its answer must still come first
+	if req.RequestLine.RequestTarget == "/1" {
+		time.Sleep(50 * time.Millisecond)
+	}
+	msg := req.RequestLine.RequestTarget
+	h := response.GetDefaultHeaders(len(msg))
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(msg))
+}
+
+// One response off reader, and a reader for what comes after it.
+func nextResponse(t *testing.T, reader io.Reader) (*response.Response, io.Reader) {
+	t.Helper()
+	res, body, err := response.ReadResponseHead(reader, "GET")
+	require.NoError(t, err)
+	res.Body, err = io.ReadAll(body)
+	require.NoError(t, err)
+	return res, io.MultiReader(bytes.NewReader(body.Buffered()), reader)
+}
+
+func TestServePipelined(t *testing.T) {
+	for _, ahead := range []int{0, 4} {
+		srv, err := Serve(0, targetHandler)
+		require.NoError(t, err)
+		defer srv.Close()
+		srv.SetParseAhead(ahead)
+
+		conn, err := net.Dial("tcp", srv.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		// all in one write, the last one asks us to close afterwards
+		_, err = conn.Write([]byte("GET /1 HTTP/1.1\r\nHost: x\r\n\r\n" +
+			"POST /2 HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\nabc" +
+			"GET /3 HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"))
+		require.NoError(t, err)
+
+		var reader io.Reader = conn
+		for _, want := range []string{"/1", "/2", "/3"} {
+			var res *response.Response
+			res, reader = nextResponse(t, reader)
+			assert.Equal(t, want, string(res.Body), "parse ahead %d", ahead)
+		}
+		// nothing after the response to Connection: close
+		rest, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Empty(t, rest)
+	}
+}
+
+func TestServeKeepAlive(t *testing.T) {
+	// a handler like the ones in cmd/httpserver, nothing but the defaults
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		msg := []byte("hello")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(msg)))
+		w.WriteBody(msg)
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// one request at a time, each waits for the answer to the last
+	var reader io.Reader = conn
+	for range 3 {
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+		require.NoError(t, err)
+		var res *response.Response
+		res, reader = nextResponse(t, reader)
+		assert.Equal(t, "hello", string(res.Body))
+		assert.NotContains(t, res.Headers, "connection")
+	}
+}
+
+func TestServePipelinedHijack(t *testing.T) {
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		conn, err := w.Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+		io.Copy(conn, conn)
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+	srv.SetParseAhead(2)
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// what follows the request in the same packet is the new protocol's
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nhello"))
+	require.NoError(t, err)
+
+	res, reader := nextResponse(t, conn)
+	assert.Equal(t, response.StatusSwitchingProtocols, res.StatusCode)
+	got := make([]byte, 5)
+	_, err = io.ReadFull(reader, got)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+}
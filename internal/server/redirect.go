@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// The plain-HTTP side of an HTTPS site: everything gets a permanent
+// redirect to the same path and query over HTTPS.
+type HTTPSRedirect struct {
+	// where to send clients, "" means the host they asked for
+	Host string
+	// port the HTTPS server is on, 0 means 443
+	Port int
+	// serves /.well-known/ over plain HTTP, ACME http-01 challenges have
+	// to work before there is a certificate. nil means 404.
+	WellKnown Handler
+}
+
+// Listen on port and redirect everything to HTTPS.
+func ServeHTTPSRedirect(port int, redirect *HTTPSRedirect) (*Server, error) {
+	return Serve(port, redirect.Handle)
+}
+
+func (rd *HTTPSRedirect) Handle(w *response.Writer, req *request.Request) {
+	target := req.RequestLine.RequestTarget
+	// absolute-form, e.g. from a client that thinks we are a proxy
+	if u, err := url.Parse(target); err == nil && u.IsAbs() {
+		target = u.RequestURI()
+	}
+	if !strings.HasPrefix(target, "/") {
+		writeError(w, &HandlerError{
+			StatusCode: response.StatusBadRequest,
+			Message:    "can't redirect request target: " + target,
+		})
+		return
+	}
+
+	if strings.HasPrefix(target, "/.well-known/") {
+		if rd.WellKnown != nil {
+			rd.WellKnown(w, req)
+			return
+		}
+		writeError(w, &HandlerError{
+			StatusCode: response.StatusNotFound,
+			Message:    "not found",
+		})
+		return
+	}
+
+	host := rd.Host
+	if host == "" {
+		host, _ = req.Headers.Get("Host")
+		// the port they used was the plain one
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			writeError(w, &HandlerError{
+				StatusCode: response.StatusBadRequest,
+				Message:    "missing host",
+			})
+			return
+		}
+	}
+	if rd.Port != 0 && rd.Port != 443 {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(rd.Port))
+	} else if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		// SplitHostPort took the brackets off an IPv6 literal
+		host = "[" + host + "]"
+	}
+
+	// 301 lets clients turn a POST into a GET, 308 doesn't
+	code := response.StatusPermanentRedirect
+	if method := req.RequestLine.Method; method == "GET" || method == "HEAD" {
+		code = response.StatusMovedPermanently
+	}
+	location := "https://" + host + target
+	msg := "moved to " + location
+	w.WriteStatusLine(code)
+	h := response.GetDefaultHeaders(len(msg))
+	h.Set("Location", location)
+	w.WriteHeaders(h)
+	if req.RequestLine.Method == "HEAD" {
+		w.WriteBody(nil)
+		return
+	}
+	w.WriteBody([]byte(msg))
+}
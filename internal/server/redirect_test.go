@@ -0,0 +1,98 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	srv, err := ServeHTTPSRedirect(0, &HTTPSRedirect{
+		Port: 8443,
+		WellKnown: func(w *response.Writer, req *request.Request) {
+			msg := []byte("token")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeaders(len(msg)))
+			w.WriteBody(msg)
+		},
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: GET keeps path and query, swaps the port
+	res := exchange(t, srv, "GET /a/b?x=1&y=2 HTTP/1.1\r\nHost: example.test:8080\r\n\r\n")
+	assert.Equal(t, response.StatusMovedPermanently, res.StatusCode)
+	assert.Equal(t, "https://example.test:8443/a/b?x=1&y=2", res.Headers["location"])
+
+	// Test: POST gets 308 so the body isn't dropped
+	res = exchange(t, srv, "POST /form HTTP/1.1\r\nHost: example.test\r\nContent-Length: 2\r\n\r\nhi")
+	assert.Equal(t, response.StatusPermanentRedirect, res.StatusCode)
+	assert.Equal(t, "https://example.test:8443/form", res.Headers["location"])
+
+	// Test: absolute-form target, IPv6 host
+	res = exchange(t, srv, "GET http://[::1]:8080/p?q HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n")
+	assert.Equal(t, "https://[::1]:8443/p?q", res.Headers["location"])
+
+	// Test: ACME challenges stay on plain HTTP
+	res = exchange(t, srv, "GET /.well-known/acme-challenge/abc HTTP/1.1\r\nHost: example.test\r\n\r\n")
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.Equal(t, "token", string(res.Body))
+
+	// Test: nowhere to send them
+	res = exchange(t, srv, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, response.StatusBadRequest, res.StatusCode)
+
+	// Test: fixed host, default port, no well-known handler
+	srv2, err := ServeHTTPSRedirect(0, &HTTPSRedirect{Host: "secure.test"})
+	require.NoError(t, err)
+	defer srv2.Close()
+	res = exchange(t, srv2, "GET /x HTTP/1.1\r\nHost: other.test\r\n\r\n")
+	assert.Equal(t, "https://secure.test/x", res.Headers["location"])
+	res = exchange(t, srv2, "GET /.well-known/acme-challenge/abc HTTP/1.1\r\nHost: other.test\r\n\r\n")
+	assert.Equal(t, response.StatusNotFound, res.StatusCode)
+}
+
+func TestHTTPSRedirectIPv6DefaultPort(t *testing.T) {
+	srv, err := ServeHTTPSRedirect(0, &HTTPSRedirect{})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// the brackets stay even when there's no port to go with them
+	res := exchange(t, srv, "GET /x HTTP/1.1\r\nHost: [::1]:80\r\n\r\n")
+	assert.Equal(t, "https://[::1]/x", res.Headers["location"])
+	res = exchange(t, srv, "GET /x HTTP/1.1\r\nHost: [::1]\r\n\r\n")
+	assert.Equal(t, "https://[::1]/x", res.Headers["location"])
+}
+
+func TestHSTS(t *testing.T) {
+	assert.Equal(t, "max-age=0", HSTSPolicy{}.String())
+	assert.Equal(t, "max-age=31536000; includeSubDomains; preload", HSTSPolicy{
+		MaxAge:            DefaultHSTSMaxAge,
+		IncludeSubDomains: true,
+		Preload:           true,
+	}.String())
+
+	srv, err := Serve(0, HSTS(HSTSPolicy{MaxAge: time.Hour}, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		if req.RequestLine.RequestTarget == "/own" {
+			h.Set("Strict-Transport-Security", "max-age=5")
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteBody(nil)
+	}))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	res := exchange(t, srv, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "max-age=3600", res.Headers["strict-transport-security"])
+
+	// Test: the handler's own value wins
+	res = exchange(t, srv, "GET /own HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "max-age=5", res.Headers["strict-transport-security"])
+}
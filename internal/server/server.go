@@ -1,9 +1,17 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
 	"net"
+	"os"
 	"fmt"
 	"sync/atomic"
+	"time"
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+	"github.com/WaronLimsakul/learn_http/internal/http2"
 	"github.com/WaronLimsakul/learn_http/internal/response"
 	"github.com/WaronLimsakul/learn_http/internal/request"
 )
@@ -15,6 +23,15 @@ type Server struct {
 	listener net.Listener
 	handler Handler
 	isClosed atomic.Bool // use this type because it is thread-safe + sync
+	// closed by Close, for background work that should stop with us
+	done chan struct{}
+	// protocols picked through ALPN that aren't ours to serve
+	alpn map[string]func(conn *tls.Conn)
+	// parent of every request's context, cancelled by Close
+	baseCtx context.Context
+	cancel context.CancelFunc
+	// see SetParseAhead
+	parseAhead atomic.Int32
 }
 
 // We can write response inside handler.
@@ -35,24 +52,45 @@ func Serve(port int, handler Handler) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
+	return ServeListener(listener, handler), nil
+}
+
+// Serve on a listener someone else made, e.g. one that wraps every conn.
+func ServeListener(listener net.Listener, handler Handler) *Server {
+	server := newServer(listener, handler)
+	// send it to wait for request in the background
+	go server.listen()
+	return server
+}
 
-	server := Server{
+func newServer(listener net.Listener, handler Handler) *Server {
+	baseCtx, cancel := context.WithCancel(context.Background())
+	return &Server{
 		listener: listener,
 		handler: handler,
 		isClosed: atomic.Bool{}, // zero value is false
+		done: make(chan struct{}),
+		baseCtx: baseCtx,
+		cancel: cancel,
 	}
+}
 
-	// send it to wait for request in the background
-	go server.listen()
-	return &server, nil
+// Useful when we Serve on port 0 and let the OS pick one.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
 }
 
 func (s *Server) Close() error {
+	if s.isClosed.Swap(true) {
+		return nil
+	}
+	close(s.done)
+	// handlers still running find out through their request context
+	s.cancel()
 	err := s.listener.Close()
 	if err != nil {
 		return fmt.Errorf("error closing server: %w", err)
 	}
-	s.isClosed.Store(true)
 	return nil
 }
 
@@ -75,39 +113,144 @@ func (s *Server) listen() {
 	}
 }
 
-func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		resWriter := response.NewResponseWriter(conn)
-		msg := []byte("couldn't parse request")
-		resWriter.WriteStatusLine(400)
-		headers := response.GetDefaultHeaders(len(msg))
-		resWriter.WriteHeaders(headers)
-		resWriter.WriteBody(msg)
+func (s *Server) handle(netConn net.Conn) {
+	conn := &watchedConn{Conn: netConn}
+	hijacked := false
+	defer func() {
+		// a hijacked connection belongs to the handler now
+		if !hijacked {
+			conn.stopBackgroundRead()
+			conn.Close()
+		}
+	}()
+	tlsConn, isTLS := netConn.(*tls.Conn)
+	if isTLS {
+		if !s.handshake(tlsConn) {
+			return
+		}
+	}
+	// HTTP/2 with prior knowledge opens with the preface, not a request
+	if !isTLS && conn.startsWith(http2.ClientPreface) {
+		http2.ServeConn(s.baseCtx, conn, s.serveRequest, nil)
 		return
 	}
+	hijacked = s.serveHTTP1(conn, isTLS)
+}
+
+// Requests one after another until one of us wants to stop. Returns
+// whether a handler took the connection over.
+func (s *Server) serveHTTP1(conn *watchedConn, isTLS bool) (hijacked bool) {
+	// the client hanging up ends every request still to come, Close
+	// interrupts us waiting for the next one
+	connCtx, connCancel := context.WithCancel(s.baseCtx)
+	defer connCancel()
+	requests := newPipeline(connCtx, conn, int(s.parseAhead.Load()), connCancel)
+	stopWake := context.AfterFunc(s.baseCtx, func() {
+		conn.Conn.SetReadDeadline(time.Unix(1, 0))
+	})
+	conn.onHijack = func() {
+		stopWake()
+		requests.stop()
+		conn.unread(requests.reader.Buffered())
+	}
+	defer func() {
+		stopWake()
+		requests.release()
+	}()
+
+	for {
+		next := requests.next()
+		resWriter := response.NewResponseWriter(conn)
+		if next.err != nil {
+			// nothing came or nobody is left to tell
+			if errors.Is(next.err, io.EOF) || errors.Is(next.err, os.ErrDeadlineExceeded) || connCtx.Err() != nil {
+				return false
+			}
+			writeError(resWriter, &HandlerError{
+				StatusCode: response.StatusBadRequest,
+				Message: "couldn't parse request",
+			})
+			return false
+		}
+		req := next.req
+		req.RemoteAddr = conn.RemoteAddr().String()
+
+		// h2c only exists in cleartext, TLS picks HTTP/2 through ALPN
+		if !isTLS && http2.IsUpgrade(req) {
+			s.upgradeHTTP2(resWriter, req)
+			// closed by now if it got that far
+			return resWriter.Hijacked()
+		}
 
-	// bytes.Buffer is a []byte but will be treated like a buffer.
-	// It has Read, Write, ETC. So easy to work with.
-	// resBuff := bytes.Buffer{} // Buffer for handler to write as a reponse writer.
+		ctx, cancel := context.WithCancel(connCtx)
+		if requests.idle(next) {
+			conn.startBackgroundRead(connCancel)
+		}
+		s.serveRequest(resWriter, req.WithContext(ctx))
+		cancel()
+		if resWriter.Hijacked() {
+			return true
+		}
+		conn.stopBackgroundRead()
+		// responses go out in order because the next one only starts now
+		if !resWriter.Reusable() || req.Headers.HasToken("Connection", "close") {
+			return false
+		}
+		requests.proceed(next)
+	}
+}
 
-	resWriter := response.NewResponseWriter(conn)
+// What every request goes through, whichever protocol brought it.
+func (s *Server) serveRequest(w *response.Writer, req *request.Request) {
+	if err := req.CheckHost(); err != nil {
+		writeError(w, &HandlerError{
+			StatusCode: response.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+	// integrity check for every upload, before any handler sees the body
+	if err := req.VerifyDigest(); err != nil {
+		writeError(w, &HandlerError{
+			StatusCode: response.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+	s.handler(w, req)
+}
 
-	s.handler(resWriter, req)
+// Switch to HTTP/2 (RFC 7540 3.2). req is answered on stream 1.
+func (s *Server) upgradeHTTP2(w *response.Writer, req *request.Request) {
+	h := headers.NewHeaders()
+	h.Set("Connection", "Upgrade")
+	h.Set("Upgrade", "h2c")
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return
+	}
+	// hijacked so whatever the client sent after the request comes along
+	conn, err := w.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	http2.ServeConn(s.baseCtx, conn, s.serveRequest, req)
 }
 
 // intend to write it back to the connection directly
-// func writeError(conn io.Writer, hErr *HandlerError) error {
-// 	err := response.WriteStatusLine(conn, hErr.StatusCode)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	h := response.GetDefaultHeaders(len(hErr.Message))
-// 	err = response.WriteHeaders(conn, h)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	conn.Write([]byte(hErr.Message))
-// 	return nil
-// }
+func writeError(w *response.Writer, hErr *HandlerError) error {
+	err := w.WriteStatusLine(hErr.StatusCode)
+	if err != nil {
+		return err
+	}
+	h := response.GetDefaultHeaders(len(hErr.Message))
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+	_, err = w.WriteBody([]byte(hErr.Message))
+	return err
+}
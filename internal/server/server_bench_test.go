@@ -0,0 +1,42 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// Full round trip over loopback: dial, send, handler, read until close.
+func BenchmarkServeLoopback(b *testing.B) {
+	msg := []byte("hello benchmark")
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(msg)))
+		w.WriteBody(msg)
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer srv.Close()
+
+	addr := srv.Addr().String()
+	raw := []byte("GET / HTTP/1.1\r\nHost: localhost\r\nUser-Agent: bench\r\nConnection: close\r\n\r\n")
+	b.ReportAllocs()
+	for b.Loop() {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := conn.Write(raw); err != nil {
+			b.Fatal(err)
+		}
+		// we asked the server to close after one response
+		if _, err := io.ReadAll(conn); err != nil {
+			b.Fatal(err)
+		}
+		conn.Close()
+	}
+}
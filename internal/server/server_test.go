@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// Send raw bytes to srv and parse whatever comes back.
+func exchange(t *testing.T, srv *Server, raw string) *response.Response {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	res, err := response.ResponseFromReader(conn, "POST")
+	require.NoError(t, err)
+	return res
+}
+
+func TestServeVerifiesDigest(t *testing.T) {
+	var got []byte
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		got = req.Body
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		w.WriteBody(nil)
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	good := "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:"
+
+	// Test: chunked upload with a matching trailer digest
+	res := exchange(t, srv, "POST /upload HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"9\r\n{\"hello\":\r\n9\r\n \"world\"}\r\n0\r\nContent-Digest: "+good+"\r\n\r\n")
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.Equal(t, `{"hello": "world"}`, string(got))
+
+	// Test: mismatch never reaches the handler
+	got = nil
+	res = exchange(t, srv, "POST /upload HTTP/1.1\r\nHost: x\r\nContent-Digest: "+good+
+		"\r\nContent-Length: 5\r\n\r\nhello")
+	assert.Equal(t, response.StatusBadRequest, res.StatusCode)
+	assert.Contains(t, string(res.Body), "content-digest")
+	assert.Nil(t, got)
+}
+
+func TestDecompressRequests(t *testing.T) {
+	var got []byte
+	srv, err := Serve(0, DecompressRequests(1024, func(w *response.Writer, req *request.Request) {
+		got = req.Body
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		w.WriteBody(nil)
+	}))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	upload := func(encoding string, body []byte) *response.Response {
+		return exchange(t, srv, "POST /upload HTTP/1.1\r\nHost: x\r\nContent-Encoding: "+encoding+
+			"\r\nContent-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+string(body))
+	}
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+
+	// Test: the handler sees the decoded body
+	res := upload("gzip", gzipped([]byte("hello")))
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.Equal(t, "hello", string(got))
+
+	// Test: unknown coding gets 415 and what we do accept
+	got = nil
+	res = upload("br", []byte("whatever"))
+	assert.Equal(t, response.StatusUnsupportedMediaType, res.StatusCode)
+	assert.Equal(t, request.SupportedEncodings, res.Headers["accept-encoding"])
+	assert.Nil(t, got)
+
+	// Test: small on the wire, past maxSize once decoded
+	res = upload("gzip", gzipped(bytes.Repeat([]byte{0}, 1<<20)))
+	assert.Equal(t, response.StatusPayloadTooLarge, res.StatusCode)
+	assert.Nil(t, got)
+}
+
+func TestRequestContext(t *testing.T) {
+	ended := make(chan error, 1)
+	srv, err := Serve(0, Timeout(time.Second, func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/hijack":
+			// the client talks after the background read started
+			time.Sleep(100 * time.Millisecond)
+			conn, err := w.Hijack()
+			if err != nil {
+				ended <- err
+				return
+			}
+			defer conn.Close()
+			buf := make([]byte, 3)
+			_, err = io.ReadFull(conn, buf)
+			conn.Write(buf)
+			ended <- err
+		default:
+			<-req.Context().Done()
+			ended <- req.Context().Err()
+		}
+	}))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	waitEnded := func() error {
+		t.Helper()
+		select {
+		case err := <-ended:
+			return err
+		case <-time.After(3 * time.Second):
+			t.Fatal("handler never finished")
+			return nil
+		}
+	}
+
+	// Test: the client hanging up cancels the context
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	conn.Write([]byte("GET /wait HTTP/1.1\r\nHost: x\r\n\r\n"))
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	assert.ErrorIs(t, waitEnded(), context.Canceled)
+
+	// Test: the route deadline
+	conn, err = net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET /wait HTTP/1.1\r\nHost: x\r\n\r\n"))
+	assert.ErrorIs(t, waitEnded(), context.DeadlineExceeded)
+
+	// Test: a byte the background read picked up is still there after hijack
+	conn, err = net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET /hijack HTTP/1.1\r\nHost: x\r\n\r\n"))
+	time.Sleep(30 * time.Millisecond)
+	conn.Write([]byte("xyz"))
+	require.NoError(t, waitEnded())
+	got := make([]byte, 3)
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	assert.Equal(t, "xyz", string(got))
+
+	// Test: shutting down cancels what is still running
+	conn, err = net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET /wait HTTP/1.1\r\nHost: x\r\n\r\n"))
+	time.Sleep(50 * time.Millisecond)
+	srv.Close()
+	assert.ErrorIs(t, waitEnded(), context.Canceled)
+}
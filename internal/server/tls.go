@@ -0,0 +1,217 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often ServeTLS looks at the certificate files when
+// TLSConfig.ReloadInterval is 0.
+const DefaultReloadInterval = 30 * time.Second
+
+// Clients get this long to finish the handshake before we hang up.
+const handshakeTimeout = 10 * time.Second
+
+// A certificate and its private key, both PEM files on disk.
+type CertFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+type TLSConfig struct {
+	// picked by SNI server name (wildcards work), the first one is used
+	// when nothing matches or the client sent no name
+	Certificates []CertFiles
+	// 0 means TLS 1.2
+	MinVersion uint16
+	// only for TLS 1.2, Go doesn't let you pick 1.3 suites. nil means Go's defaults.
+	CipherSuites []uint16
+	// how often to check the files for changes, 0 means DefaultReloadInterval
+	ReloadInterval time.Duration
+	// Extra protocols to offer through ALPN and who takes a connection that
+	// picked one, e.g. "h2". The function runs on the connection's goroutine
+	// and the connection is closed when it returns.
+	// http/1.1 is always offered and served by the handler.
+	ALPN map[string]func(conn *tls.Conn)
+}
+
+// Like Serve, but every connection speaks TLS first. Certificates are
+// reloaded when their files change, no restart needed.
+func ServeTLS(port int, handler Handler, config *TLSConfig) (*Server, error) {
+	if len(config.Certificates) == 0 {
+		return nil, fmt.Errorf("tls needs at least one certificate")
+	}
+	store := &certStore{files: config.Certificates}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: store.getCertificate,
+		MinVersion:     config.MinVersion,
+		CipherSuites:   config.CipherSuites,
+	}
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+	for proto := range config.ALPN {
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, proto)
+	}
+	sort.Strings(tlsConfig.NextProtos)
+	// the server's order wins, ours goes last so clients that speak
+	// something better get it
+	tlsConfig.NextProtos = append(tlsConfig.NextProtos, "http/1.1")
+
+	listener, err := net.Listen("tcp", localHost(port))
+	if err != nil {
+		return nil, err
+	}
+	server := newServer(tls.NewListener(listener, tlsConfig), handler)
+	server.alpn = config.ALPN
+
+	interval := config.ReloadInterval
+	if interval == 0 {
+		interval = DefaultReloadInterval
+	}
+	go store.watch(interval, server.done)
+	go server.listen()
+	return server, nil
+}
+
+// Finish the handshake up front so we know the protocol, and hand the
+// connection over if ALPN picked something that isn't HTTP/1.1.
+// Return whether we should go on and serve it ourselves.
+func (s *Server) handshake(conn *tls.Conn) bool {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		return false
+	}
+	conn.SetDeadline(time.Time{})
+
+	proto := conn.ConnectionState().NegotiatedProtocol
+	if hook, ok := s.alpn[proto]; ok {
+		hook(conn)
+		return false
+	}
+	return true
+}
+
+// The loaded certificates, swapped out whole when the files change.
+type certStore struct {
+	files []CertFiles
+
+	mu       sync.RWMutex
+	certs    []*tls.Certificate
+	byName   map[string]*tls.Certificate // lowercased DNS names, "*.a.com" included
+	modTimes []time.Time                 // cert then key for each pair
+}
+
+func (cs *certStore) load() error {
+	// stat first, a write that lands while we load gets picked up next time
+	modTimes := cs.statFiles()
+	certs := []*tls.Certificate{}
+	byName := map[string]*tls.Certificate{}
+	for _, files := range cs.files {
+		cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", files.CertFile, err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", files.CertFile, err)
+		}
+		cert.Leaf = leaf
+		certs = append(certs, &cert)
+		names := leaf.DNSNames
+		if len(names) == 0 && leaf.Subject.CommonName != "" {
+			names = []string{leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			// the first file to claim a name keeps it
+			if _, taken := byName[name]; !taken {
+				byName[name] = &cert
+			}
+		}
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.certs = certs
+	cs.byName = byName
+	cs.modTimes = modTimes
+	return nil
+}
+
+func (cs *certStore) statFiles() []time.Time {
+	times := []time.Time{}
+	for _, files := range cs.files {
+		for _, path := range []string{files.CertFile, files.KeyFile} {
+			info, err := os.Stat(path)
+			if err != nil {
+				times = append(times, time.Time{})
+				continue
+			}
+			times = append(times, info.ModTime())
+		}
+	}
+	return times
+}
+
+func (cs *certStore) changed() bool {
+	current := cs.statFiles()
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	for i := range current {
+		if !current[i].Equal(cs.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Poll the files until done is closed. A bad reload (e.g. the cert was
+// written but the key not yet) keeps the old certificates and tries again.
+func (cs *certStore) watch(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if !cs.changed() {
+			continue
+		}
+		if err := cs.load(); err != nil {
+			log.Printf("tls: keeping old certificates: %v", err)
+			continue
+		}
+		log.Printf("tls: certificates reloaded")
+	}
+}
+
+// Exact name first, then a wildcard one label up, then the default.
+func (cs *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := cs.byName[name]; ok {
+		return cert, nil
+	}
+	if _, parent, found := strings.Cut(name, "."); found {
+		if cert, ok := cs.byName["*."+parent]; ok {
+			return cert, nil
+		}
+	}
+	return cs.certs[0], nil
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// Write a fresh self-signed cert for names into dir, return the file pair.
+func selfSigned(t *testing.T, dir, commonName string, names ...string) CertFiles {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	files := CertFiles{
+		CertFile: filepath.Join(dir, commonName+".crt"),
+		KeyFile:  filepath.Join(dir, commonName+".key"),
+	}
+	require.NoError(t, os.WriteFile(files.CertFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(files.KeyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return files
+}
+
+func helloHandler(w *response.Writer, req *request.Request) {
+	msg := []byte("hello over tls")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(msg)))
+	w.WriteBody(msg)
+}
+
+// Handshake as serverName and return the cert we were shown.
+func peerCert(t *testing.T, srv *Server, config *tls.Config) (*x509.Certificate, *tls.Conn) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", srv.Addr().String(), config)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn.ConnectionState().PeerCertificates[0], conn
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	fallback := selfSigned(t, dir, "default", "default.test")
+	api := selfSigned(t, dir, "api", "api.example.test")
+	wildcard := selfSigned(t, dir, "wildcard", "*.example.test")
+	srv, err := ServeTLS(0, helloHandler, &TLSConfig{
+		Certificates: []CertFiles{fallback, api, wildcard},
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: SNI picks exact names, then wildcards, then the first cert
+	for serverName, want := range map[string]string{
+		"api.example.test": "api",
+		"API.Example.Test": "api",
+		"www.example.test": "wildcard",
+		"a.b.example.test": "default",
+		"":                 "default",
+	} {
+		cert, _ := peerCert(t, srv, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		assert.Equal(t, want, cert.Subject.CommonName, serverName)
+	}
+
+	// Test: a full request, verified against the cert we wrote
+	pool := x509.NewCertPool()
+	pemBytes, err := os.ReadFile(api.CertFile)
+	require.NoError(t, err)
+	pool.AppendCertsFromPEM(pemBytes)
+	_, conn := peerCert(t, srv, &tls.Config{
+		ServerName: "api.example.test",
+		RootCAs:    pool,
+		NextProtos: []string{"h2", "http/1.1"},
+	})
+	assert.Equal(t, "http/1.1", conn.ConnectionState().NegotiatedProtocol)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: api.example.test\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := response.ResponseFromReader(conn, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello over tls", string(res.Body))
+
+	// Test: a bad file is refused up front
+	_, err = ServeTLS(0, helloHandler, &TLSConfig{
+		Certificates: []CertFiles{{CertFile: api.CertFile, KeyFile: fallback.KeyFile}},
+	})
+	assert.Error(t, err)
+}
+
+func TestServeTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	files := selfSigned(t, dir, "site", "site.test")
+	srv, err := ServeTLS(0, helloHandler, &TLSConfig{
+		Certificates:   []CertFiles{files},
+		ReloadInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	config := &tls.Config{ServerName: "site.test", InsecureSkipVerify: true}
+	before, _ := peerCert(t, srv, config)
+
+	// same names, new key and serial, written over the old files
+	renewed := selfSigned(t, dir, "site", "site.test")
+	require.Equal(t, files, renewed)
+	// some filesystems only keep whole seconds
+	later := time.Now().Add(2 * time.Second)
+	require.NoError(t, os.Chtimes(files.CertFile, later, later))
+	require.NoError(t, os.Chtimes(files.KeyFile, later, later))
+
+	assert.Eventually(t, func() bool {
+		after, _ := peerCert(t, srv, config)
+		return after.SerialNumber.Cmp(before.SerialNumber) != 0
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+func TestServeTLSVersionAndALPN(t *testing.T) {
+	dir := t.TempDir()
+	files := selfSigned(t, dir, "site", "site.test")
+	srv, err := ServeTLS(0, helloHandler, &TLSConfig{
+		Certificates: []CertFiles{files},
+		MinVersion:   tls.VersionTLS13,
+		ALPN: map[string]func(conn *tls.Conn){
+			"echo/1": func(conn *tls.Conn) {
+				conn.Write([]byte("echo speaking"))
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: a client stuck on TLS 1.2 can't get in
+	_, err = tls.Dial("tcp", srv.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+	})
+	assert.Error(t, err)
+
+	// Test: ALPN hands the connection to the hook
+	conn, err := tls.Dial("tcp", srv.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"echo/1", "http/1.1"},
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "echo/1", conn.ConnectionState().NegotiatedProtocol)
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "echo speaking", string(got))
+
+	// Test: plain TCP speaking HTTP gets nowhere
+	raw, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer raw.Close()
+	raw.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	raw.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got, _ = io.ReadAll(raw)
+	assert.NotContains(t, string(got), "hello over tls")
+}
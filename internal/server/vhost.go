@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// Virtual hosting: one server, a handler per host the requests are for.
+// Hosts match case-insensitively and without the port. "*.example.test"
+// matches every subdomain of example.test however deep, but not
+// example.test itself. An exact name beats a wildcard, a longer wildcard
+// beats a shorter one.
+type HostMux struct {
+	hosts     map[string]Handler
+	wildcards []wildcardHost // longest suffix first
+	// for hosts nothing matches, nil means 404
+	Default Handler
+}
+
+type wildcardHost struct {
+	suffix  string // ".example.test"
+	handler Handler
+}
+
+func NewHostMux() *HostMux {
+	return &HostMux{hosts: map[string]Handler{}}
+}
+
+// Route requests for host, or for its subdomains if it starts with "*.",
+// to handler. Adding a host again replaces its handler.
+func (m *HostMux) Add(host string, handler Handler) {
+	host = normalizeHost(host)
+	suffix, ok := strings.CutPrefix(host, "*")
+	if !ok {
+		m.hosts[host] = handler
+		return
+	}
+	for i, w := range m.wildcards {
+		if w.suffix == suffix {
+			m.wildcards[i].handler = handler
+			return
+		}
+	}
+	m.wildcards = append(m.wildcards, wildcardHost{suffix, handler})
+	sort.SliceStable(m.wildcards, func(i, j int) bool {
+		return len(m.wildcards[i].suffix) > len(m.wildcards[j].suffix)
+	})
+}
+
+func (m *HostMux) Handle(w *response.Writer, req *request.Request) {
+	if handler := m.match(req.Host()); handler != nil {
+		handler(w, req)
+		return
+	}
+	if m.Default != nil {
+		m.Default(w, req)
+		return
+	}
+	writeError(w, &HandlerError{
+		StatusCode: response.StatusNotFound,
+		Message:    "unknown host",
+	})
+}
+
+func (m *HostMux) match(host string) Handler {
+	host = normalizeHost(host)
+	if handler, ok := m.hosts[host]; ok {
+		return handler
+	}
+	for _, w := range m.wildcards {
+		if strings.HasSuffix(host, w.suffix) && len(host) > len(w.suffix) {
+			return w.handler
+		}
+	}
+	return nil
+}
+
+// Lowercase, no port, no trailing dot: "API.example.test.:8080" is
+// "api.example.test".
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
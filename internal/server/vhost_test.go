@@ -0,0 +1,83 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+func named(name string) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(name)))
+		w.WriteBody([]byte(name))
+	}
+}
+
+func TestHostMux(t *testing.T) {
+	mux := NewHostMux()
+	mux.Add("api.example.test", named("api"))
+	mux.Add("static.example.test", named("static"))
+	mux.Add("*.example.test", named("any"))
+	mux.Add("*.eu.example.test", named("eu"))
+	srv, err := Serve(0, mux.Handle)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	get := func(host string) *response.Response {
+		return exchange(t, srv, "GET / HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+	}
+	for host, want := range map[string]string{
+		"api.example.test":      "api",
+		"API.Example.test:8080": "api",
+		"static.example.test.":  "static",
+		"blog.example.test":     "any",
+		"a.b.example.test":      "any",
+		"shop.eu.example.test":  "eu",
+		"x.api.example.test":    "any",
+	} {
+		res := get(host)
+		assert.Equal(t, response.StatusOK, res.StatusCode, host)
+		assert.Equal(t, want, string(res.Body), host)
+	}
+
+	// Test: the wildcard is for subdomains only
+	assert.Equal(t, response.StatusNotFound, get("example.test").StatusCode)
+	assert.Equal(t, response.StatusNotFound, get("other.test").StatusCode)
+
+	// Test: absolute-form names the host too
+	res := exchange(t, srv, "GET http://static.example.test/ HTTP/1.1\r\nHost: api.example.test\r\n\r\n")
+	assert.Equal(t, "static", string(res.Body))
+
+}
+
+func TestHostMuxDefault(t *testing.T) {
+	mux := NewHostMux()
+	mux.Add("api.example.test", named("api"))
+	mux.Default = named("default")
+	srv, err := Serve(0, mux.Handle)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	res := exchange(t, srv, "GET / HTTP/1.1\r\nHost: other.test\r\n\r\n")
+	assert.Equal(t, "default", string(res.Body))
+}
+
+func TestServeRequiresHost(t *testing.T) {
+	srv, err := Serve(0, named("ok"))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	res := exchange(t, srv, "GET / HTTP/1.1\r\nHost: example.test\r\n\r\n")
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	res = exchange(t, srv, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, response.StatusBadRequest, res.StatusCode)
+	res = exchange(t, srv, "GET / HTTP/1.1\r\nHost: a.test\r\nHost: b.test\r\n\r\n")
+	assert.Equal(t, response.StatusBadRequest, res.StatusCode)
+	res = exchange(t, srv, "GET / HTTP/1.1\r\nHost: a.test/evil\r\n\r\n")
+	assert.Equal(t, response.StatusBadRequest, res.StatusCode)
+}
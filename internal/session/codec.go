@@ -0,0 +1,143 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidCookie = errors.New("session: cookie not ours or tampered with")
+
+// Browsers only promise 4096 bytes per cookie, name and attributes included.
+const maxCookieValue = 3800
+
+// Turns session data into a cookie value and back. name is the cookie's,
+// so a value can't be moved to another cookie.
+type Codec interface {
+	Encode(name string, data []byte) (string, error)
+	Decode(name, value string) ([]byte, error)
+}
+
+var encoding = base64.RawURLEncoding
+
+// Readable but tamper-proof: data plus an HMAC-SHA256 over it.
+type Signer struct {
+	keys [][]byte
+}
+
+// The first key signs, all of them verify. Put a new key first to rotate,
+// drop the old one once the cookies it signed have expired.
+func NewSigner(keys ...[]byte) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("session: signer needs a key")
+	}
+	for _, k := range keys {
+		if len(k) < 32 {
+			return nil, fmt.Errorf("session: signing keys need at least 32 bytes")
+		}
+	}
+	return &Signer{keys: keys}, nil
+}
+
+func (s *Signer) mac(key []byte, name, payload string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(name))
+	m.Write([]byte{'|'})
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
+
+func (s *Signer) Encode(name string, data []byte) (string, error) {
+	payload := encoding.EncodeToString(data)
+	value := payload + "." + encoding.EncodeToString(s.mac(s.keys[0], name, payload))
+	if len(value) > maxCookieValue {
+		return "", fmt.Errorf("session: %d bytes is too big for a cookie", len(value))
+	}
+	return value, nil
+}
+
+func (s *Signer) Decode(name, value string) ([]byte, error) {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, ErrInvalidCookie
+	}
+	got, err := encoding.DecodeString(sig)
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+	for _, key := range s.keys {
+		if hmac.Equal(got, s.mac(key, name, payload)) {
+			data, err := encoding.DecodeString(payload)
+			if err != nil {
+				return nil, ErrInvalidCookie
+			}
+			return data, nil
+		}
+	}
+	return nil, ErrInvalidCookie
+}
+
+// Secret and tamper-proof: data sealed with AES-GCM, the cookie name as
+// additional data.
+type Encrypter struct {
+	aeads []cipher.AEAD
+}
+
+// Keys are 16, 24 or 32 bytes (AES-128, -192, -256). The first one
+// encrypts, all of them decrypt, rotate like NewSigner.
+func NewEncrypter(keys ...[]byte) (*Encrypter, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("session: encrypter needs a key")
+	}
+	e := &Encrypter{}
+	for _, k := range keys {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+		e.aeads = append(e.aeads, aead)
+	}
+	return e, nil
+}
+
+func (e *Encrypter) Encode(name string, data []byte) (string, error) {
+	aead := e.aeads[0]
+	// random nonces are fine at 96 bits, as long as one key doesn't seal
+	// billions of cookies
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	value := encoding.EncodeToString(aead.Seal(nonce, nonce, data, []byte(name)))
+	if len(value) > maxCookieValue {
+		return "", fmt.Errorf("session: %d bytes is too big for a cookie", len(value))
+	}
+	return value, nil
+}
+
+func (e *Encrypter) Decode(name, value string) ([]byte, error) {
+	sealed, err := encoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+	for _, aead := range e.aeads {
+		if len(sealed) < aead.NonceSize() {
+			continue
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if data, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return data, nil
+		}
+	}
+	return nil, ErrInvalidCookie
+}
@@ -0,0 +1,304 @@
+// Sessions kept in a signed or encrypted cookie, or in a Store with only
+// the ID in the cookie.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/WaronLimsakul/learn_http/internal/cookie"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+	"github.com/WaronLimsakul/learn_http/internal/server"
+)
+
+const (
+	DefaultCookieName      = "session"
+	DefaultIdleTimeout     = 30 * time.Minute
+	DefaultAbsoluteTimeout = 24 * time.Hour
+)
+
+// Settings for the sessions of one site. Only Codec is required.
+type Manager struct {
+	// DefaultCookieName if empty
+	CookieName string
+	// signs (NewSigner) or encrypts (NewEncrypter) the cookie
+	Codec Codec
+	// nil keeps the whole session in the cookie
+	Store Store
+	// a session ends after this long without a request,
+	// DefaultIdleTimeout if 0
+	IdleTimeout time.Duration
+	// and after this long in any case, DefaultAbsoluteTimeout if 0
+	AbsoluteTimeout time.Duration
+
+	// cookie attributes. Path is "/" if empty and SameSite Lax if unset,
+	// the cookie is always HttpOnly.
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite cookie.SameSite
+
+	// for tests
+	now func() time.Time
+}
+
+// One visitor's session, from Get. Values are strings, encode anything
+// else yourself.
+type Session struct {
+	mu       sync.Mutex
+	id       string
+	values   map[string]string
+	created  time.Time
+	lastSeen time.Time
+
+	// nothing came in, we only send a cookie if the handler used it
+	isNew   bool
+	changed bool
+	// ID to forget once the new one is saved
+	oldID     string
+	destroyed bool
+}
+
+// What goes in the cookie or the store.
+type record struct {
+	ID       string            `json:"id"`
+	Values   map[string]string `json:"v,omitempty"`
+	Created  int64             `json:"c"`
+	LastSeen int64             `json:"l"`
+}
+
+type contextKey struct{}
+
+// The request's session, nil outside Manager.Wrap.
+func Get(req *request.Request) *Session {
+	s, _ := req.Context().Value(contextKey{}).(*Session)
+	return s
+}
+
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+func (s *Session) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, ok := s.values[key]
+	return val, ok
+}
+
+func (s *Session) Set(key, val string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = val
+	s.changed = true
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	s.changed = true
+}
+
+// A new ID for the same data, so an ID someone planted or saw before
+// doesn't carry over. Call it when the user logs in or gains rights.
+// The absolute timeout still counts from the start. With the session in
+// the cookie the old cookie is only as dead as its timeouts make it, it
+// takes a Store to forget an ID for good.
+func (s *Session) Regenerate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.id
+	}
+	s.id = newID()
+	s.changed = true
+}
+
+// End the session, e.g. on logout. The cookie is deleted with the
+// response, a later request starts a fresh one.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+	s.values = map[string]string{}
+}
+
+func newID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Give every request through handler a session, see Get. The cookie goes
+// out with the handler's headers.
+func (m *Manager) Wrap(handler server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		s, err := m.load(req)
+		if err != nil {
+			log.Printf("session: loading: %v", err)
+			msg := "session store unavailable"
+			w.WriteStatusLine(response.StatusServerError)
+			w.WriteHeaders(response.GetDefaultHeaders(len(msg)))
+			w.WriteBody([]byte(msg))
+			return
+		}
+		committed := false
+		w.OnWriteHeaders(func() {
+			committed = true
+			m.commit(w, s)
+		})
+		handler(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, s)))
+		if !committed {
+			// e.g. hijacked. A store still hears about the visit, a cookie
+			// can't go out anymore.
+			m.commit(nil, s)
+		}
+	}
+}
+
+func (m *Manager) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+func (m *Manager) cookieName() string {
+	if m.CookieName == "" {
+		return DefaultCookieName
+	}
+	return m.CookieName
+}
+
+func (m *Manager) idle() time.Duration {
+	if m.IdleTimeout == 0 {
+		return DefaultIdleTimeout
+	}
+	return m.IdleTimeout
+}
+
+func (m *Manager) absolute() time.Duration {
+	if m.AbsoluteTimeout == 0 {
+		return DefaultAbsoluteTimeout
+	}
+	return m.AbsoluteTimeout
+}
+
+// The session the request's cookie points to, a new one if it points
+// nowhere, has been tampered with or has expired.
+func (m *Manager) load(req *request.Request) (*Session, error) {
+	now := m.clock()
+	fresh := &Session{id: newID(), values: map[string]string{}, created: now, lastSeen: now, isNew: true}
+	c, ok := req.Cookie(m.cookieName())
+	if !ok {
+		return fresh, nil
+	}
+	data, err := m.Codec.Decode(m.cookieName(), c.Value)
+	if err != nil {
+		return fresh, nil
+	}
+	if m.Store != nil {
+		id := string(data)
+		data, ok, err = m.Store.Load(id)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return fresh, nil
+		}
+	}
+	var r record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return fresh, nil
+	}
+	created, lastSeen := time.Unix(r.Created, 0), time.Unix(r.LastSeen, 0)
+	if now.Sub(lastSeen) >= m.idle() || now.Sub(created) >= m.absolute() {
+		if m.Store != nil {
+			m.Store.Delete(r.ID)
+		}
+		return fresh, nil
+	}
+	if r.Values == nil {
+		r.Values = map[string]string{}
+	}
+	return &Session{id: r.ID, values: r.Values, created: created, lastSeen: now}, nil
+}
+
+// Save s and, if w isn't nil, send the cookie for it.
+func (m *Manager) commit(w *response.Writer, s *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isNew && (!s.changed || s.destroyed) {
+		// never used, no need to hand out a cookie
+		return
+	}
+	if m.Store != nil && s.oldID != "" {
+		m.Store.Delete(s.oldID)
+	}
+	if s.destroyed {
+		if m.Store != nil {
+			m.Store.Delete(s.id)
+		}
+		if w != nil {
+			w.SetCookie(m.cookie("", -1))
+		}
+		return
+	}
+
+	expires := s.lastSeen.Add(m.idle())
+	if end := s.created.Add(m.absolute()); end.Before(expires) {
+		expires = end
+	}
+	data, err := json.Marshal(record{ID: s.id, Values: s.values, Created: s.created.Unix(), LastSeen: s.lastSeen.Unix()})
+	if err != nil {
+		log.Printf("session: %v", err)
+		return
+	}
+	if m.Store != nil {
+		if err := m.Store.Save(s.id, data, expires); err != nil {
+			log.Printf("session: saving: %v", err)
+			return
+		}
+		// the cookie only has to point at it
+		data = []byte(s.id)
+	}
+	if w == nil {
+		return
+	}
+	value, err := m.Codec.Encode(m.cookieName(), data)
+	if err != nil {
+		log.Printf("session: %v", err)
+		return
+	}
+	maxAge := int(expires.Sub(m.clock()) / time.Second)
+	w.SetCookie(m.cookie(value, max(maxAge, 1)))
+}
+
+func (m *Manager) cookie(value string, maxAge int) *cookie.Cookie {
+	c := &cookie.Cookie{
+		Name:     m.cookieName(),
+		Value:    value,
+		Path:     m.Path,
+		Domain:   m.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: m.SameSite,
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.SameSite == cookie.SameSiteDefault {
+		c.SameSite = cookie.SameSiteLax
+	}
+	return c
+}
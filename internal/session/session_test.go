@@ -0,0 +1,272 @@
+package session
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+	"github.com/WaronLimsakul/learn_http/internal/server"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestCodecs(t *testing.T) {
+	signer, err := NewSigner(key1)
+	require.NoError(t, err)
+	encrypter, err := NewEncrypter(key1)
+	require.NoError(t, err)
+	for _, codec := range []Codec{signer, encrypter} {
+		value, err := codec.Encode("session", []byte("user=ann"))
+		require.NoError(t, err)
+		data, err := codec.Decode("session", value)
+		require.NoError(t, err)
+		assert.Equal(t, "user=ann", string(data))
+
+		// Test: not valid for another cookie
+		_, err = codec.Decode("other", value)
+		assert.ErrorIs(t, err, ErrInvalidCookie)
+		// Test: tampered with
+		tampered := []byte(value)
+		tampered[len(tampered)/2] ^= 1
+		_, err = codec.Decode("session", string(tampered))
+		assert.ErrorIs(t, err, ErrInvalidCookie)
+
+		// Test: too big for a cookie
+		_, err = codec.Encode("session", make([]byte, 4096))
+		assert.Error(t, err)
+	}
+
+	// Test: the signed payload can be read, the encrypted one can't
+	value, _ := signer.Encode("session", []byte("user=ann"))
+	assert.Contains(t, value, encoding.EncodeToString([]byte("user=ann")))
+	value, _ = encrypter.Encode("session", []byte("user=ann"))
+	assert.NotContains(t, value, encoding.EncodeToString([]byte("user=ann")))
+
+	_, err = NewSigner([]byte("short"))
+	assert.Error(t, err)
+	_, err = NewEncrypter([]byte("not 16, 24 or 32"[:10]))
+	assert.Error(t, err)
+}
+
+func TestKeyRotation(t *testing.T) {
+	old, _ := NewEncrypter(key1)
+	value, err := old.Encode("session", []byte("x"))
+	require.NoError(t, err)
+
+	// the new key comes first, the old one still opens what it sealed
+	rotated, _ := NewEncrypter(key2, key1)
+	data, err := rotated.Decode("session", value)
+	require.NoError(t, err)
+	assert.Equal(t, "x", string(data))
+	newValue, _ := rotated.Encode("session", []byte("x"))
+	_, err = old.Decode("session", newValue)
+	assert.Error(t, err)
+
+	// once the old key is gone, so are its cookies
+	retired, _ := NewEncrypter(key2)
+	_, err = retired.Decode("session", value)
+	assert.Error(t, err)
+
+	signer, _ := NewSigner(key1)
+	value, _ = signer.Encode("session", []byte("x"))
+	rotatedSigner, _ := NewSigner(key2, key1)
+	_, err = rotatedSigner.Decode("session", value)
+	assert.NoError(t, err)
+}
+
+// net.Conn that only records what gets written to it
+type bufConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *bufConn) Write(p []byte) (int, error) {
+	return c.buf.Write(p)
+}
+
+// One request through handler with the session cookie set to value ("" for
+// none). Returns the response and the new cookie value, if one was sent.
+func do(t *testing.T, handler server.Handler, value string) (*response.Response, string) {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\nHost: x\r\n"
+	if value != "" {
+		raw += "Cookie: other=1; session=" + value + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	conn := &bufConn{}
+	handler(response.NewResponseWriter(conn), req)
+	res, err := response.ResponseFromReader(&conn.buf, "GET")
+	require.NoError(t, err)
+	if len(res.Cookies) == 0 {
+		return res, ""
+	}
+	require.Len(t, res.Cookies, 1)
+	setCookie := res.Cookies[0]
+	assert.Contains(t, setCookie, "; HttpOnly")
+	v, _, _ := strings.Cut(strings.TrimPrefix(setCookie, "session="), ";")
+	return res, v
+}
+
+func text(w *response.Writer, msg string) {
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(msg)))
+	w.WriteBody([]byte(msg))
+}
+
+// /login sets the user, anything else says who it is
+func app(w *response.Writer, req *request.Request) {
+	s := Get(req)
+	switch {
+	case strings.HasPrefix(string(req.Body), "login"):
+		s.Regenerate()
+		s.Set("user", "ann")
+		text(w, "welcome")
+	case strings.HasPrefix(string(req.Body), "logout"):
+		s.Destroy()
+		text(w, "bye")
+	default:
+		user, _ := s.Get("user")
+		text(w, user)
+	}
+}
+
+func TestManager(t *testing.T) {
+	signer, _ := NewSigner(key1)
+	encrypter, _ := NewEncrypter(key1)
+	for _, m := range []*Manager{
+		{Codec: signer},
+		{Codec: encrypter},
+		{Codec: signer, Store: NewMemoryStore()},
+	} {
+		var body string
+		handler := m.Wrap(func(w *response.Writer, req *request.Request) {
+			req.Body = []byte(body)
+			app(w, req)
+		})
+
+		// Test: an unused session costs no cookie
+		res, value := do(t, handler, "")
+		assert.Equal(t, "", string(res.Body))
+		assert.Empty(t, value)
+
+		body = "login"
+		_, value = do(t, handler, "")
+		require.NotEmpty(t, value)
+		body = ""
+		res, next := do(t, handler, value)
+		assert.Equal(t, "ann", string(res.Body))
+		assert.NotEmpty(t, next)
+
+		// Test: a forged cookie is a fresh session
+		res, _ = do(t, handler, value+"x")
+		assert.Equal(t, "", string(res.Body))
+
+		// Test: logging out deletes the cookie
+		body = "logout"
+		res, _ = do(t, handler, next)
+		require.Len(t, res.Cookies, 1)
+		setCookie := res.Cookies[0]
+		assert.Contains(t, setCookie, "Max-Age=0")
+		if m.Store != nil {
+			// and the stored session, the old cookie is worthless now
+			body = ""
+			res, _ = do(t, handler, next)
+			assert.Equal(t, "", string(res.Body))
+		}
+	}
+}
+
+func TestManagerRegenerate(t *testing.T) {
+	signer, _ := NewSigner(key1)
+	store := NewMemoryStore()
+	m := &Manager{Codec: signer, Store: store}
+	var ids []string
+	login := false
+	handler := m.Wrap(func(w *response.Writer, req *request.Request) {
+		s := Get(req)
+		if login {
+			s.Regenerate()
+		}
+		s.Set("n", "1")
+		ids = append(ids, s.ID())
+		text(w, "")
+	})
+
+	_, value := do(t, handler, "")
+	login = true
+	_, newValue := do(t, handler, value)
+	// same session, new ID, and the old one is gone
+	require.Len(t, ids, 2)
+	assert.NotEqual(t, ids[0], ids[1])
+	assert.NotEqual(t, value, newValue)
+	_, found, _ := store.Load(ids[0])
+	assert.False(t, found)
+	_, found, _ = store.Load(ids[1])
+	assert.True(t, found)
+	assert.Equal(t, 1, store.Len())
+}
+
+func TestManagerExpiry(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	encrypter, _ := NewEncrypter(key1)
+	store := NewMemoryStore()
+	store.now = clock
+	for _, m := range []*Manager{
+		{Codec: encrypter, IdleTimeout: time.Minute, AbsoluteTimeout: time.Hour, now: clock},
+		{Codec: encrypter, Store: store, IdleTimeout: time.Minute, AbsoluteTimeout: time.Hour, now: clock},
+	} {
+		now = time.Now()
+		handler := m.Wrap(func(w *response.Writer, req *request.Request) {
+			s := Get(req)
+			if _, ok := s.Get("user"); !ok {
+				s.Set("user", "ann")
+				text(w, "new")
+				return
+			}
+			text(w, "known")
+		})
+		_, value := do(t, handler, "")
+
+		// Test: active sessions slide along, up to the absolute timeout
+		for range 5 {
+			now = now.Add(50 * time.Second)
+			var res *response.Response
+			res, value = do(t, handler, value)
+			assert.Equal(t, "known", string(res.Body))
+		}
+		res, _ := do(t, handler, value)
+		require.Len(t, res.Cookies, 1)
+		setCookie := res.Cookies[0]
+		assert.Contains(t, setCookie, "Max-Age=60")
+
+		// Test: idle too long
+		now = now.Add(2 * time.Minute)
+		res, _ = do(t, handler, value)
+		assert.Equal(t, "new", string(res.Body))
+
+		// Test: active, but for too long
+		now = time.Now()
+		_, value = do(t, handler, "")
+		for range 65 {
+			now = now.Add(59 * time.Second)
+			res, value = do(t, handler, value)
+			if string(res.Body) == "new" {
+				break
+			}
+		}
+		assert.Equal(t, "new", string(res.Body))
+		assert.True(t, now.Sub(time.Now()) >= time.Hour-time.Minute)
+	}
+}
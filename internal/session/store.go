@@ -0,0 +1,89 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// Keeps sessions on the server, the cookie only carries the ID. For data
+// too big or too secret for a cookie, or sessions that have to end
+// everywhere at once.
+type Store interface {
+	// found is false for IDs it doesn't know or that have expired
+	Load(id string) (data []byte, found bool, err error)
+	// expires is when the session is over unless it's saved again
+	Save(id string, data []byte, expires time.Time) error
+	Delete(id string) error
+}
+
+// How often MemoryStore looks for expired sessions nobody came back for.
+const sweepInterval = time.Minute
+
+// A Store in a map, gone with the process. Fine for one server.
+type MemoryStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memorySession
+	lastSweep time.Time
+	// for tests
+	now func() time.Time
+}
+
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]memorySession{}, now: time.Now}
+}
+
+func (m *MemoryStore) Load(id string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, false, nil
+	}
+	if !m.now().Before(s.expires) {
+		delete(m.sessions, id)
+		return nil, false, nil
+	}
+	return s.data, true, nil
+}
+
+func (m *MemoryStore) Save(id string, data []byte, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		for id, s := range m.sessions {
+			if !now.Before(s.expires) {
+				delete(m.sessions, id)
+			}
+		}
+		m.lastSweep = now
+	}
+	m.sessions[id] = memorySession{data: data, expires: expires}
+	return nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// Sessions not expired yet.
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	now := m.now()
+	for _, s := range m.sessions {
+		if now.Before(s.expires) {
+			n++
+		}
+	}
+	return n
+}
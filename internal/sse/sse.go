@@ -0,0 +1,205 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// Proxies tend to drop connections that stay quiet longer than this.
+const DefaultHeartbeat = 15 * time.Second
+
+var ErrStreamClosed = errors.New("sse: stream closed")
+
+// One message for the browser's EventSource (HTML Living Standard 9.2).
+type Event struct {
+	// "" means the default "message" type
+	Event string
+	// may span lines, each line becomes its own data: field
+	Data string
+	// remembered by the client and sent back as Last-Event-ID on reconnect
+	ID string
+	// how long the client waits before reconnecting, 0 leaves it alone
+	Retry time.Duration
+}
+
+// Wire format of e, blank line included.
+func (e Event) Format() (string, error) {
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return "", fmt.Errorf("sse: newline in event type")
+	}
+	// a NUL makes clients ignore the id
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return "", fmt.Errorf("sse: newline or NUL in event id")
+	}
+	var b strings.Builder
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	// clients split on \r\n, \r and \n alike
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return b.String(), nil
+}
+
+// An open text/event-stream response. Every event goes out in its own
+// chunk right away, nothing sits in a buffer.
+//
+// Done fires when the request's context ends (the server notices the
+// client leaving) or a write fails, whichever comes first. Heartbeats
+// make sure a dead connection is found even without the server's help.
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	err    error
+}
+
+// Write the response head and start sending heartbeat comments every
+// heartbeat (0 means DefaultHeartbeat, negative means never).
+func NewStream(w *response.Writer, req *request.Request, heartbeat time.Duration) (*Stream, error) {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Connection", "close")
+	// stop nginx and friends from buffering the stream
+	h.Set("X-Accel-Buffering", "no")
+	if err := w.WriteStatusLine(response.StatusOK); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+
+	s := &Stream{w: w, done: make(chan struct{})}
+	s.lastEventID, _ = req.Headers.Get("Last-Event-ID")
+	if heartbeat == 0 {
+		heartbeat = DefaultHeartbeat
+	}
+	if heartbeat > 0 {
+		go s.heartbeat(heartbeat)
+	}
+	go s.watch(req.Context())
+	return s, nil
+}
+
+// The id of the last event the client saw before reconnecting, "" on a
+// first connect. Resume after it.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Closed once the stream can't be written to anymore, e.g. the client
+// went away or Close was called.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Why the stream ended, nil while it is still open or after Close.
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Stream) Send(e Event) error {
+	text, err := e.Format()
+	if err != nil {
+		return err
+	}
+	return s.write(text)
+}
+
+// A comment line, ignored by clients. Handy to keep the connection busy.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r", ""), "\n") {
+		b.WriteString(": " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+func (s *Stream) write(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+	if _, err := s.w.WriteChunkedBody([]byte(text)); err != nil {
+		s.stop(err)
+		return err
+	}
+	return nil
+}
+
+// Caller holds mu.
+func (s *Stream) stop(err error) {
+	s.closed = true
+	s.err = err
+	close(s.done)
+}
+
+func (s *Stream) watch(ctx context.Context) {
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		s.mu.Lock()
+		if !s.closed {
+			s.stop(ctx.Err())
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Stream) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if s.Comment("heartbeat") != nil {
+				return
+			}
+		}
+	}
+}
+
+// End the stream properly. The client will reconnect after its retry
+// delay unless it is told otherwise (e.g. with a 204 next time).
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.stop(nil)
+	if _, err := s.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return s.w.WriteTrailers(headers.NewHeaders())
+}
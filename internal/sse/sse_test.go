@@ -0,0 +1,131 @@
+package sse
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+	"github.com/WaronLimsakul/learn_http/internal/server"
+)
+
+func TestEventFormat(t *testing.T) {
+	text, err := Event{Data: "hello"}.Format()
+	require.NoError(t, err)
+	assert.Equal(t, "data: hello\n\n", text)
+
+	// Test: every field, data over several lines with mixed line endings
+	text, err = Event{
+		Event: "update",
+		ID:    "42",
+		Retry: 3 * time.Second,
+		Data:  "line one\nline two\r\nline three\rend",
+	}.Format()
+	require.NoError(t, err)
+	assert.Equal(t, "event: update\nid: 42\nretry: 3000\n"+
+		"data: line one\ndata: line two\ndata: line three\ndata: end\n\n", text)
+
+	// Test: empty data still makes an event, trailing newline keeps its line
+	text, _ = Event{Data: "a\n"}.Format()
+	assert.Equal(t, "data: a\ndata: \n\n", text)
+
+	// Test: fields that would break the framing
+	_, err = Event{Event: "a\nb"}.Format()
+	assert.Error(t, err)
+	_, err = Event{ID: "1\x002"}.Format()
+	assert.Error(t, err)
+}
+
+// Connect to srv, send headers, return a reader over the de-chunked body.
+func subscribe(t *testing.T, srv *server.Server, extra string) (*response.Response, *bufio.Reader, net.Conn) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.Write([]byte("GET /events HTTP/1.1\r\nHost: x\r\n" + extra + "\r\n"))
+	require.NoError(t, err)
+	head, body, err := response.ReadResponseHead(conn, "GET")
+	require.NoError(t, err)
+	return head, bufio.NewReader(body), conn
+}
+
+// Everything up to the next blank line.
+func nextBlock(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var b strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\n" {
+			return b.String()
+		}
+		b.WriteString(line)
+	}
+}
+
+func TestStream(t *testing.T) {
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, 20*time.Millisecond)
+		if err != nil {
+			return
+		}
+		start := 0
+		if s.LastEventID() == "2" {
+			start = 3
+		}
+		for i := start; i < 4; i++ {
+			s.Send(Event{Event: "tick", ID: string(rune('0' + i)), Data: "n\nn"})
+		}
+		// long enough for a heartbeat
+		time.Sleep(50 * time.Millisecond)
+		s.Close()
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	head, r, _ := subscribe(t, srv, "")
+	assert.Equal(t, "text/event-stream", head.Headers["content-type"])
+	assert.Equal(t, "no-cache", head.Headers["cache-control"])
+	assert.Equal(t, "event: tick\nid: 0\ndata: n\ndata: n\n", nextBlock(t, r))
+	for range 3 {
+		nextBlock(t, r)
+	}
+	assert.Equal(t, ": heartbeat\n", nextBlock(t, r))
+
+	// Test: reconnect resumes after the last id
+	_, r, _ = subscribe(t, srv, "Last-Event-ID: 2\r\n")
+	assert.Equal(t, "event: tick\nid: 3\ndata: n\ndata: n\n", nextBlock(t, r))
+}
+
+func TestStreamStopsOnDisconnect(t *testing.T) {
+	stopped := make(chan error, 1)
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, 10*time.Millisecond)
+		if err != nil {
+			return
+		}
+		s.Send(Event{Data: "first"})
+		<-s.Done()
+		stopped <- s.Err()
+		assert.ErrorIs(t, s.Send(Event{Data: "late"}), ErrStreamClosed)
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	_, r, conn := subscribe(t, srv, "")
+	assert.Equal(t, "data: first\n", nextBlock(t, r))
+	conn.Close()
+
+	select {
+	case err := <-stopped:
+		assert.Error(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("stream never noticed the client left")
+	}
+}
@@ -0,0 +1,178 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/WaronLimsakul/learn_http/internal/client"
+	"github.com/WaronLimsakul/learn_http/internal/headers"
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+)
+
+// Appended to the client's key before hashing (RFC 6455 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Options for the server side of the handshake.
+type Upgrader struct {
+	// offered in our order of preference, the first one the client also
+	// asked for is picked
+	Subprotocols []string
+	// nil accepts every origin. Browsers always send Origin, so this is
+	// where cross-site pages get turned away.
+	CheckOrigin func(req *request.Request) bool
+	// copied to the Conn
+	MaxMessageSize int64
+}
+
+// Answer the handshake in req with 101 and take over the connection.
+// On a bad handshake the error response is already written.
+func (u *Upgrader) Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	if req.RequestLine.Method != "GET" {
+		return nil, refuse(w, response.StatusBadRequest, "websocket handshake must be GET")
+	}
+	if !req.Headers.HasToken("Connection", "upgrade") || !req.Headers.HasToken("Upgrade", "websocket") {
+		return nil, refuse(w, response.StatusUpgradeRequired, "not a websocket handshake")
+	}
+	if version, _ := req.Headers.Get("Sec-WebSocket-Version"); version != "13" {
+		return nil, refuse(w, response.StatusUpgradeRequired, "unsupported websocket version")
+	}
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, refuse(w, response.StatusBadRequest, "bad Sec-WebSocket-Key")
+	}
+	if u.CheckOrigin != nil && !u.CheckOrigin(req) {
+		return nil, refuse(w, response.StatusForbidden, "origin not allowed")
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", acceptKey(key))
+	subprotocol := u.pickSubprotocol(req)
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	conn, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(conn, bufio.NewReader(conn), true)
+	c.MaxMessageSize = u.MaxMessageSize
+	c.Subprotocol = subprotocol
+	return c, nil
+}
+
+func (u *Upgrader) pickSubprotocol(req *request.Request) string {
+	requested, _ := req.Headers.Get("Sec-WebSocket-Protocol")
+	for _, ours := range u.Subprotocols {
+		for _, theirs := range strings.Split(requested, ",") {
+			if strings.TrimSpace(theirs) == ours {
+				return ours
+			}
+		}
+	}
+	return ""
+}
+
+func refuse(w *response.Writer, code response.StatusCode, msg string) error {
+	w.WriteStatusLine(code)
+	h := response.GetDefaultHeaders(len(msg))
+	if code == response.StatusUpgradeRequired {
+		h.Set("Upgrade", "websocket")
+		h.Set("Sec-WebSocket-Version", "13")
+	}
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(msg))
+	return fmt.Errorf("websocket: %s", msg)
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Open a client connection to a ws:// or wss:// URL.
+func Dial(rawURL string, subprotocols ...string) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		conn, err = net.Dial("tcp", hostPort(u, "80"))
+	case "wss":
+		conn, err = tls.Dial("tcp", hostPort(u, "443"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("websocket: unsupported scheme: %s", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c, err := clientHandshake(conn, u, subprotocols)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func clientHandshake(conn net.Conn, u *url.URL, subprotocols []string) (*Conn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := client.NewRequest("GET", u, nil)
+	req.Headers.Set("Upgrade", "websocket")
+	req.Headers.Set("Connection", "Upgrade")
+	req.Headers.Set("Sec-WebSocket-Key", key)
+	req.Headers.Set("Sec-WebSocket-Version", "13")
+	if len(subprotocols) > 0 {
+		req.Headers.Set("Sec-WebSocket-Protocol", strings.Join(subprotocols, ", "))
+	}
+	if _, err := req.WriteTo(conn); err != nil {
+		return nil, err
+	}
+
+	head, body, err := response.ReadResponseHead(conn, "GET")
+	if err != nil {
+		return nil, err
+	}
+	if head.StatusCode != response.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("websocket: handshake refused: %d %s", head.StatusCode, head.Reason)
+	}
+	if accept, _ := head.Headers.Get("Sec-WebSocket-Accept"); accept != acceptKey(key) {
+		return nil, fmt.Errorf("websocket: bad Sec-WebSocket-Accept")
+	}
+	// the server may have sent frames right behind its response
+	reader := io.MultiReader(bytes.NewReader(body.Buffered()), conn)
+	c := newConn(conn, bufio.NewReader(reader), false)
+	c.Subprotocol, _ = head.Headers.Get("Sec-WebSocket-Protocol")
+	return c, nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,422 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Frame opcodes (RFC 6455 5.2). Text and Binary are the message types.
+type MessageType int
+
+const (
+	continuation  MessageType = 0
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+	CloseMessage  MessageType = 8
+	PingMessage   MessageType = 9
+	PongMessage   MessageType = 10
+)
+
+// Status codes for close frames (RFC 6455 7.4.1).
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005 // never sent, means the frame had no code
+	CloseAbnormal        = 1006 // never sent, means no close frame at all
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// Messages bigger than this are refused with CloseMessageTooBig when
+// Conn.MaxMessageSize is 0.
+const DefaultMaxMessageSize = 1 << 20
+
+// Control frames can't carry more than this (RFC 6455 5.5).
+const maxControlPayload = 125
+
+// How long Close waits for the peer's close frame.
+const closeTimeout = 5 * time.Second
+
+// What ReadMessage returns once the peer closed the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+var ErrClosed = errors.New("websocket: connection closed")
+
+// One side of a WebSocket connection. Reads happen on one goroutine,
+// writes may come from several.
+type Conn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	isServer bool
+	// 0 means DefaultMaxMessageSize
+	MaxMessageSize int64
+	// called for every pong, e.g. to push a read deadline forward
+	OnPong func(data []byte)
+	// the subprotocol both sides agreed on, "" for none
+	Subprotocol string
+
+	writeMutex sync.Mutex
+	closeSent  bool
+	// the peer's close, once we have seen it
+	closeErr *CloseError
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, isServer bool) *Conn {
+	return &Conn{conn: conn, reader: reader, isServer: isServer}
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+type frame struct {
+	fin     bool
+	opcode  MessageType
+	payload []byte
+}
+
+func isControl(opcode MessageType) bool {
+	return opcode >= CloseMessage
+}
+
+// Read one frame and unmask it. limit caps the payload we are willing to hold.
+func (c *Conn) readFrame(limit int64) (*frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return nil, err
+	}
+	f := &frame{
+		fin:    head[0]&0x80 != 0,
+		opcode: MessageType(head[0] & 0x0f),
+	}
+	// no extensions were negotiated, so no RSV bits either
+	if head[0]&0x70 != 0 {
+		return nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	switch f.opcode {
+	case continuation, TextMessage, BinaryMessage, CloseMessage, PingMessage, PongMessage:
+	default:
+		return nil, c.fail(CloseProtocolError, "unknown opcode")
+	}
+	masked := head[1]&0x80 != 0
+	// clients always mask, servers never do (RFC 6455 5.1)
+	if masked != c.isServer {
+		return nil, c.fail(CloseProtocolError, "wrong masking")
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return nil, c.fail(CloseProtocolError, "invalid length")
+		}
+	}
+	if isControl(f.opcode) && (!f.fin || length > maxControlPayload) {
+		return nil, c.fail(CloseProtocolError, "bad control frame")
+	}
+	if length > uint64(limit) {
+		return nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return nil, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, f.payload); err != nil {
+		return nil, err
+	}
+	if masked {
+		maskBytes(mask, f.payload)
+	}
+	return f, nil
+}
+
+func maskBytes(mask [4]byte, p []byte) {
+	for i := range p {
+		p[i] ^= mask[i%4]
+	}
+}
+
+// Send one frame. Client frames get a fresh random mask every time.
+func (c *Conn) writeFrame(fin bool, opcode MessageType, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+
+	buf := make([]byte, 0, 14+len(payload))
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	buf = append(buf, first)
+
+	var maskBit byte
+	if !c.isServer {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+
+	if c.isServer {
+		buf = append(buf, payload...)
+	} else {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		buf = append(buf, mask[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		maskBytes(mask, buf[start:])
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// Read the next whole message, putting fragments back together. Pings are
+// answered and pongs handed to OnPong on the way. Once the peer closes,
+// the close is echoed and a *CloseError comes back.
+// After any error the connection is closed.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	messageType, message, err := c.readMessage()
+	if err != nil {
+		c.conn.Close()
+	}
+	return messageType, message, err
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	if c.closeErr != nil {
+		return 0, nil, c.closeErr
+	}
+	limit := c.MaxMessageSize
+	if limit == 0 {
+		limit = DefaultMaxMessageSize
+	}
+
+	var messageType MessageType
+	var message []byte
+	for {
+		f, err := c.readFrame(max(limit-int64(len(message)), maxControlPayload))
+		if err != nil {
+			return 0, nil, err
+		}
+		switch f.opcode {
+		case PingMessage:
+			if err := c.writeFrame(true, PongMessage, f.payload); err != nil && err != ErrClosed {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			if c.OnPong != nil {
+				c.OnPong(f.payload)
+			}
+			continue
+		case CloseMessage:
+			return 0, nil, c.handleClose(f.payload)
+		case continuation:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "continuation without a message")
+			}
+		default:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "new message inside a fragmented one")
+			}
+			messageType = f.opcode
+		}
+		if int64(len(message)+len(f.payload)) > limit {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(CloseInvalidPayload, "text is not utf-8")
+		}
+		if message == nil {
+			message = []byte{}
+		}
+		return messageType, message, nil
+	}
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	if len(payload) == 1 {
+		return c.fail(CloseProtocolError, "bad close frame")
+	}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) || !utf8.Valid(payload[2:]) {
+			return c.fail(CloseProtocolError, "bad close frame")
+		}
+	}
+	c.closeErr = closeErr
+	// echo it back unless we started the close ourselves
+	code := closeErr.Code
+	if code == CloseNoStatus {
+		code = CloseNormal
+	}
+	c.writeFrame(true, CloseMessage, closePayload(code, ""))
+	// both closes are out, nothing more will come over it
+	c.conn.Close()
+	return closeErr
+}
+
+// Codes a peer may put on the wire (RFC 6455 7.4).
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1014:
+		return false
+	}
+	switch code {
+	case 1004, CloseNoStatus, CloseAbnormal:
+		return false
+	}
+	return true
+}
+
+func closePayload(code int, reason string) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return append(payload, reason...)
+}
+
+// Tell the peer what went wrong and hang up.
+func (c *Conn) fail(code int, reason string) error {
+	c.writeFrame(true, CloseMessage, closePayload(code, reason))
+	c.conn.Close()
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// Send data as a single frame.
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: not a data message type: %d", messageType)
+	}
+	return c.writeFrame(true, messageType, data)
+}
+
+// Send a message in pieces without knowing its size up front. Every Write
+// is one frame, Close sends the final one.
+func (c *Conn) NextWriter(messageType MessageType) (io.WriteCloser, error) {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return nil, fmt.Errorf("websocket: not a data message type: %d", messageType)
+	}
+	return &messageWriter{conn: c, opcode: messageType}, nil
+}
+
+type messageWriter struct {
+	conn   *Conn
+	opcode MessageType
+	closed bool
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.conn.writeFrame(false, w.opcode, p); err != nil {
+		return 0, err
+	}
+	// everything after the first frame is a continuation
+	w.opcode = continuation
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.conn.writeFrame(true, w.opcode, nil)
+}
+
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("websocket: ping payload over %d bytes", maxControlPayload)
+	}
+	return c.writeFrame(true, PingMessage, data)
+}
+
+// Start the close handshake: send our close frame, wait (a little) for the
+// peer's, then drop the connection. Data that arrives meanwhile is discarded.
+func (c *Conn) Close(code int, reason string) error {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	err := c.writeFrame(true, CloseMessage, closePayload(code, reason))
+	if err == ErrClosed {
+		// someone got here first, the connection is on its way out
+		c.conn.Close()
+		return nil
+	}
+	if err != nil {
+		c.conn.Close()
+		return err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	// ReadMessage closes the connection once the peer's close (or anything
+	// going wrong) comes in
+	for {
+		if _, _, err := c.ReadMessage(); err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WaronLimsakul/learn_http/internal/request"
+	"github.com/WaronLimsakul/learn_http/internal/response"
+	"github.com/WaronLimsakul/learn_http/internal/server"
+)
+
+// Serve an echo endpoint, send back the close error the server saw.
+func startEcho(t *testing.T, upgrader *Upgrader) (string, <-chan error) {
+	t.Helper()
+	closed := make(chan error, 1)
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		c, err := upgrader.Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		for {
+			messageType, data, err := c.ReadMessage()
+			if err != nil {
+				closed <- err
+				return
+			}
+			if err := c.WriteMessage(messageType, data); err != nil {
+				closed <- err
+				return
+			}
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	_, port, _ := net.SplitHostPort(srv.Addr().String())
+	return "ws://127.0.0.1:" + port + "/ws", closed
+}
+
+func TestEcho(t *testing.T) {
+	wsURL, closed := startEcho(t, &Upgrader{Subprotocols: []string{"chat.v2", "chat.v1"}})
+	c, err := Dial(wsURL, "chat.v1", "chat.v2")
+	require.NoError(t, err)
+	assert.Equal(t, "chat.v2", c.Subprotocol)
+
+	// Test: every length encoding, both message types
+	for _, size := range []int{0, 5, 125, 126, 200, 0xffff, 70000} {
+		data := bytes.Repeat([]byte("x"), size)
+		require.NoError(t, c.WriteMessage(BinaryMessage, data))
+		messageType, got, err := c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, BinaryMessage, messageType)
+		assert.Equal(t, data, got, "size %d", size)
+	}
+	require.NoError(t, c.WriteMessage(TextMessage, []byte("héllo")))
+	messageType, got, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, messageType)
+	assert.Equal(t, "héllo", string(got))
+
+	// Test: fragments come back as one message, pings get answered on the way
+	pongs := make(chan string, 1)
+	c.OnPong = func(data []byte) { pongs <- string(data) }
+	mw, err := c.NextWriter(TextMessage)
+	require.NoError(t, err)
+	mw.Write([]byte("one "))
+	require.NoError(t, c.Ping([]byte("are you there")))
+	mw.Write([]byte("two "))
+	mw.Write([]byte("three"))
+	require.NoError(t, mw.Close())
+	_, got, err = c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "one two three", string(got))
+	assert.Equal(t, "are you there", <-pongs)
+
+	// Test: close handshake both ways
+	require.NoError(t, c.Close(CloseNormal, "bye"))
+	select {
+	case err := <-closed:
+		assert.Equal(t, &CloseError{Code: CloseNormal, Reason: "bye"}, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("server never saw the close")
+	}
+	assert.ErrorIs(t, c.WriteMessage(TextMessage, []byte("late")), ErrClosed)
+}
+
+func TestHandshakeRefused(t *testing.T) {
+	wsURL, _ := startEcho(t, &Upgrader{
+		CheckOrigin: func(req *request.Request) bool {
+			origin, _ := req.Headers.Get("Origin")
+			return origin == "" || origin == "https://good.test"
+		},
+	})
+	addr := strings.TrimSuffix(strings.TrimPrefix(wsURL, "ws://"), "/ws")
+
+	send := func(raw string) *response.Response {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.Write([]byte(raw))
+		res, err := response.ResponseFromReader(conn, "GET")
+		require.NoError(t, err)
+		return res
+	}
+	handshake := "GET /ws HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+
+	// Test: the example from RFC 6455 1.3
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte(handshake + "\r\n"))
+	head, _, err := response.ReadResponseHead(conn, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusSwitchingProtocols, head.StatusCode)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", head.Headers["sec-websocket-accept"])
+
+	res := send("GET /ws HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, response.StatusUpgradeRequired, res.StatusCode)
+	assert.Equal(t, "13", res.Headers["sec-websocket-version"])
+
+	res = send(strings.Replace(handshake, "13", "8", 1) + "\r\n")
+	assert.Equal(t, response.StatusUpgradeRequired, res.StatusCode)
+
+	res = send(strings.Replace(handshake, "dGhlIHNhbXBsZSBub25jZQ==", "short", 1) + "\r\n")
+	assert.Equal(t, response.StatusBadRequest, res.StatusCode)
+
+	res = send(handshake + "Origin: https://evil.test\r\n\r\n")
+	assert.Equal(t, response.StatusForbidden, res.StatusCode)
+}
+
+// Build a raw client frame with a fixed mask.
+func clientFrame(first byte, payload []byte) []byte {
+	mask := [4]byte{1, 2, 3, 4}
+	out := []byte{first}
+	switch {
+	case len(payload) <= 125:
+		out = append(out, 0x80|byte(len(payload)))
+	default:
+		out = append(out, 0x80|126)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(payload)))
+	}
+	out = append(out, mask[:]...)
+	masked := append([]byte{}, payload...)
+	maskBytes(mask, masked)
+	return append(out, masked...)
+}
+
+func TestProtocolErrors(t *testing.T) {
+	cases := map[string]struct {
+		input []byte
+		code  int
+	}{
+		"unmasked":          {[]byte{0x81, 0x02, 'h', 'i'}, CloseProtocolError},
+		"reserved bits":     {clientFrame(0xC1, []byte("hi")), CloseProtocolError},
+		"unknown opcode":    {clientFrame(0x83, nil), CloseProtocolError},
+		"fragmented ping":   {clientFrame(0x09, nil), CloseProtocolError},
+		"lone continuation": {clientFrame(0x80, []byte("x")), CloseProtocolError},
+		"bad utf-8":         {clientFrame(0x81, []byte{0xff, 0xfe}), CloseInvalidPayload},
+		"too big":           {clientFrame(0x82, make([]byte, 200)), CloseMessageTooBig},
+		"interleaved": {
+			append(clientFrame(0x01, []byte("a")), clientFrame(0x81, []byte("b"))...),
+			CloseProtocolError,
+		},
+		"bad close code": {clientFrame(0x88, closePayload(999, "")), CloseProtocolError},
+	}
+	for name, tc := range cases {
+		serverSide, clientSide := net.Pipe()
+		c := newConn(serverSide, bufio.NewReader(serverSide), true)
+		c.MaxMessageSize = 100
+
+		sent := make(chan []byte, 1)
+		go func() {
+			clientSide.Write(tc.input)
+		}()
+		go func() {
+			out, _ := io.ReadAll(clientSide)
+			sent <- out
+		}()
+
+		_, _, err := c.ReadMessage()
+		var closeErr *CloseError
+		require.ErrorAs(t, err, &closeErr, name)
+		assert.Equal(t, tc.code, closeErr.Code, name)
+
+		// the peer is told why before we hang up
+		out := <-sent
+		require.GreaterOrEqual(t, len(out), 4, name)
+		assert.Equal(t, byte(0x88), out[0], name)
+		assert.Equal(t, tc.code, int(binary.BigEndian.Uint16(out[2:4])), name)
+	}
+}